@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+
+	"auth/internal/api/handlers"
+	"auth/internal/api/inmiddlewares"
+	"auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiPath is the path the REST API is served under. Every version of the API
+// has a prefix of its own below it, the routes without a version are
+// deprecated aliases of the first version.
+const apiPath = "/api/auth"
+
+// apiV1Path is the path the first version of the REST API is served under.
+const apiV1Path = apiPath + "/v1"
+
+// legacyAPIDeprecation is when the routes without a version were deprecated.
+var legacyAPIDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// apiV1 is the handler set of the first version of the REST API. A new
+// version gets a handler set of its own, registered next to this one by
+// setupRouter, so that the responses of a version don't change under the
+// clients using it.
+type apiV1 struct {
+	sessionService    *services.SessionService
+	authService       *services.AuthService
+	deviceService     *services.DeviceService
+	oidcService       *services.OIDCService
+	federationService *services.FederationService
+	oauthClients      services.IClientAuthenticator
+}
+
+// register adds the routes of the API to group. The paths handlers redirect
+// to and scope cookies to are built from the path of the group, so that the
+// API may be served under several prefixes.
+func (api *apiV1) register(group *gin.RouterGroup) {
+	basePath := group.BasePath()
+	rtPath := basePath + "/token/"
+
+	authHandlers := handlers.NewAuthHandlers(
+		api.authService,
+		api.sessionService,
+	)
+
+	group.POST("code/generate/", authHandlers.GenerateEmailCodeHandler)
+	group.GET("code/:id/delivery/", authHandlers.GetEmailCodeDeliveryHandler)
+	group.POST("code/check/", authHandlers.NewCheckEmailCodeHandler(rtPath))
+	group.POST("token/", authHandlers.NewRefreshTokenHandler(rtPath))
+	group.DELETE("token/", authHandlers.NewDeleteCurrentSession(rtPath))
+
+	oauthHandlers := handlers.NewOAuthHandlers(api.sessionService, api.deviceService, api.oidcService)
+	group.POST("oauth/device_authorization", oauthHandlers.DeviceAuthorizationHandler)
+	group.POST("oauth/token", oauthHandlers.TokenHandler)
+	oauthGroup := group.Group("oauth", inmiddlewares.NewClientAuthMiddleware(api.oauthClients))
+	oauthGroup.POST("introspect", oauthHandlers.IntrospectHandler)
+	oauthGroup.POST("revoke", oauthHandlers.RevokeHandler)
+
+	federationHandlers := api.federationHandlers(basePath)
+	if federationHandlers != nil {
+		group.GET("federation/providers/", federationHandlers.ProvidersHandler)
+		group.GET("federation/:provider/login", federationHandlers.LoginHandler)
+		group.GET("federation/:provider/callback", federationHandlers.CallbackHandler)
+	}
+
+	if api.oidcService != nil {
+		oidcHandlers := api.oidcHandlers(basePath, federationHandlers)
+		group.GET("oauth/jwks", oidcHandlers.JWKSHandler)
+		group.GET("oauth/authorize", oidcHandlers.AuthorizeHandler)
+		group.POST("oauth/authorize", oidcHandlers.AuthorizeHandler)
+		group.POST("oauth/authorize/login/email", oidcHandlers.LoginEmailHandler)
+		group.POST("oauth/authorize/login/code", oidcHandlers.LoginCodeHandler)
+		group.POST("oauth/authorize/consent", oidcHandlers.ConsentHandler)
+		group.GET("oauth/userinfo", oidcHandlers.UserInfoHandler)
+		group.POST("oauth/userinfo", oidcHandlers.UserInfoHandler)
+	}
+
+	authenticatedGroup := group.Group("/", inmiddlewares.NewAuthMiddleware(api.sessionService))
+	authenticatedGroup.GET("/sessions/", authHandlers.GetUserSessionsHandler)
+	authenticatedGroup.DELETE("/sessions/:id/", authHandlers.DeleteSession)
+
+	deviceHandlers := handlers.NewDeviceHandlers(api.deviceService)
+	authenticatedGroup.GET("/device/:user_code/", deviceHandlers.GetDeviceAuthorizationHandler)
+	authenticatedGroup.POST("/device/", deviceHandlers.DecideDeviceAuthorizationHandler)
+	if federationHandlers != nil {
+		authenticatedGroup.GET("/identities/", federationHandlers.GetIdentitiesHandler)
+	}
+}
+
+// federationHandlers returns the handlers of logins with upstream providers
+// served under basePath, nil when federation is disabled. The callback URLs
+// registered at the providers depend on basePath.
+func (api *apiV1) federationHandlers(basePath string) *handlers.FederationHandlers {
+	if api.federationService == nil {
+		return nil
+	}
+	providerPath := ""
+	if api.oidcService != nil {
+		providerPath = basePath + "/oauth"
+	}
+	return handlers.NewFederationHandlers(
+		api.federationService,
+		api.sessionService,
+		basePath+"/federation",
+		basePath+"/token/",
+		providerPath,
+	)
+}
+
+// oidcHandlers returns the handlers of the OpenID Connect provider served
+// under basePath.
+func (api *apiV1) oidcHandlers(basePath string, federationHandlers *handlers.FederationHandlers) *handlers.OIDCHandlers {
+	return handlers.NewOIDCHandlers(api.oidcService, api.sessionService, api.authService, federationHandlers, basePath+"/oauth")
+}
+
+// discoveryHandler serves the provider metadata, which points at the
+// endpoints served under basePath.
+func (api *apiV1) discoveryHandler(basePath string) gin.HandlerFunc {
+	return api.oidcHandlers(basePath, api.federationHandlers(basePath)).DiscoveryHandler
+}
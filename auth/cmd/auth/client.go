@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/services"
+)
+
+const clientUsage = `usage:
+  auth client create -name NAME -redirect-uri URI [-redirect-uri URI...] [-public]
+  auth client list
+  auth client delete CLIENT_ID`
+
+// stringsFlag collects the values of a flag given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runClient manages the clients of the OpenID Connect provider.
+func runClient(ctx context.Context, cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, clientUsage)
+		return 2
+	}
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+	// Managing clients neither issues nor validates tokens.
+	oidcService := services.NewOIDCService(services.OIDCSettings{}, store, nil, nil)
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("client create", flag.ContinueOnError)
+		name := flags.String("name", "", "name shown to users on the consent page")
+		public := flags.Bool("public", false, "create a public client without a secret, e.g. a single page app")
+		var redirectURIs stringsFlag
+		flags.Var(&redirectURIs, "redirect-uri", "allowed redirect URI, can be given several times")
+		if err := flags.Parse(args[1:]); err != nil || *name == "" {
+			fmt.Fprintln(os.Stderr, clientUsage)
+			return 2
+		}
+		client, secret, err := oidcService.CreateClient(ctx, *name, redirectURIs, *public)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("client_id:     %s\n", client.ID)
+		if secret != "" {
+			fmt.Printf("client_secret: %s\n", secret)
+			fmt.Println("The secret is not stored and can't be shown again.")
+		}
+	case "list":
+		clients, err := oidcService.GetClients(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CLIENT ID\tNAME\tTYPE\tCREATED AT\tREDIRECT URIS")
+		for _, client := range clients {
+			clientType := "confidential"
+			if client.IsPublic() {
+				clientType = "public"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", client.ID, client.Name, clientType, client.CreatedAt.Format(time.RFC3339), strings.Join(client.RedirectURIs, " "))
+		}
+		w.Flush()
+	case "delete":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, clientUsage)
+			return 2
+		}
+		if err := oidcService.DeleteClient(ctx, args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, clientUsage)
+		return 2
+	}
+	return 0
+}
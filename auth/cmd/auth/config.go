@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"auth/internal/config"
+)
+
+const configUsage = "usage: auth [flags] config print [-format yaml|env]"
+
+// runConfig prints the effective configuration with the secrets redacted,
+// followed by the problems found by the validation.
+func runConfig(cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	format := flags.String("format", "yaml", "output format, yaml or env")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	if err := cfg.Print(os.Stdout, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
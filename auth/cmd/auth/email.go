@@ -0,0 +1,38 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/pkg/emailsender"
+)
+
+// newEmailSender creates the configured providers. The mailbox, which is
+// only set in development, is the one of the "mailbox" provider.
+func newEmailSender(cfg *config.Config, mailbox *emailsender.Mailbox) (emailsender.IEmailSender, error) {
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = cfg.SMTPUsername
+	}
+	return emailsender.NewFromNames(cfg.EmailProviderNames(), emailsender.Settings{
+		SMTP: emailsender.SMTPSettings{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     from,
+			FromName: cfg.SMTPFromName,
+			TLSMode:  cfg.SMTPTLSMode,
+			Timeout:  cfg.SMTPTimeout,
+		},
+		HTTP: emailsender.HTTPSettings{
+			URL:      cfg.EmailAPIURL,
+			APIKey:   cfg.EmailAPIKey,
+			From:     from,
+			FromName: cfg.SMTPFromName,
+			Timeout:  cfg.EmailAPITimeout,
+		},
+		MaildirDir: cfg.EmailMaildir,
+		Mailbox:    mailbox,
+		From:       from,
+		FromName:   cfg.SMTPFromName,
+	})
+}
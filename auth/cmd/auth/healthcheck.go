@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"auth/internal/config"
+)
+
+// runHealthcheck probes the readiness endpoint of a locally running server.
+// It is used as the container healthcheck since the runtime image has no curl.
+func runHealthcheck(cfg *config.Config) int {
+	_, port, err := net.SplitHostPort(cfg.ServerAddress)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%s/readyz", port))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "readiness probe returned %d\n", resp.StatusCode)
+		return 1
+	}
+	return 0
+}
@@ -2,84 +2,390 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"auth/internal/api/handlers"
 	"auth/internal/api/inmiddlewares"
 	"auth/internal/config"
+	"auth/internal/emailtemplates"
+	"auth/internal/federation"
 	"auth/internal/grpcserver"
+	"auth/internal/health"
+	"auth/internal/logger"
+	"auth/internal/metrics"
 	"auth/internal/services"
-	"auth/internal/storage/psql"
+	"auth/internal/tracing"
+	"auth/openapi"
 	"auth/pkg/emailsender"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func setupRouter(
+	serviceName string,
 	sessionService *services.SessionService,
 	authService *services.AuthService,
+	deviceService *services.DeviceService,
+	oidcService *services.OIDCService,
+	federationService *services.FederationService,
+	checker *health.Checker,
+	oauthClients services.IClientAuthenticator,
+	mailbox *emailsender.Mailbox,
+	openAPIDocument []byte,
+	legacyAPISunset time.Time,
 ) *gin.Engine {
-	router := gin.Default()
-	rootGroup := router.Group("api/auth")
-
-	authHandlers := handlers.NewAuthHandlers(
-		authService,
-		sessionService,
+	router := gin.New()
+	router.NoRoute(handlers.NotFoundHandler)
+	router.Use(
+		gin.CustomRecovery(handlers.RecoveryHandler),
+		otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/metrics" && r.URL.Path != "/healthz" && r.URL.Path != "/readyz"
+		})),
+		inmiddlewares.NewRequestIDMiddleware(),
+		inmiddlewares.NewLoggingMiddleware(),
+		metrics.NewGinMiddleware(),
 	)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	healthHandlers := handlers.NewHealthHandlers(checker)
+	router.GET("/healthz", healthHandlers.LivenessHandler)
+	router.GET("/readyz", healthHandlers.ReadinessHandler)
+
+	if mailbox != nil {
+		mailboxHandlers := handlers.NewMailboxHandlers(mailbox)
+		router.GET("/dev/mailbox", mailboxHandlers.ListHandler)
+		router.DELETE("/dev/mailbox", mailboxHandlers.ClearHandler)
+		router.GET("/dev/mailbox/latest-code", mailboxHandlers.LatestCodeHandler)
+		router.GET("/dev/mailbox/:id", mailboxHandlers.GetHandler)
+	}
 
-	rootGroup.POST("code/generate/", authHandlers.GenerateEmailCodeHandler)
-	rootGroup.POST("code/check/", authHandlers.NewCheckEmailCodeHandler("/api/auth/token/"))
-	rootGroup.POST("token/", authHandlers.NewRefreshTokenHandler("/api/auth/token/"))
-	rootGroup.DELETE("token/", authHandlers.NewDeleteCurrentSession("/api/auth/token/"))
+	openAPIHandlers := handlers.NewOpenAPIHandlers(openAPIDocument)
+	router.GET(apiPath+"/openapi.json", openAPIHandlers.DocumentHandler)
 
-	authenticatedGroup := rootGroup.Group("/", inmiddlewares.NewAuthMiddleware(sessionService))
-	authenticatedGroup.GET("/sessions/", authHandlers.GetUserSessionsHandler)
-	authenticatedGroup.DELETE("/sessions/:id/", authHandlers.DeleteSession)
+	v1 := &apiV1{
+		sessionService:    sessionService,
+		authService:       authService,
+		deviceService:     deviceService,
+		oidcService:       oidcService,
+		federationService: federationService,
+		oauthClients:      oauthClients,
+	}
+	v1.register(router.Group(apiV1Path))
+	// The routes without a version were served before the API was versioned
+	// and are kept until the sunset for the clients already shipped.
+	v1.register(router.Group(
+		apiPath,
+		inmiddlewares.NewDeprecationMiddleware(legacyAPIDeprecation, legacyAPISunset, apiPath, apiV1Path),
+	))
+	if oidcService != nil {
+		router.GET(discoveryPath(oidcService.IssuerURL), v1.discoveryHandler(apiV1Path))
+	}
 	return router
 }
 
+// discoveryPath returns the path of the provider metadata, which is relative to the issuer.
+func discoveryPath(issuerURL string) string {
+	path := ""
+	if u, err := url.Parse(issuerURL); err == nil {
+		path = strings.TrimSuffix(u.Path, "/")
+	}
+	return path + "/.well-known/openid-configuration"
+}
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	cfg := config.MustLoad()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(runConfig(cfg, args[1:]))
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	oauthClients, err := services.ParseStaticClients(cfg.OAuthClients)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid OAUTH_CLIENTS: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "healthcheck":
+			os.Exit(runHealthcheck(cfg))
+		case "migrate":
+			os.Exit(runMigrate(ctx, cfg, args[1:]))
+		case "client":
+			os.Exit(runClient(ctx, cfg, args[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+			os.Exit(2)
+		}
+	}
+
+	slog.SetDefault(logger.New(cfg.IsDev, cfg.LogLevel))
+
+	shutdownTracing, err := tracing.Setup(
+		ctx,
+		cfg.ProjectName,
+		cfg.TracingExporter,
+		cfg.TracingOTLPEndpoint,
+		cfg.TracingOTLPInsecure,
+		cfg.TracingSampleRatio,
+	)
+	if err != nil {
+		slog.Error("failed to init tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("failed to shutdown tracing", "error", err)
+		}
+	}()
 
 	if !cfg.IsDev {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	psqlStorage, err := psql.New(
-		ctx,
-		cfg.PSQLHost,
-		cfg.PSQLPort,
-		cfg.PSQLUsername,
-		cfg.PSQLPassword,
-		cfg.PSQLDBName,
-	)
+	checker := health.New(cfg.ReadinessTimeout)
+
+	store, err := newStorage(ctx, cfg, checker)
 	if err != nil {
-		panic(err)
+		slog.Error("failed to init storage", "driver", cfg.StorageDriver, "error", err)
+		os.Exit(1)
 	}
-	defer psqlStorage.Close()
+	defer store.Close()
 
-	var emailSender emailsender.IEmailSender
+	var mailbox *emailsender.Mailbox
 	if cfg.IsDev {
-		emailSender = emailsender.NewMock()
-	} else {
-		emailSender = emailsender.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword)
+		mailbox = emailsender.NewMailbox(cfg.DevMailboxSize)
+	}
+	emailSender, err := newEmailSender(cfg, mailbox)
+	if err != nil {
+		slog.Error("failed to init email sender", "error", err)
+		os.Exit(1)
 	}
 
-	sessionService := services.NewSessionService(cfg.JWTSecretKey, cfg.JWTAccessExp, cfg.JWTRefreshExp, psqlStorage)
-	authService := services.NewAuthService(psqlStorage, emailSender)
+	sessionService := services.NewSessionService(tokenSettings(cfg), store)
+	emailTemplates, err := emailtemplates.New(
+		cfg.EmailTemplatesDir,
+		cfg.EmailDefaultLocale,
+		emailtemplates.Branding{
+			ProjectName:  cfg.ProjectName,
+			LogoURL:      cfg.EmailLogoURL,
+			SupportEmail: cfg.EmailSupportAddress,
+			PrimaryColor: cfg.EmailPrimaryColor,
+			PublicURL:    cfg.PublicURL,
+		},
+	)
+	if err != nil {
+		slog.Error("failed to load email templates", "error", err)
+		os.Exit(1)
+	}
+	outboxService := services.NewOutboxService(outboxSettings(cfg), store, emailSender)
+	if cfg.ReadinessCheckSMTP {
+		checker.Add("email", func(ctx context.Context) error {
+			if pinger, ok := outboxService.EmailSender().(interface{ Ping(context.Context) error }); ok {
+				return pinger.Ping(ctx)
+			}
+			return nil
+		})
+	}
+	authService := services.NewAuthService(store, outboxService, emailTemplates)
+	deviceService := services.NewDeviceService(
+		services.DeviceSettings{
+			ClientIDs:       cfg.DeviceClientIDs,
+			VerificationURI: cfg.DeviceVerificationURI,
+			CodeExp:         cfg.DeviceCodeExp,
+			PollInterval:    cfg.DevicePollInterval,
+		},
+		store,
+		sessionService,
+	)
+	outboxService.OnCleanup(deviceService.DeleteExpiredCodes)
+	checker.Add("signing_key", sessionService.CheckSigningKey)
+
+	var oidcService *services.OIDCService
+	if cfg.OIDCEnabled {
+		signingKey, err := loadSigningKey(cfg)
+		if err != nil {
+			slog.Error("failed to load OIDC signing key", "error", err)
+			os.Exit(1)
+		}
+		oidcService = services.NewOIDCService(
+			services.OIDCSettings{
+				IssuerURL:  cfg.OIDCIssuerURL,
+				CodeExp:    cfg.OIDCCodeExp,
+				IDTokenExp: cfg.OIDCIDTokenExp,
+			},
+			store,
+			sessionService,
+			signingKey,
+		)
+	}
+
+	var federationService *services.FederationService
+	if cfg.UpstreamProvidersFile != "" {
+		providers, err := federation.LoadProviders(cfg.UpstreamProvidersFile)
+		if err != nil {
+			slog.Error("failed to load upstream providers", "error", err)
+			os.Exit(1)
+		}
+		federationService = services.NewFederationService(
+			services.FederationSettings{
+				PublicURL:      cfg.PublicURL,
+				AllowedOrigins: cfg.FederationAllowedOrigins,
+				LoginExp:       cfg.FederationLoginExp,
+			},
+			providers,
+			store,
+			authService,
+			sessionService,
+		)
+	}
 
 	gprcAuthServer := grpcserver.NewAuthGRPCServer(cfg.GPRCServerAddress, sessionService)
-	go gprcAuthServer.Run()
+	go func() {
+		if err := gprcAuthServer.Run(); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+			cancel()
+		}
+	}()
+
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxDone := make(chan struct{})
+	go func() {
+		defer close(outboxDone)
+		outboxService.Run(outboxCtx)
+	}()
 
-	r := setupRouter(sessionService, authService)
-	go r.Run(cfg.ServerAddress)
+	openAPIDocument, err := openapi.JSON()
+	if err != nil {
+		slog.Error("failed to load OpenAPI document", "error", err)
+		os.Exit(1)
+	}
+
+	// LEGACY_API_SUNSET has been checked by Validate.
+	legacyAPISunset, _ := time.Parse(time.DateOnly, cfg.LegacyAPISunset)
+
+	httpServer := &http.Server{
+		Addr: cfg.ServerAddress,
+		Handler: setupRouter(
+			cfg.ProjectName,
+			sessionService,
+			authService,
+			deviceService,
+			oidcService,
+			federationService,
+			checker,
+			oauthClients,
+			mailbox,
+			openAPIDocument,
+			legacyAPISunset,
+		),
+	}
+	go func() {
+		slog.Info("HTTP server is running", "addr", cfg.ServerAddress)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "error", err)
+			cancel()
+		}
+	}()
+
+	reloader := newReloader(os.Args[1:], cfg, mailbox, sessionService, outboxService, oidcService)
+	go reloader.Watch(ctx)
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
+	for waiting := true; waiting; {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				reloader.Reload(ctx)
+				continue
+			}
+			slog.Info("shutting down", "signal", sig.String())
+		case <-ctx.Done():
+			slog.Info("shutting down after server failure")
+		}
+		waiting = false
+	}
+
+	// Report not ready first so that load balancers stop routing new requests
+	// while in-flight ones are still being served.
+	checker.SetShuttingDown()
+	gprcAuthServer.SetNotServing()
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shutdown HTTP server", "error", err)
+	}
+	gprcAuthServer.Shutdown(shutdownCtx)
+
+	// Emails left in the outbox are sent after the restart.
+	stopOutbox()
+	select {
+	case <-outboxDone:
+	case <-shutdownCtx.Done():
+		slog.Error("failed to stop outbox workers in time")
+	}
+}
+
+func tokenSettings(cfg *config.Config) services.TokenSettings {
+	return services.TokenSettings{
+		SecretKey:          cfg.JWTSecretKey,
+		PreviousSecretKeys: cfg.JWTPreviousSecretKeys,
+		AccessExp:          cfg.JWTAccessExp,
+		RefreshExp:         cfg.JWTRefreshExp,
+		RefreshTokenFormat: cfg.RefreshTokenFormat,
+		Issuer:             cfg.JWTIssuer,
+		Audience:           cfg.JWTAudience,
+		Audiences:          cfg.JWTAudiences,
+		Leeway:             cfg.JWTLeeway,
+	}
+}
+
+func outboxSettings(cfg *config.Config) services.OutboxSettings {
+	return services.OutboxSettings{
+		Workers:      cfg.OutboxWorkers,
+		PollInterval: cfg.OutboxPollInterval,
+		MaxAttempts:  cfg.OutboxMaxAttempts,
+		BackoffBase:  cfg.OutboxBackoffBase,
+		BackoffMax:   cfg.OutboxBackoffMax,
+		Lease:        cfg.OutboxLease,
+		Retention:    cfg.OutboxRetention,
+	}
+}
+
+func loadSigningKey(cfg *config.Config) (*services.SigningKey, error) {
+	if cfg.OIDCSigningKeyFile != "" {
+		return services.LoadSigningKey(cfg.OIDCSigningKeyFile)
+	}
+	if !cfg.IsDev {
+		return nil, errors.New("OIDC_SIGNING_KEY_FILE is required")
+	}
+	slog.Warn("OIDC_SIGNING_KEY_FILE is not set, ID tokens are signed with a temporary key")
+	return services.GenerateSigningKey()
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/storage"
+
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = "usage: auth migrate up|down|status|redo"
+
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+	m, ok := store.(storage.Migratable)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "storage driver %q has no migrations\n", cfg.StorageDriver)
+		return 1
+	}
+	migrations := m.Migrations()
+
+	var results []*goose.MigrationResult
+	switch args[0] {
+	case "up":
+		results, err = migrations.Up(ctx)
+	case "down":
+		var result *goose.MigrationResult
+		result, err = migrations.Down(ctx)
+		if result != nil {
+			results = append(results, result)
+		}
+	case "redo":
+		results, err = migrations.Redo(ctx)
+	case "status":
+		var statuses []*goose.MigrationStatus
+		statuses, err = migrations.Status(ctx)
+		printMigrationStatuses(statuses)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatuses(statuses []*goose.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tSOURCE")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if !status.AppliedAt.IsZero() {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		source := status.Source.Path
+		if status.Source.Type == goose.TypeGo {
+			source = "(go migration)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, source)
+	}
+	w.Flush()
+}
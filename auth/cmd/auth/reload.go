@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/logger"
+	"auth/internal/services"
+	"auth/pkg/emailsender"
+)
+
+// reloadable lists the settings applied without a restart when the
+// configuration is reloaded.
+var reloadable = []string{
+	"LOG_LEVEL",
+	"JWT_SECRET_KEY",
+	"JWT_PREVIOUS_SECRET_KEYS",
+	"JWT_ACCESS_EXP",
+	"JWT_REFRESH_EXP",
+	"JWT_ISSUER",
+	"JWT_AUDIENCE",
+	"JWT_AUDIENCES",
+	"JWT_LEEWAY",
+	"REFRESH_TOKEN_FORMAT",
+	"OIDC_SIGNING_KEY_FILE",
+	"EMAIL_PROVIDERS",
+	"SMTP_HOST",
+	"SMTP_PORT",
+	"SMTP_USERNAME",
+	"SMTP_PASSWORD",
+	"SMTP_FROM",
+	"SMTP_FROM_NAME",
+	"SMTP_TLS_MODE",
+	"SMTP_TIMEOUT",
+	"EMAIL_API_URL",
+	"EMAIL_API_KEY",
+	"EMAIL_API_TIMEOUT",
+	"EMAIL_MAILDIR",
+	"OUTBOX_POLL_INTERVAL",
+	"OUTBOX_MAX_ATTEMPTS",
+	"OUTBOX_BACKOFF_BASE",
+	"OUTBOX_BACKOFF_MAX",
+	"OUTBOX_LEASE",
+	"OUTBOX_RETENTION",
+}
+
+// reloader reloads the configuration on SIGHUP or when the files it has been
+// read from change, and swaps the signing keys, the email sender and the
+// token and outbox settings. A configuration that fails to load or validate
+// is rejected as a whole and the previous one is kept. The settings which
+// are not reloadable keep their values until the service is restarted.
+type reloader struct {
+	mu sync.Mutex
+	// args are the command line arguments the configuration is loaded from.
+	args           []string
+	cfg            *config.Config
+	mailbox        *emailsender.Mailbox
+	sessionService *services.SessionService
+	outboxService  *services.OutboxService
+	oidcService    *services.OIDCService
+	// modTimes are the modification times of the watched files when the
+	// configuration has been loaded.
+	modTimes map[string]time.Time
+}
+
+func newReloader(
+	args []string,
+	cfg *config.Config,
+	mailbox *emailsender.Mailbox,
+	sessionService *services.SessionService,
+	outboxService *services.OutboxService,
+	oidcService *services.OIDCService,
+) *reloader {
+	r := &reloader{
+		args:           args,
+		cfg:            cfg,
+		mailbox:        mailbox,
+		sessionService: sessionService,
+		outboxService:  outboxService,
+		oidcService:    oidcService,
+	}
+	r.modTimes = statFiles(r.watchedFiles(cfg))
+	return r
+}
+
+// Watch reloads the configuration whenever a watched file changes, until ctx
+// is done.
+func (r *reloader) Watch(ctx context.Context) {
+	if r.cfg.ConfigWatchInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.ConfigWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			changed := !maps.Equal(r.modTimes, statFiles(slices.Collect(maps.Keys(r.modTimes))))
+			r.mu.Unlock()
+			if changed {
+				slog.InfoContext(ctx, "configuration files changed")
+				r.Reload(ctx)
+			}
+		}
+	}
+}
+
+// Reload loads the configuration again and applies it, errors are logged.
+func (r *reloader) Reload(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.reload(); err != nil {
+		slog.ErrorContext(ctx, "failed to reload configuration, keeping the previous one", "error", err)
+	}
+}
+
+func (r *reloader) reload() error {
+	// An invalid configuration is only loaded again at the next change.
+	r.modTimes = statFiles(slices.Collect(maps.Keys(r.modTimes)))
+	cfg, _, err := config.Load(r.args)
+	if err != nil {
+		return err
+	}
+	r.modTimes = statFiles(r.watchedFiles(cfg))
+	// The running values of the other settings are validated and compared
+	// at the next reload, the restart is asked for until it happens.
+	restart := cfg.Retain(r.cfg, reloadable)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Everything is built before anything is swapped, so that a failure
+	// leaves the running configuration untouched.
+	emailSender, err := newEmailSender(cfg, r.mailbox)
+	if err != nil {
+		return fmt.Errorf("failed to init email sender: %w", err)
+	}
+	var signingKey *services.SigningKey
+	if r.oidcService != nil && cfg.OIDCSigningKeyFile != "" {
+		signingKey, err = services.LoadSigningKey(cfg.OIDCSigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load OIDC signing key: %w", err)
+		}
+	}
+
+	changed := r.cfg.Changed(cfg)
+	slog.SetDefault(logger.New(cfg.IsDev, cfg.LogLevel))
+	r.sessionService.UpdateSettings(tokenSettings(cfg))
+	r.outboxService.UpdateSettings(outboxSettings(cfg))
+	r.outboxService.UpdateEmailSender(emailSender)
+	if signingKey != nil {
+		r.oidcService.SetSigningKey(signingKey)
+	}
+	r.cfg = cfg
+
+	if len(restart) > 0 {
+		slog.Warn("configuration changes require a restart to take effect", "settings", restart)
+	}
+	slog.Info("configuration reloaded", "changed", changed)
+	return nil
+}
+
+// watchedFiles returns the files the configuration has been read from and
+// the OIDC signing key.
+func (r *reloader) watchedFiles(cfg *config.Config) []string {
+	files := cfg.Files()
+	if r.oidcService != nil && cfg.OIDCSigningKeyFile != "" {
+		files = append(slices.Clone(files), cfg.OIDCSigningKeyFile)
+	}
+	return files
+}
+
+// statFiles returns the modification times of the files, zero for the files
+// that can't be read, so that they are reloaded once they are back.
+func statFiles(files []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		var modTime time.Time
+		if info, err := os.Stat(file); err == nil {
+			modTime = info.ModTime()
+		}
+		modTimes[file] = modTime
+	}
+	return modTimes
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/emailsender"
+)
+
+type reloadTest struct {
+	dir            string
+	reloader       *reloader
+	sessionService *services.SessionService
+	oidcService    *services.OIDCService
+}
+
+// newReloadTest starts the services from testConfig, written to a temporary
+// directory along with a new signing key.
+func newReloadTest(t *testing.T) *reloadTest {
+	t.Helper()
+	rt := &reloadTest{dir: t.TempDir()}
+	rt.writeKey(t)
+	rt.write(t, "config.yaml", rt.testConfig(nil))
+	args := []string{"-config", filepath.Join(rt.dir, "config.yaml")}
+	cfg, _, err := config.Load(args)
+	if err != nil {
+		t.Fatalf("failed to load the configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+
+	store := memory.New()
+	mailbox := emailsender.NewMailbox(cfg.DevMailboxSize)
+	emailSender, err := newEmailSender(cfg, mailbox)
+	if err != nil {
+		t.Fatalf("failed to create the email sender: %v", err)
+	}
+	signingKey, err := loadSigningKey(cfg)
+	if err != nil {
+		t.Fatalf("failed to load the signing key: %v", err)
+	}
+	rt.sessionService = services.NewSessionService(tokenSettings(cfg), store)
+	rt.oidcService = services.NewOIDCService(services.OIDCSettings{IssuerURL: cfg.OIDCIssuerURL}, store, rt.sessionService, signingKey)
+	outboxService := services.NewOutboxService(outboxSettings(cfg), store, emailSender)
+	rt.reloader = newReloader(args, cfg, mailbox, rt.sessionService, outboxService, rt.oidcService)
+	return rt
+}
+
+// testConfig is the configuration of the tests, with the given settings
+// replacing the defaults of the test, as keys can't be repeated.
+func (rt *reloadTest) testConfig(settings map[string]string) string {
+	values := map[string]string{
+		"is_dev":                "true",
+		"storage_driver":        "memory",
+		"server_address":        "127.0.0.1:8080",
+		"oidc_enabled":          "true",
+		"oidc_signing_key_file": filepath.Join(rt.dir, "key.pem"),
+		"email_providers":       "mailbox",
+		"jwt_secret_key":        "reload-test-secret-key",
+		"jwt_access_exp":        "5m",
+	}
+	maps.Copy(values, settings)
+	var b strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(&b, "%s: %q\n", key, values[key])
+	}
+	return b.String()
+}
+
+func (rt *reloadTest) write(t *testing.T, name string, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(rt.dir, name), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (rt *reloadTest) writeKey(t *testing.T) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	rt.write(t, "key.pem", string(pem.EncodeToMemory(block)))
+}
+
+func TestReloadRejected(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		settings map[string]string
+		key      string
+	}{
+		{
+			name:     "unparsable value",
+			settings: map[string]string{"jwt_access_exp": "soon"},
+		},
+		{
+			name:     "invalid value",
+			settings: map[string]string{"log_level": "loud", "jwt_secret_key": "another-secret-key", "jwt_access_exp": "1m"},
+		},
+		{
+			name:     "invalid signing key",
+			settings: map[string]string{"jwt_secret_key": "another-secret-key", "jwt_access_exp": "1m"},
+			key:      "not a key",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReloadTest(t)
+			settings := *rt.sessionService.Settings()
+			signingKey := rt.oidcService.SigningKey()
+
+			rt.write(t, "config.yaml", rt.testConfig(tt.settings))
+			if tt.key != "" {
+				rt.write(t, "key.pem", tt.key)
+			} else {
+				rt.writeKey(t)
+			}
+			if err := rt.reloader.reload(); err == nil {
+				t.Fatal("the configuration has been reloaded, want it rejected")
+			}
+			if got := *rt.sessionService.Settings(); !reflect.DeepEqual(got, settings) {
+				t.Errorf("the token settings are %+v after the rejected reload, want %+v", got, settings)
+			}
+			if rt.oidcService.SigningKey() != signingKey {
+				t.Error("the signing key has been replaced by the rejected reload")
+			}
+		})
+	}
+}
+
+func TestReloadRetainsRestartSettings(t *testing.T) {
+	rt := newReloadTest(t)
+	signingKey := rt.oidcService.SigningKey()
+
+	rt.write(t, "config.yaml", rt.testConfig(map[string]string{"server_address": "127.0.0.1:9999", "jwt_access_exp": "1m"}))
+	rt.writeKey(t)
+	if err := rt.reloader.reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if got := rt.sessionService.Settings().AccessExp; got != time.Minute {
+		t.Errorf("JWT_ACCESS_EXP is %s after the reload, want 1m", got)
+	}
+	if rt.oidcService.SigningKey() == signingKey {
+		t.Error("the signing key has not been replaced by the reload")
+	}
+
+	// The running value is kept and compared again at every reload, so that
+	// the restart is asked for until it happens.
+	for range 2 {
+		cfg, _, err := config.Load(rt.reloader.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retained := cfg.Retain(rt.reloader.cfg, reloadable); !reflect.DeepEqual(retained, []string{"SERVER_ADDRESS"}) {
+			t.Errorf("the settings waiting for a restart are %v, want [SERVER_ADDRESS]", retained)
+		}
+		if err := rt.reloader.reload(); err != nil {
+			t.Fatalf("failed to reload: %v", err)
+		}
+		if got := rt.reloader.cfg.ServerAddress; got != "127.0.0.1:8080" {
+			t.Errorf("SERVER_ADDRESS is %s after the reload, want the running 127.0.0.1:8080", got)
+		}
+	}
+}
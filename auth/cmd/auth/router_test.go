@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/config"
+	"auth/internal/emailtemplates"
+	"auth/internal/health"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/openapi"
+	"auth/pkg/emailsender"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+// testServerURL is the URL the requests of the tests are sent to.
+const testServerURL = "http://auth.test"
+
+// stubProvider logs in a fixed account. It has no login page: the
+// authorization URL is the callback URL itself.
+type stubProvider struct{}
+
+func (stubProvider) Name() string        { return "stub" }
+func (stubProvider) DisplayName() string { return "Stub" }
+
+func (stubProvider) AuthCodeURL(_ context.Context, state string, _ string, _ string, redirectURI string) (string, error) {
+	return redirectURI + "?" + url.Values{"state": {state}, "code": {"stub-code"}}.Encode(), nil
+}
+
+func (stubProvider) Exchange(_ context.Context, _ string, _ string, _ string, _ string) (*services.UpstreamIdentity, error) {
+	return &services.UpstreamIdentity{Subject: "stub-subject", Email: "federated@example.com", EmailVerified: true}, nil
+}
+
+// newTestRouter returns the router of a development server running on the
+// memory storage, with every optional feature enabled.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg, _, err := config.Load([]string{
+		"-is-dev",
+		"-oidc-enabled",
+		"-storage-driver=memory",
+		"-email-providers=mailbox",
+		"-outbox-poll-interval=10ms",
+		"-oauth-clients=gophkeeper-vault:secret,service:secret",
+	})
+	if err != nil {
+		t.Fatalf("failed to load the configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+
+	store := memory.New()
+	checker := health.New(cfg.ReadinessTimeout)
+	checker.Add(cfg.StorageDriver, store.Ping)
+	mailbox := emailsender.NewMailbox(cfg.DevMailboxSize)
+	emailSender, err := newEmailSender(cfg, mailbox)
+	if err != nil {
+		t.Fatalf("failed to create the email sender: %v", err)
+	}
+	emailTemplates, err := emailtemplates.New(cfg.EmailTemplatesDir, cfg.EmailDefaultLocale, emailtemplates.Branding{ProjectName: cfg.ProjectName})
+	if err != nil {
+		t.Fatalf("failed to load the email templates: %v", err)
+	}
+	sessionService := services.NewSessionService(tokenSettings(cfg), store)
+	outboxService := services.NewOutboxService(outboxSettings(cfg), store, emailSender)
+	authService := services.NewAuthService(store, outboxService, emailTemplates)
+	deviceService := services.NewDeviceService(
+		services.DeviceSettings{
+			ClientIDs:       cfg.DeviceClientIDs,
+			VerificationURI: cfg.DeviceVerificationURI,
+			CodeExp:         cfg.DeviceCodeExp,
+			PollInterval:    cfg.DevicePollInterval,
+		},
+		store,
+		sessionService,
+	)
+	outboxService.OnCleanup(deviceService.DeleteExpiredCodes)
+	signingKey, err := loadSigningKey(cfg)
+	if err != nil {
+		t.Fatalf("failed to create the signing key: %v", err)
+	}
+	oidcService := services.NewOIDCService(
+		services.OIDCSettings{IssuerURL: cfg.OIDCIssuerURL, CodeExp: cfg.OIDCCodeExp, IDTokenExp: cfg.OIDCIDTokenExp},
+		store,
+		sessionService,
+		signingKey,
+	)
+	federationService := services.NewFederationService(
+		services.FederationSettings{PublicURL: testServerURL, LoginExp: cfg.FederationLoginExp},
+		[]services.UpstreamProvider{stubProvider{}},
+		store,
+		authService,
+		sessionService,
+	)
+	oauthClients, err := services.ParseStaticClients(cfg.OAuthClients)
+	if err != nil {
+		t.Fatalf("invalid OAuth clients: %v", err)
+	}
+	document, err := openapi.JSON()
+	if err != nil {
+		t.Fatalf("failed to load the OpenAPI document: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		outboxService.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return setupRouter(
+		cfg.ProjectName,
+		sessionService,
+		authService,
+		deviceService,
+		oidcService,
+		federationService,
+		checker,
+		oauthClients,
+		mailbox,
+		document,
+		time.Now().AddDate(0, 6, 0),
+	)
+}
+
+// loadDocument returns the OpenAPI document served by the router.
+func loadDocument(t *testing.T) *openapi3.T {
+	t.Helper()
+	data, err := openapi.JSON()
+	if err != nil {
+		t.Fatalf("failed to load the OpenAPI document: %v", err)
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		t.Fatalf("failed to parse the OpenAPI document: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	return doc
+}
+
+var ginParamRegexp = regexp.MustCompile(`:(\w+)`)
+
+// isLegacyRoute reports whether the route is an unversioned alias of the
+// first version of the API, which is not documented on its own.
+func isLegacyRoute(path string) bool {
+	return strings.HasPrefix(path, apiPath+"/") && !strings.HasPrefix(path, apiV1Path+"/") && path != apiPath+"/openapi.json"
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	doc := loadDocument(t)
+	router := newTestRouter(t)
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		path := ginParamRegexp.ReplaceAllString(route.Path, "{$1}")
+		registered[route.Method+" "+path] = true
+		if isLegacyRoute(path) {
+			continue
+		}
+		item := doc.Paths.Value(path)
+		if item == nil || item.GetOperation(route.Method) == nil {
+			t.Errorf("%s %s is not documented", route.Method, path)
+		}
+	}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if !registered[method+" "+path] {
+				t.Errorf("%s %s is documented but not served", method, path)
+			}
+			if strings.HasPrefix(path, apiV1Path+"/") {
+				legacyPath := apiPath + strings.TrimPrefix(path, apiV1Path)
+				if !registered[method+" "+legacyPath] {
+					t.Errorf("%s %s has no unversioned alias", method, path)
+				}
+			}
+		}
+	}
+}
+
+// apiRequest is a request sent by apiTest.
+type apiRequest struct {
+	method string
+	path   string
+	json   string
+	form   url.Values
+	token  string
+	// basicAuth is the client_id:client_secret pair of a confidential client.
+	basicAuth string
+	// invalid requests are sent to check the errors, only their response
+	// is validated.
+	invalid bool
+}
+
+// apiTest sends requests to the router like a client keeping cookies, and
+// validates them and their responses against the OpenAPI document.
+type apiTest struct {
+	t       *testing.T
+	handler http.Handler
+	routes  routers.Router
+	jar     http.CookieJar
+}
+
+func (at *apiTest) newRequest(r apiRequest) *http.Request {
+	var body io.Reader
+	switch {
+	case r.json != "":
+		body = strings.NewReader(r.json)
+	case r.form != nil:
+		body = strings.NewReader(r.form.Encode())
+	}
+	req := httptest.NewRequest(r.method, testServerURL+r.path, body)
+	switch {
+	case r.json != "":
+		req.Header.Set("Content-Type", "application/json")
+	case r.form != nil:
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	if clientID, secret, ok := strings.Cut(r.basicAuth, ":"); ok {
+		req.SetBasicAuth(clientID, secret)
+	}
+	for _, cookie := range at.jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+// do sends the request and returns the response and its decoded JSON body.
+func (at *apiTest) do(r apiRequest) (*http.Response, any) {
+	at.t.Helper()
+	ctx := context.Background()
+	name := r.method + " " + r.path
+
+	req := at.newRequest(r)
+	route, params, err := at.routes.FindRoute(req)
+	if err != nil {
+		at.t.Fatalf("%s is not documented: %v", name, err)
+	}
+	requestInput := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	if err := openapi3filter.ValidateRequest(ctx, requestInput); err != nil && !r.invalid {
+		at.t.Errorf("%s: invalid request: %v", name, err)
+	}
+
+	rec := httptest.NewRecorder()
+	at.handler.ServeHTTP(rec, at.newRequest(r))
+	resp := rec.Result()
+	at.jar.SetCookies(req.URL, resp.Cookies())
+	respBody := rec.Body.Bytes()
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: requestInput,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(bytes.NewReader(respBody)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		at.t.Errorf("%s: invalid %d response: %v", name, resp.StatusCode, err)
+	}
+	var decoded any
+	_ = json.Unmarshal(respBody, &decoded)
+	return resp, decoded
+}
+
+// expect sends the request and fails the test unless the response has the
+// status code.
+func (at *apiTest) expect(r apiRequest, statusCode int) map[string]any {
+	at.t.Helper()
+	resp, body := at.do(r)
+	if resp.StatusCode != statusCode {
+		at.t.Fatalf("%s %s returned %d, want %d: %v", r.method, r.path, resp.StatusCode, statusCode, body)
+	}
+	object, _ := body.(map[string]any)
+	return object
+}
+
+// latestCode waits for the login code sent to the address.
+func (at *apiTest) latestCode(address string) any {
+	at.t.Helper()
+	path := "/dev/mailbox/latest-code?" + url.Values{"to": {address}}.Encode()
+	for range 100 {
+		if resp, body := at.do(apiRequest{method: http.MethodGet, path: path}); resp.StatusCode == http.StatusOK {
+			return body.(map[string]any)["code"]
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	at.t.Fatalf("no login code has been sent to %s", address)
+	return nil
+}
+
+// decodeForm decodes the fields a form carries. The decoder of kin-openapi
+// sets the missing optional fields to null, which fails the validation.
+// Only the fields of the schema which are arrays may be repeated.
+func decodeForm(body io.Reader, _ http.Header, schema *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (any, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	for name, value := range values {
+		if property := schema.Value.Properties[name]; property != nil && property.Value.Type.Is(openapi3.TypeArray) {
+			items := make([]any, len(value))
+			for i, item := range value {
+				items[i] = item
+			}
+			fields[name] = items
+			continue
+		}
+		fields[name] = value[0]
+	}
+	return fields, nil
+}
+
+// newAPITest returns an apiTest of the router of newTestRouter.
+func newAPITest(t *testing.T) *apiTest {
+	t.Helper()
+	openapi3filter.RegisterBodyDecoder("application/x-www-form-urlencoded", decodeForm)
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.PlainBodyDecoder)
+	doc := loadDocument(t)
+	doc.Servers = openapi3.Servers{{URL: testServerURL}}
+	routes, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("failed to route the OpenAPI document: %v", err)
+	}
+	jar, _ := cookiejar.New(nil)
+	return &apiTest{t: t, handler: newTestRouter(t), routes: routes, jar: jar}
+}
+
+// login logs in with an email code and returns the access token, issued for
+// the audiences.
+func (at *apiTest) login(email string, audiences ...string) string {
+	at.t.Helper()
+	generated := at.expect(apiRequest{method: http.MethodPost, path: apiV1Path + "/code/generate/", json: `{"email":"` + email + `"}`}, http.StatusCreated)
+	check := map[string]any{"email_code_id": generated["email_code_id"], "code": at.latestCode(email)}
+	if len(audiences) > 0 {
+		check["audience"] = audiences
+	}
+	checked := at.expect(apiRequest{method: http.MethodPost, path: apiV1Path + "/code/check/", json: jsonString(at.t, check)}, http.StatusCreated)
+	accessToken, _ := checked["access_token"].(string)
+	return accessToken
+}
+
+func TestRoutesConformToOpenAPI(t *testing.T) {
+	at := newAPITest(t)
+	const (
+		get  = http.MethodGet
+		post = http.MethodPost
+		del  = http.MethodDelete
+	)
+	v1 := apiV1Path
+
+	at.expect(apiRequest{method: get, path: "/healthz"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: "/readyz"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: "/metrics"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: apiPath + "/openapi.json"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: "/.well-known/openid-configuration"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: v1 + "/oauth/jwks"}, http.StatusOK)
+
+	// Logging in with an email code
+	email := "user@example.com"
+	generated := at.expect(apiRequest{method: post, path: v1 + "/code/generate/", json: `{"email":"` + email + `"}`}, http.StatusCreated)
+	codeID, _ := generated["email_code_id"].(string)
+	at.expect(apiRequest{method: post, path: v1 + "/code/generate/", json: `{"email":5}`, invalid: true}, http.StatusBadRequest)
+	at.expect(apiRequest{method: post, path: v1 + "/code/generate/", json: `{"email":"nope"}`, invalid: true}, http.StatusBadRequest)
+	at.expect(apiRequest{method: get, path: v1 + "/code/00000000-0000-0000-0000-000000000000/delivery/"}, http.StatusNotFound)
+	at.expect(apiRequest{method: get, path: v1 + "/code/x/delivery/", invalid: true}, http.StatusBadRequest)
+	code := at.latestCode(email)
+	at.expect(apiRequest{method: get, path: v1 + "/code/" + codeID + "/delivery/"}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: "/dev/mailbox?" + url.Values{"to": {email}}.Encode()}, http.StatusOK)
+	at.expect(apiRequest{method: post, path: v1 + "/code/check/", json: `{"email_code_id":"` + codeID + `","code":1}`}, http.StatusPreconditionFailed)
+	checked := at.expect(apiRequest{method: post, path: v1 + "/code/check/", json: `{"email_code_id":"` + codeID + `","code":` + jsonString(t, code) + `}`}, http.StatusCreated)
+	accessToken, _ := checked["access_token"].(string)
+
+	// Sessions
+	at.expect(apiRequest{method: get, path: v1 + "/sessions/", token: accessToken}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: v1 + "/sessions/"}, http.StatusUnauthorized)
+	at.expect(apiRequest{method: del, path: v1 + "/sessions/x/", token: accessToken, invalid: true}, http.StatusBadRequest)
+	at.expect(apiRequest{method: post, path: v1 + "/token/?audience=unknown"}, http.StatusBadRequest)
+	refreshed := at.expect(apiRequest{method: post, path: v1 + "/token/?audience=gophkeeper-auth&audience=gophkeeper-vault"}, http.StatusOK)
+	accessToken, _ = refreshed["access_token"].(string)
+
+	// Device authorization grant
+	device := at.expect(apiRequest{method: post, path: v1 + "/oauth/device_authorization", form: url.Values{"client_id": {"gophkeeper-cli"}, "scope": {"vault"}}}, http.StatusOK)
+	deviceCode, _ := device["device_code"].(string)
+	userCode, _ := device["user_code"].(string)
+	deviceToken := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "client_id": {"gophkeeper-cli"}, "device_code": {deviceCode}}
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/token", form: deviceToken}, http.StatusBadRequest)
+	unknownAudience := url.Values{"audience": {"unknown"}}
+	for key, values := range deviceToken {
+		unknownAudience[key] = values
+	}
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/token", form: unknownAudience}, http.StatusBadRequest)
+	at.expect(apiRequest{method: get, path: v1 + "/device/" + userCode + "/", token: accessToken}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: v1 + "/device/NOPE-NOPE/", token: accessToken}, http.StatusNotFound)
+	at.expect(apiRequest{method: post, path: v1 + "/device/", json: `{"user_code":"` + userCode + `","approve":true}`, token: accessToken}, http.StatusNoContent)
+	deviceToken.Set("audience", "gophkeeper-vault")
+	deviceTokens := at.expect(apiRequest{method: post, path: v1 + "/oauth/token", form: deviceToken}, http.StatusOK)
+
+	// Token introspection and revocation
+	introspected := at.expect(apiRequest{method: post, path: v1 + "/oauth/introspect", form: url.Values{"token": {accessToken}}, basicAuth: "gophkeeper-vault:secret"}, http.StatusOK)
+	if introspected["active"] != true {
+		t.Errorf("the access token is not active: %v", introspected)
+	}
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/introspect", form: url.Values{"token": {accessToken}}, basicAuth: "gophkeeper-vault:wrong"}, http.StatusUnauthorized)
+	deviceAccessToken, _ := deviceTokens["access_token"].(string)
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/revoke", form: url.Values{"token": {deviceAccessToken}}, basicAuth: "service:secret"}, http.StatusBadRequest)
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/revoke", form: url.Values{"token": {deviceAccessToken}}, basicAuth: "gophkeeper-vault:secret"}, http.StatusOK)
+
+	// First-party tokens are not accepted by the userinfo endpoint.
+	at.expect(apiRequest{method: get, path: v1 + "/oauth/userinfo", token: accessToken}, http.StatusUnauthorized)
+
+	// The login forms of the OpenID provider are only accepted from the browser they have been shown in.
+	forged := url.Values{"request": {"client_id=client"}, "csrf_token": {"forged"}, "email": {email}}
+	at.expect(apiRequest{method: post, path: v1 + "/oauth/authorize/login/email", form: forged}, http.StatusForbidden)
+
+	// Logging in with an upstream provider
+	at.expect(apiRequest{method: get, path: v1 + "/federation/providers/"}, http.StatusOK)
+	resp, _ := at.do(apiRequest{method: get, path: v1 + "/federation/stub/login"})
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("the login with the provider is not redirected: %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	federated := at.expect(apiRequest{method: get, path: callback.RequestURI()}, http.StatusOK)
+	federatedToken, _ := federated["access_token"].(string)
+	at.expect(apiRequest{method: get, path: v1 + "/identities/", token: federatedToken}, http.StatusOK)
+	at.expect(apiRequest{method: get, path: v1 + "/federation/unknown/login"}, http.StatusNotFound)
+
+	// Logging out
+	at.expect(apiRequest{method: del, path: v1 + "/token/"}, http.StatusNoContent)
+	at.expect(apiRequest{method: post, path: v1 + "/token/"}, http.StatusUnauthorized)
+	at.expect(apiRequest{method: del, path: "/dev/mailbox"}, http.StatusNoContent)
+}
+
+func TestLegacyRoutesAreDeprecated(t *testing.T) {
+	router := newTestRouter(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, testServerURL+apiPath+"/sessions/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("the unversioned route returned %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	for _, header := range []string{"Deprecation", "Sunset"} {
+		if rec.Header().Get(header) == "" {
+			t.Errorf("the unversioned route has no %s header", header)
+		}
+	}
+	if link, want := rec.Header().Get("Link"), "<"+apiV1Path+`/sessions/>; rel="successor-version"`; link != want {
+		t.Errorf("Link is %q, want %q", link, want)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, testServerURL+apiV1Path+"/sessions/", nil))
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("the versioned route is deprecated")
+	}
+}
+
+func jsonString(t *testing.T, value any) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to encode %v: %v", value, err)
+	}
+	return string(data)
+}
+
+func TestTokenIntrospectionIsBoundToClient(t *testing.T) {
+	at := newAPITest(t)
+	introspect := func(token string, client string) map[string]any {
+		t.Helper()
+		return at.expect(apiRequest{method: http.MethodPost, path: apiV1Path + "/oauth/introspect", form: url.Values{"token": {token}}, basicAuth: client + ":secret"}, http.StatusOK)
+	}
+	revoke := func(token string, client string) map[string]any {
+		t.Helper()
+		resp, body := at.do(apiRequest{method: http.MethodPost, path: apiV1Path + "/oauth/revoke", form: url.Values{"token": {token}}, basicAuth: client + ":secret"})
+		object, _ := body.(map[string]any)
+		if object == nil {
+			object = map[string]any{}
+		}
+		object["status"] = resp.StatusCode
+		return object
+	}
+	const vault = "gophkeeper-vault"
+	accessToken := at.login("alice@example.com", vault)
+	authToken := at.login("bob@example.com")
+	refreshToken := ""
+	for _, cookie := range at.jar.Cookies(&url.URL{Scheme: "http", Host: "auth.test", Path: apiV1Path + "/token/"}) {
+		if cookie.Name == "atlas_rt" {
+			refreshToken = cookie.Value
+		}
+	}
+	if refreshToken == "" {
+		t.Fatal("no refresh token has been set")
+	}
+
+	for _, tt := range []struct {
+		name       string
+		token      string
+		client     string
+		wantActive bool
+	}{
+		{name: "issued to the client", token: accessToken, client: vault, wantActive: true},
+		{name: "issued to another client", token: accessToken, client: "service"},
+		{name: "issued to the auth service", token: authToken, client: vault},
+		{name: "refresh token", token: refreshToken, client: vault},
+		{name: "invalid", token: "not-a-token", client: vault},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			info := introspect(tt.token, tt.client)
+			if info["active"] != tt.wantActive {
+				t.Errorf("the token is reported as %v, want active: %t", info, tt.wantActive)
+			}
+			if !tt.wantActive && len(info) != 1 {
+				t.Errorf("the inactive token is described: %v", info)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		token      string
+		client     string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "issued to another client", token: accessToken, client: "service", wantStatus: http.StatusBadRequest, wantError: "unauthorized_client"},
+		{name: "refresh token", token: refreshToken, client: vault, wantStatus: http.StatusBadRequest, wantError: "unauthorized_client"},
+		{name: "invalid", token: "not-a-token", client: vault, wantStatus: http.StatusOK},
+	} {
+		t.Run("revoke "+tt.name, func(t *testing.T) {
+			result := revoke(tt.token, tt.client)
+			if result["status"] != tt.wantStatus || (tt.wantError != "" && result["error"] != tt.wantError) {
+				t.Errorf("the revocation returned %v, want %d %s", result, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+	if info := introspect(accessToken, vault); info["active"] != true {
+		t.Fatalf("the token is not active after the refused revocations: %v", info)
+	}
+
+	if result := revoke(accessToken, vault); result["status"] != http.StatusOK {
+		t.Fatalf("the revocation by the client returned %v", result)
+	}
+	if info := introspect(accessToken, vault); info["active"] != false {
+		t.Errorf("the revoked token is reported as %v, want inactive", info)
+	}
+	if result := revoke(accessToken, vault); result["status"] != http.StatusOK {
+		t.Errorf("revoking the token again returned %v, want 200", result)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"auth/internal/config"
+	"auth/internal/health"
+	"auth/internal/metrics"
+	"auth/internal/storage"
+	"auth/internal/storage/memory"
+	"auth/internal/storage/psql"
+	"auth/internal/storage/sqlite"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newStorage(ctx context.Context, cfg *config.Config, checker *health.Checker) (storage.Storage, error) {
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	checker.Add(cfg.StorageDriver, store.Ping)
+
+	if m, ok := store.(storage.Migratable); ok {
+		if cfg.AutoMigrate {
+			if _, err := m.Migrations().Up(ctx); err != nil {
+				store.Close()
+				return nil, fmt.Errorf("failed to apply migrations: %w", err)
+			}
+		}
+		checker.Add("migrations", m.Migrations().Check)
+	}
+	if psqlStorage, ok := store.(*psql.PSQLStorage); ok {
+		prometheus.MustRegister(metrics.NewPgxPoolCollector(psqlStorage.Pool))
+	}
+	return store, nil
+}
+
+func openStorage(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+	switch cfg.StorageDriver {
+	case storage.DriverPostgres:
+		return psql.New(
+			ctx,
+			cfg.PSQLHost,
+			cfg.PSQLPort,
+			cfg.PSQLUsername,
+			cfg.PSQLPassword,
+			cfg.PSQLDBName,
+		)
+	case storage.DriverSQLite:
+		return sqlite.New(ctx, cfg.SQLitePath)
+	case storage.DriverMemory:
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// errRefreshTokenMissing is returned when the refresh token cookie is not sent.
+var errRefreshTokenMissing = httperror.NewWithCode(nil, httperror.CodeInvalidToken, "Refresh token is missing or invalid", http.StatusUnauthorized)
+
 type AuthHandlers struct {
 	authService    *services.AuthService
 	sessionService *services.SessionService
@@ -28,48 +31,74 @@ func NewAuthHandlers(
 func (ah *AuthHandlers) GenerateEmailCodeHandler(c *gin.Context) {
 	var requestData struct {
 		Email *string `json:"email"`
+		// Locale of the email, the Accept-Language header is used when it is empty.
+		Locale string `json:"locale"`
 	}
-	if err := c.BindJSON(&requestData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+	if err := bindJSON(c, &requestData); err != nil {
+		respondWithError(c, err)
 		return
 	}
 	if requestData.Email == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "email is required"})
+		respondWithError(c, invalidRequest("email is required"))
 		return
 	}
-	emailCode, err := ah.authService.GenerateEmailCode(c.Request.Context(), *requestData.Email)
+	locale := requestData.Locale
+	if locale == "" {
+		locale = c.GetHeader("Accept-Language")
+	}
+	emailCode, err := ah.authService.GenerateEmailCode(c.Request.Context(), *requestData.Email, locale)
 	if err != nil {
-		msg, statusCode := httperror.GetMessageAndStatusCode(err)
-		c.JSON(statusCode, gin.H{"detail": msg})
+		respondWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"email_code_id": emailCode.ID})
 }
 
+// GetEmailCodeDeliveryHandler reports whether the email with the code has been
+// sent, so that the client can offer to send a new code when it has failed.
+func (ah *AuthHandlers) GetEmailCodeDeliveryHandler(c *gin.Context) {
+	emailCodeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondWithError(c, invalidRequest("Invalid email code id"))
+		return
+	}
+	delivery, err := ah.authService.GetEmailCodeDelivery(c.Request.Context(), emailCodeID)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
 func (ah *AuthHandlers) NewCheckEmailCodeHandler(rt_path string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var requestData struct {
 			EmailCodeID *uuid.UUID `json:"email_code_id"`
 			Code        *uint16    `json:"code"`
+			// Audience lists the services the access token is for.
+			Audience []string `json:"audience"`
 		}
-		if err := c.BindJSON(&requestData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := bindJSON(c, &requestData); err != nil {
+			respondWithError(c, err)
 			return
 		}
 		if requestData.EmailCodeID == nil || requestData.Code == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"detail": "email_code_id and code are required"})
+			respondWithError(c, invalidRequest("email_code_id and code are required"))
+			return
+		}
+		// Checked before the code, which can only be used once.
+		if err := ah.sessionService.CheckAudiences(requestData.Audience); err != nil {
+			respondWithError(c, err)
 			return
 		}
 		user, isNewUser, err := ah.authService.CheckEmailCode(c.Request.Context(), *requestData.EmailCodeID, *requestData.Code)
 		if err != nil {
-			msg, statusCode := httperror.GetMessageAndStatusCode(err)
-			c.JSON(statusCode, gin.H{"detail": msg})
+			respondWithError(c, err)
 			return
 		}
-		tokens, err := ah.sessionService.CreateSession(c.Request.Context(), user.ID, c.GetHeader("User-Agent"), c.ClientIP())
+		tokens, err := ah.sessionService.CreateSession(c.Request.Context(), user.ID, c.GetHeader("User-Agent"), c.ClientIP(), requestData.Audience...)
 		if err != nil {
-			msg, statusCode := httperror.GetMessageAndStatusCode(err)
-			c.JSON(statusCode, gin.H{"detail": msg})
+			respondWithError(c, err)
 			return
 		}
 		statusCode := http.StatusOK
@@ -85,13 +114,12 @@ func (ah *AuthHandlers) NewRefreshTokenHandler(rt_path string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		refreshToken, err := c.Cookie("atlas_rt")
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"detail": "Refresh token is missing or invalid"})
+			respondWithError(c, errRefreshTokenMissing)
 			return
 		}
-		tokens, err := ah.sessionService.UpdateSession(c.Request.Context(), refreshToken, c.GetHeader("User-Agent"), c.ClientIP())
+		tokens, err := ah.sessionService.UpdateSession(c.Request.Context(), refreshToken, c.GetHeader("User-Agent"), c.ClientIP(), c.QueryArray("audience")...)
 		if err != nil {
-			msg, statusCode := httperror.GetMessageAndStatusCode(err)
-			c.JSON(statusCode, gin.H{"detail": msg})
+			respondWithError(c, err)
 			return
 		}
 		c.SetCookie("atlas_rt", tokens.RefreshToken, 7*24*60*60, rt_path, "", false, true)
@@ -104,8 +132,7 @@ func (ah *AuthHandlers) GetUserSessionsHandler(c *gin.Context) {
 	userID := c.MustGet(gin.AuthUserKey).(uuid.UUID)
 	sessions, err := ah.sessionService.GetSessionsList(c.Request.Context(), userID)
 	if err != nil {
-		msg, statusCode := httperror.GetMessageAndStatusCode(err)
-		c.JSON(statusCode, gin.H{"detail": msg})
+		respondWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, sessions)
@@ -114,13 +141,12 @@ func (ah *AuthHandlers) GetUserSessionsHandler(c *gin.Context) {
 func (ah *AuthHandlers) DeleteSession(c *gin.Context) {
 	sessionID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid session id"})
+		respondWithError(c, invalidRequest("Invalid session id"))
 		return
 	}
 	err = ah.sessionService.DeleteSession(c.Request.Context(), sessionID)
 	if err != nil {
-		msg, statusCode := httperror.GetMessageAndStatusCode(err)
-		c.JSON(statusCode, gin.H{"detail": msg})
+		respondWithError(c, err)
 		return
 	}
 	c.String(http.StatusNoContent, "")
@@ -130,13 +156,12 @@ func (ah *AuthHandlers) NewDeleteCurrentSession(rt_path string) gin.HandlerFunc
 	return func(c *gin.Context) {
 		refreshToken, err := c.Cookie("atlas_rt")
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"detail": "Refresh token is missing or invalid"})
+			respondWithError(c, errRefreshTokenMissing)
 			return
 		}
 		err = ah.sessionService.DeleteSessionByToken(c.Request.Context(), refreshToken)
 		if err != nil {
-			msg, statusCode := httperror.GetMessageAndStatusCode(err)
-			c.JSON(statusCode, gin.H{"detail": msg})
+			respondWithError(c, err)
 			return
 		}
 		c.SetCookie("atlas_rt", "", -1, rt_path, "", false, true)
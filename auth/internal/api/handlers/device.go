@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type DeviceHandlers struct {
+	deviceService *services.DeviceService
+}
+
+func NewDeviceHandlers(deviceService *services.DeviceService) *DeviceHandlers {
+	return &DeviceHandlers{
+		deviceService: deviceService,
+	}
+}
+
+// GetDeviceAuthorizationHandler describes the pending authorization of a user code.
+func (dh *DeviceHandlers) GetDeviceAuthorizationHandler(c *gin.Context) {
+	deviceCode, err := dh.deviceService.GetPendingAuthorization(c.Request.Context(), c.Param("user_code"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"client_id":  deviceCode.ClientID,
+		"scope":      deviceCode.Scope,
+		"expires_at": deviceCode.ExpiresAt,
+	})
+}
+
+// DecideDeviceAuthorizationHandler approves or denies the authorization of a user code.
+func (dh *DeviceHandlers) DecideDeviceAuthorizationHandler(c *gin.Context) {
+	var requestData struct {
+		UserCode *string `json:"user_code"`
+		Approve  *bool   `json:"approve"`
+	}
+	if err := bindJSON(c, &requestData); err != nil {
+		respondWithError(c, err)
+		return
+	}
+	if requestData.UserCode == nil || requestData.Approve == nil {
+		respondWithError(c, invalidRequest("user_code and approve are required"))
+		return
+	}
+	userID := c.MustGet(gin.AuthUserKey).(uuid.UUID)
+	err := dh.deviceService.Decide(c.Request.Context(), userID, *requestData.UserCode, *requestData.Approve)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	c.String(http.StatusNoContent, "")
+}
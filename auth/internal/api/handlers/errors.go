@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"auth/internal/services"
+	"auth/pkg/httperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondWithError writes err as problem details, see RFC 7807. Internal
+// errors are logged and masked.
+func respondWithError(c *gin.Context, err error) {
+	problem := httperror.NewProblem(err, c.Request.URL.Path)
+	if problem.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(c.Request.Context(), "request failed", "error", err)
+	}
+	c.Header("Content-Type", httperror.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// respondWithOAuthError writes errors of the OAuth2 endpoints in the RFC 6749 format.
+func respondWithOAuthError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		respondWithError(c, err)
+		return
+	}
+	body := gin.H{"error": oauthErr.Code}
+	if oauthErr.Description != "" {
+		body["error_description"] = oauthErr.Description
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(oauthErr.StatusCode, body)
+}
+
+// invalidRequest returns an error about a request missing or having invalid parameters.
+func invalidRequest(msg string) error {
+	return httperror.NewWithCode(nil, httperror.CodeInvalidRequest, msg, http.StatusBadRequest)
+}
+
+// bindJSON decodes the request body into obj. The errors of the decoder are
+// replaced by messages that don't tell about the types of the service.
+func bindJSON(c *gin.Context, obj any) error {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return nil
+	}
+	var (
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+	)
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return httperror.WithDetails(
+			httperror.NewWithCode(err, httperror.CodeInvalidRequest, typeErr.Field+" has an invalid type", http.StatusBadRequest),
+			map[string]any{"field": typeErr.Field},
+		)
+	case errors.Is(err, io.EOF):
+		return httperror.NewWithCode(err, httperror.CodeInvalidRequest, "Request body is empty", http.StatusBadRequest)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return httperror.NewWithCode(err, httperror.CodeInvalidRequest, "Request body is not valid JSON", http.StatusBadRequest)
+	default:
+		return httperror.NewWithCode(err, httperror.CodeInvalidRequest, "Request body is invalid", http.StatusBadRequest)
+	}
+}
+
+// NotFoundHandler answers requests to unknown routes.
+func NotFoundHandler(c *gin.Context) {
+	respondWithError(c, httperror.NewWithCode(nil, httperror.CodeNotFound, "Not found", http.StatusNotFound))
+}
+
+// RecoveryHandler answers requests whose handler has panicked, the panic is
+// logged by gin.
+func RecoveryHandler(c *gin.Context, _ any) {
+	respondWithError(c, errors.New("handler panicked"))
+}
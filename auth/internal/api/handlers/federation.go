@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"auth/internal/services"
+	"auth/pkg/httperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// federatedLoginCookie keeps the state of a login with an upstream provider
+// until the user is redirected back.
+const federatedLoginCookie = "atlas_fed"
+
+type FederationHandlers struct {
+	federationService *services.FederationService
+	sessionService    *services.SessionService
+	// basePath is the path the federation endpoints are served under.
+	basePath string
+	// rtPath is the path of the refresh token cookie.
+	rtPath string
+	// oauthBasePath is the path of the OpenID Connect provider, empty when it
+	// is disabled. Logins returning to its authorization endpoint start a
+	// provider session instead of an app session.
+	oauthBasePath string
+}
+
+func NewFederationHandlers(
+	federationService *services.FederationService,
+	sessionService *services.SessionService,
+	basePath string,
+	rtPath string,
+	oauthBasePath string,
+) *FederationHandlers {
+	return &FederationHandlers{
+		federationService: federationService,
+		sessionService:    sessionService,
+		basePath:          strings.TrimSuffix(basePath, "/"),
+		rtPath:            rtPath,
+		oauthBasePath:     strings.TrimSuffix(oauthBasePath, "/"),
+	}
+}
+
+// ProvidersHandler lists the providers users can log in with.
+func (fh *FederationHandlers) ProvidersHandler(c *gin.Context) {
+	providers := []gin.H{}
+	for _, provider := range fh.federationService.Providers() {
+		providers = append(providers, gin.H{
+			"name":         provider.Name(),
+			"display_name": provider.DisplayName(),
+			"login_url":    fh.LoginPath(provider.Name(), ""),
+		})
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+// LoginHandler redirects the user to the login page of the provider. The
+// user is sent to return_to after logging in.
+func (fh *FederationHandlers) LoginHandler(c *gin.Context) {
+	name := c.Param("provider")
+	authURL, loginState, err := fh.federationService.StartLogin(
+		c.Request.Context(),
+		name,
+		fh.callbackURL(name),
+		c.Query("return_to"),
+	)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	fh.setCookie(c, federatedLoginCookie, loginState, int(fh.federationService.LoginExp.Seconds()), fh.basePath)
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// CallbackHandler completes the login when the provider redirects back.
+func (fh *FederationHandlers) CallbackHandler(c *gin.Context) {
+	loginState, _ := c.Cookie(federatedLoginCookie)
+	// The login state is single use, whatever the outcome.
+	fh.setCookie(c, federatedLoginCookie, "", -1, fh.basePath)
+	if c.Query("error") != "" {
+		respondWithError(c, httperror.NewWithCode(nil, httperror.CodeLoginCancelled, "The login was cancelled at the identity provider", http.StatusUnauthorized))
+		return
+	}
+	if loginState == "" || c.Query("code") == "" {
+		respondWithError(c, httperror.NewWithCode(nil, httperror.CodeLoginExpired, "The login has expired, try again", http.StatusBadRequest))
+		return
+	}
+	name := c.Param("provider")
+	tokens, login, err := fh.federationService.FinishLogin(
+		c.Request.Context(),
+		name,
+		loginState,
+		c.Query("state"),
+		c.Query("code"),
+		fh.callbackURL(name),
+		c.GetHeader("User-Agent"),
+		c.ClientIP(),
+	)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	maxAge := int(fh.sessionService.Settings().RefreshExp.Seconds())
+	if fh.isProviderLogin(login.ReturnTo) {
+		fh.setCookie(c, oidcSessionCookie, tokens.RefreshToken, maxAge, fh.oauthBasePath)
+	} else {
+		fh.setCookie(c, "atlas_rt", tokens.RefreshToken, maxAge, fh.rtPath)
+	}
+	if login.ReturnTo == "" {
+		c.JSON(http.StatusOK, gin.H{"access_token": tokens.AccessToken})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, login.ReturnTo)
+}
+
+// GetIdentitiesHandler lists the provider accounts linked to the user.
+func (fh *FederationHandlers) GetIdentitiesHandler(c *gin.Context) {
+	userID := c.MustGet(gin.AuthUserKey).(uuid.UUID)
+	identities, err := fh.federationService.GetIdentitiesList(c.Request.Context(), userID)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// LoginPath returns the path starting the login with the provider.
+func (fh *FederationHandlers) LoginPath(name string, returnTo string) string {
+	path := fh.basePath + "/" + url.PathEscape(name) + "/login"
+	if returnTo != "" {
+		path += "?" + url.Values{"return_to": {returnTo}}.Encode()
+	}
+	return path
+}
+
+func (fh *FederationHandlers) callbackURL(name string) string {
+	return strings.TrimSuffix(fh.federationService.PublicURL, "/") + fh.basePath + "/" + url.PathEscape(name) + "/callback"
+}
+
+func (fh *FederationHandlers) isProviderLogin(returnTo string) bool {
+	if fh.oauthBasePath == "" {
+		return false
+	}
+	target, err := url.Parse(returnTo)
+	return err == nil && target.Host == "" && target.Path == fh.oauthBasePath+"/authorize"
+}
+
+func (fh *FederationHandlers) setCookie(c *gin.Context, name string, value string, maxAge int, path string) {
+	// Lax, so that the cookies are sent on the redirect back from the provider.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, path, "", strings.HasPrefix(fh.federationService.PublicURL, "https://"), true)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"auth/internal/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandlers struct {
+	checker *health.Checker
+}
+
+func NewHealthHandlers(checker *health.Checker) *HealthHandlers {
+	return &HealthHandlers{
+		checker: checker,
+	}
+}
+
+func (hh *HealthHandlers) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (hh *HealthHandlers) ReadinessHandler(c *gin.Context) {
+	results := hh.checker.Check(c.Request.Context())
+	statusCode := http.StatusOK
+	checks := make(gin.H, len(results))
+	for name, err := range results {
+		if err != nil {
+			statusCode = http.StatusServiceUnavailable
+			checks[name] = err.Error()
+			slog.WarnContext(c.Request.Context(), "readiness check failed", "check", name, "error", err)
+			continue
+		}
+		checks[name] = "ok"
+	}
+	status := "ok"
+	if statusCode != http.StatusOK {
+		status = "unavailable"
+	}
+	c.JSON(statusCode, gin.H{"status": status, "checks": checks})
+}
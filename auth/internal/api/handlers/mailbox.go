@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"auth/pkg/emailsender"
+	"auth/pkg/httperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginCodeRegexp finds the login code in the subject or the body of an email.
+var loginCodeRegexp = regexp.MustCompile(`\b\d{4,5}\b`)
+
+// MailboxHandlers expose the development mailbox, so that end-to-end tests
+// can read the emails sent by the service. They must only be served in
+// development, the messages contain login codes.
+type MailboxHandlers struct {
+	mailbox *emailsender.Mailbox
+}
+
+func NewMailboxHandlers(mailbox *emailsender.Mailbox) *MailboxHandlers {
+	return &MailboxHandlers{
+		mailbox: mailbox,
+	}
+}
+
+// ListHandler lists the messages, the newest first. The "to" query parameter
+// filters them by recipient.
+func (mh *MailboxHandlers) ListHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, mh.mailbox.List(c.Query("to")))
+}
+
+// GetHandler returns a message as JSON, or its HTML part with ?format=html
+// for viewing it in a browser.
+func (mh *MailboxHandlers) GetHandler(c *gin.Context) {
+	msg, ok := mh.mailbox.Get(c.Param("id"))
+	if !ok {
+		respondWithError(c, httperror.NewWithCode(nil, httperror.CodeMessageNotFound, "Message not found", http.StatusNotFound))
+		return
+	}
+	switch c.Query("format") {
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTML))
+	case "text":
+		c.String(http.StatusOK, msg.Text)
+	default:
+		c.JSON(http.StatusOK, msg)
+	}
+}
+
+// LatestCodeHandler returns the login code of the latest email sent to the
+// address in the "to" query parameter.
+func (mh *MailboxHandlers) LatestCodeHandler(c *gin.Context) {
+	to := c.Query("to")
+	if to == "" {
+		respondWithError(c, invalidRequest("to is required"))
+		return
+	}
+	for _, msg := range mh.mailbox.List(to) {
+		match := loginCodeRegexp.FindString(msg.Subject)
+		if match == "" {
+			match = loginCodeRegexp.FindString(msg.Text)
+		}
+		if code, err := strconv.Atoi(match); err == nil {
+			c.JSON(http.StatusOK, gin.H{"code": code, "message_id": msg.ID, "received_at": msg.ReceivedAt})
+			return
+		}
+	}
+	respondWithError(c, httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "No code has been sent to the address", http.StatusNotFound))
+}
+
+// ClearHandler deletes all messages, e.g. between test cases.
+func (mh *MailboxHandlers) ClearHandler(c *gin.Context) {
+	mh.mailbox.Clear()
+	c.String(http.StatusNoContent, "")
+}
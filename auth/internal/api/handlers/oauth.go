@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+
+	"auth/internal/api/inmiddlewares"
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/pkg/httperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthHandlers struct {
+	sessionService *services.SessionService
+	deviceService  *services.DeviceService
+	// oidcService is nil when the OpenID Connect provider is disabled.
+	oidcService *services.OIDCService
+}
+
+func NewOAuthHandlers(
+	sessionService *services.SessionService,
+	deviceService *services.DeviceService,
+	oidcService *services.OIDCService,
+) *OAuthHandlers {
+	return &OAuthHandlers{
+		sessionService: sessionService,
+		deviceService:  deviceService,
+		oidcService:    oidcService,
+	}
+}
+
+// IntrospectHandler implements RFC 7662 token introspection for the
+// authenticated client. The token_type_hint is ignored, as only access tokens
+// are issued to the clients.
+func (oh *OAuthHandlers) IntrospectHandler(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "token is required", http.StatusBadRequest))
+		return
+	}
+	info, err := oh.sessionService.IntrospectToken(c.Request.Context(), c.GetString(inmiddlewares.ClientIDKey), token)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// RevokeHandler implements RFC 7009 token revocation for the authenticated
+// client, the token_type_hint is ignored like by IntrospectHandler.
+func (oh *OAuthHandlers) RevokeHandler(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "token is required", http.StatusBadRequest))
+		return
+	}
+	err := oh.sessionService.RevokeToken(c.Request.Context(), c.GetString(inmiddlewares.ClientIDKey), token)
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// DeviceAuthorizationHandler starts the RFC 8628 device authorization flow.
+func (oh *OAuthHandlers) DeviceAuthorizationHandler(c *gin.Context) {
+	clientID := c.PostForm("client_id")
+	if clientID == "" {
+		respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "client_id is required", http.StatusBadRequest))
+		return
+	}
+	authorization, err := oh.deviceService.Authorize(c.Request.Context(), clientID, c.PostForm("scope"))
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, authorization)
+}
+
+// TokenHandler issues tokens for the authorization code and device code grants
+// and, so that clients which can't keep cookies are able to refresh them, the
+// refresh token grant.
+func (oh *OAuthHandlers) TokenHandler(c *gin.Context) {
+	var (
+		tokens *services.Tokens
+		scope  string
+		err    error
+	)
+	grantType := c.PostForm("grant_type")
+	if grantType == "authorization_code" && oh.oidcService != nil {
+		oh.exchangeAuthorizationCode(c)
+		return
+	}
+	// The access tokens of the other grants are issued for the requested audiences.
+	audiences := c.PostFormArray("audience")
+	if err := oh.sessionService.CheckAudiences(audiences); err != nil {
+		message, _ := httperror.GetMessageAndStatusCode(err)
+		respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidTarget, message, http.StatusBadRequest))
+		return
+	}
+	switch grantType {
+	case services.DeviceGrantType:
+		deviceCode := c.PostForm("device_code")
+		if deviceCode == "" {
+			respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "device_code is required", http.StatusBadRequest))
+			return
+		}
+		var authorization *models.DeviceCode
+		tokens, authorization, err = oh.deviceService.Poll(c.Request.Context(), c.PostForm("client_id"), deviceCode, c.GetHeader("User-Agent"), c.ClientIP(), audiences...)
+		if err == nil {
+			scope = authorization.Scope
+		}
+	case "refresh_token":
+		refreshToken := c.PostForm("refresh_token")
+		if refreshToken == "" {
+			respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "refresh_token is required", http.StatusBadRequest))
+			return
+		}
+		tokens, err = oh.sessionService.UpdateSession(c.Request.Context(), refreshToken, c.GetHeader("User-Agent"), c.ClientIP(), audiences...)
+		if err != nil {
+			if _, statusCode := httperror.GetMessageAndStatusCode(err); statusCode < http.StatusInternalServerError {
+				err = services.NewOAuthError(services.OAuthErrorInvalidGrant, "", http.StatusBadRequest)
+			}
+		}
+	default:
+		err = services.NewOAuthError(services.OAuthErrorUnsupportedGrantType, "", http.StatusBadRequest)
+	}
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+	body := gin.H{
+		"access_token":  tokens.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(oh.sessionService.Settings().AccessExp.Seconds()),
+		"refresh_token": tokens.RefreshToken,
+	}
+	if scope != "" {
+		body["scope"] = scope
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, body)
+}
+
+func (oh *OAuthHandlers) exchangeAuthorizationCode(c *gin.Context) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+	code := c.PostForm("code")
+	codeVerifier := c.PostForm("code_verifier")
+	if clientID == "" || code == "" || codeVerifier == "" {
+		respondWithOAuthError(c, services.NewOAuthError(services.OAuthErrorInvalidRequest, "client_id, code and code_verifier are required", http.StatusBadRequest))
+		return
+	}
+	tokens, err := oh.oidcService.ExchangeAuthorizationCode(
+		c.Request.Context(),
+		clientID,
+		clientSecret,
+		code,
+		c.PostForm("redirect_uri"),
+		codeVerifier,
+	)
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/pkg/httperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+//go:embed templates/oidc.html
+var templatesFS embed.FS
+
+var oidcTemplates = template.Must(template.ParseFS(templatesFS, "templates/oidc.html"))
+
+// oidcSessionCookie holds the refresh token of the provider session, the
+// session the user has logged in to the provider with in the browser.
+const oidcSessionCookie = "atlas_op"
+
+// oidcCSRFCookie holds the random id of the browser the login forms are
+// bound to, see OIDCService.LoginToken.
+const oidcCSRFCookie = oidcSessionCookie + "_csrf"
+
+// OpenID Connect prompt values.
+const (
+	promptNone    = "none"
+	promptLogin   = "login"
+	promptConsent = "consent"
+)
+
+var scopeDescriptions = map[string]string{
+	services.ScopeOpenID: "Know who you are",
+	services.ScopeEmail:  "See your email address",
+}
+
+type OIDCHandlers struct {
+	oidcService    *services.OIDCService
+	sessionService *services.SessionService
+	authService    *services.AuthService
+	// federationHandlers offer logging in with upstream providers, nil when
+	// none are configured.
+	federationHandlers *FederationHandlers
+	// basePath is the path the OAuth2 endpoints are served under.
+	basePath string
+}
+
+// federationLink is a login button of an upstream provider.
+type federationLink struct {
+	DisplayName string
+	URL         string
+}
+
+type oidcPage struct {
+	Title          string
+	Error          string
+	ClientName     string
+	Request        string
+	Email          string
+	EmailCodeID    string
+	CSRFToken      string
+	Scopes         []string
+	Providers      []federationLink
+	LoginEmailPath string
+	LoginCodePath  string
+	ConsentPath    string
+}
+
+func NewOIDCHandlers(
+	oidcService *services.OIDCService,
+	sessionService *services.SessionService,
+	authService *services.AuthService,
+	federationHandlers *FederationHandlers,
+	basePath string,
+) *OIDCHandlers {
+	return &OIDCHandlers{
+		oidcService:        oidcService,
+		sessionService:     sessionService,
+		authService:        authService,
+		federationHandlers: federationHandlers,
+		basePath:           strings.TrimSuffix(basePath, "/"),
+	}
+}
+
+// DiscoveryHandler serves the OpenID Provider Metadata.
+func (oh *OIDCHandlers) DiscoveryHandler(c *gin.Context) {
+	baseURL := strings.TrimSuffix(oh.oidcService.IssuerURL, "/") + oh.basePath
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                oh.oidcService.IssuerURL,
+		"authorization_endpoint":                baseURL + "/authorize",
+		"token_endpoint":                        baseURL + "/token",
+		"userinfo_endpoint":                     baseURL + "/userinfo",
+		"jwks_uri":                              baseURL + "/jwks",
+		"introspection_endpoint":                baseURL + "/introspect",
+		"revocation_endpoint":                   baseURL + "/revoke",
+		"device_authorization_endpoint":         baseURL + "/device_authorization",
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", services.DeviceGrantType},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      services.SupportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{services.CodeChallengeMethodS256},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid", "email", "email_verified"},
+		"prompt_values_supported":               []string{promptNone, promptLogin, promptConsent},
+	})
+}
+
+func (oh *OIDCHandlers) JWKSHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, oh.oidcService.JWKS())
+}
+
+// UserInfoHandler serves the claims about the user of an access token.
+func (oh *OIDCHandlers) UserInfoHandler(c *gin.Context) {
+	accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.OAuthErrorInvalidRequest})
+		return
+	}
+	info, err := oh.oidcService.UserInfo(c.Request.Context(), accessToken)
+	if err != nil {
+		var oauthErr *services.OAuthError
+		if errors.As(err, &oauthErr) {
+			c.Header("WWW-Authenticate", `Bearer error="`+oauthErr.Code+`"`)
+		}
+		respondWithOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// AuthorizeHandler is the authorization endpoint. Users who are not logged
+// in to the provider are asked to log in with an email code, users who have
+// not allowed the client access yet are asked for consent.
+func (oh *OIDCHandlers) AuthorizeHandler(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		oh.renderError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	oh.authorize(c, services.ParseAuthorizationRequest(c.Request.Form))
+}
+
+func (oh *OIDCHandlers) authorize(c *gin.Context, req *services.AuthorizationRequest) {
+	ctx := c.Request.Context()
+	client, err := oh.oidcService.ValidateAuthorizationRequest(ctx, req)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, client != nil, err)
+		return
+	}
+	session, err := oh.currentSession(c)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, true, err)
+		return
+	}
+	if session == nil || req.Prompt == promptLogin {
+		if req.Prompt == promptNone {
+			oh.respondAuthorizeError(c, req, true, services.NewOAuthError(services.OAuthErrorLoginRequired, "", http.StatusBadRequest))
+			return
+		}
+		// The user is sent back to the authorization endpoint after logging
+		// in, asking for the login again there would loop.
+		if req.Prompt == promptLogin {
+			req.Prompt = ""
+		}
+		oh.render(c, http.StatusOK, "login_email", &oidcPage{
+			Title:      "Sign in",
+			ClientName: client.Name,
+			Request:    req.Encode(),
+		})
+		return
+	}
+	consented, err := oh.oidcService.HasConsent(ctx, session.UserID, client.ID, req.Scope)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, true, err)
+		return
+	}
+	if !consented || req.Prompt == promptConsent {
+		if req.Prompt == promptNone {
+			oh.respondAuthorizeError(c, req, true, services.NewOAuthError(services.OAuthErrorConsentRequired, "", http.StatusBadRequest))
+			return
+		}
+		scopes := []string{}
+		for _, scope := range req.Scopes() {
+			scopes = append(scopes, scopeDescriptions[scope])
+		}
+		oh.render(c, http.StatusOK, "consent", &oidcPage{
+			Title:      "Allow access",
+			ClientName: client.Name,
+			Request:    req.Encode(),
+			CSRFToken:  oh.oidcService.ConsentToken(session.ID, client.ID),
+			Scopes:     scopes,
+		})
+		return
+	}
+	oh.issueCode(c, req, session)
+}
+
+// LoginEmailHandler sends the login code to the email entered on the login page.
+func (oh *OIDCHandlers) LoginEmailHandler(c *gin.Context) {
+	req, client, ok := oh.parseLoginForm(c)
+	if !ok {
+		return
+	}
+	email := strings.TrimSpace(c.PostForm("email"))
+	page := &oidcPage{
+		Title:      "Sign in",
+		ClientName: client.Name,
+		Request:    req.Encode(),
+		Email:      email,
+	}
+	emailCode, err := oh.authService.GenerateEmailCode(c.Request.Context(), email, c.GetHeader("Accept-Language"))
+	if err != nil {
+		page.Error = oh.pageError(c, err)
+		oh.render(c, http.StatusBadRequest, "login_email", page)
+		return
+	}
+	page.EmailCodeID = emailCode.ID.String()
+	oh.render(c, http.StatusOK, "login_code", page)
+}
+
+// LoginCodeHandler checks the login code, starts the provider session and
+// continues the authorization request.
+func (oh *OIDCHandlers) LoginCodeHandler(c *gin.Context) {
+	req, client, ok := oh.parseLoginForm(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	page := &oidcPage{
+		Title:       "Sign in",
+		ClientName:  client.Name,
+		Request:     req.Encode(),
+		Email:       c.PostForm("email"),
+		EmailCodeID: c.PostForm("email_code_id"),
+	}
+	emailCodeID, err := uuid.Parse(page.EmailCodeID)
+	if err != nil {
+		page.Error = "Invalid code, request a new one"
+		oh.render(c, http.StatusBadRequest, "login_email", page)
+		return
+	}
+	code, err := strconv.ParseUint(strings.TrimSpace(c.PostForm("code")), 10, 16)
+	if err != nil {
+		page.Error = "Incorrect code"
+		oh.render(c, http.StatusBadRequest, "login_code", page)
+		return
+	}
+	user, _, err := oh.authService.CheckEmailCode(ctx, emailCodeID, uint16(code))
+	if err != nil {
+		page.Error = oh.pageError(c, err)
+		name := "login_code"
+		if _, statusCode := httperror.GetMessageAndStatusCode(err); statusCode == http.StatusGone || statusCode == http.StatusNotFound {
+			name = "login_email"
+		}
+		oh.render(c, http.StatusBadRequest, name, page)
+		return
+	}
+	tokens, err := oh.sessionService.CreateSession(ctx, user.ID, c.GetHeader("User-Agent"), c.ClientIP())
+	if err != nil {
+		page.Error = oh.pageError(c, err)
+		oh.render(c, http.StatusInternalServerError, "login_code", page)
+		return
+	}
+	oh.setCookie(c, oidcSessionCookie, tokens.RefreshToken, int(oh.sessionService.Settings().RefreshExp.Seconds()))
+	c.Redirect(http.StatusSeeOther, oh.basePath+"/authorize?"+req.Encode())
+}
+
+// ConsentHandler records the decision made on the consent page.
+func (oh *OIDCHandlers) ConsentHandler(c *gin.Context) {
+	req, client, ok := oh.parseFormRequest(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	session, err := oh.currentSession(c)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, true, err)
+		return
+	}
+	if session == nil || !oh.oidcService.VerifyConsentToken(session.ID, client.ID, c.PostForm("csrf_token")) {
+		oh.renderError(c, http.StatusForbidden, "The sign in session has expired, try again")
+		return
+	}
+	if c.PostForm("decision") != "allow" {
+		oh.respondAuthorizeError(c, req, true, services.NewOAuthError(services.OAuthErrorAccessDenied, "", http.StatusForbidden))
+		return
+	}
+	err = oh.oidcService.GrantConsent(ctx, session.UserID, client.ID, req.Scope)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, true, err)
+		return
+	}
+	oh.issueCode(c, req, session)
+}
+
+// parseLoginForm restores the authorization request carried by a login form
+// and checks that the form has been shown to this browser.
+func (oh *OIDCHandlers) parseLoginForm(c *gin.Context) (*services.AuthorizationRequest, *models.OAuthClient, bool) {
+	browserID, _ := c.Cookie(oidcCSRFCookie)
+	if !oh.oidcService.VerifyLoginToken(browserID, c.PostForm("request"), c.PostForm("csrf_token")) {
+		oh.renderError(c, http.StatusForbidden, "The sign in session has expired, try again")
+		return nil, nil, false
+	}
+	return oh.parseFormRequest(c)
+}
+
+// parseFormRequest restores the authorization request carried by a form.
+func (oh *OIDCHandlers) parseFormRequest(c *gin.Context) (*services.AuthorizationRequest, *models.OAuthClient, bool) {
+	values, err := url.ParseQuery(c.PostForm("request"))
+	if err != nil {
+		oh.renderError(c, http.StatusBadRequest, "Invalid request")
+		return nil, nil, false
+	}
+	req := services.ParseAuthorizationRequest(values)
+	client, err := oh.oidcService.ValidateAuthorizationRequest(c.Request.Context(), req)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, client != nil, err)
+		return nil, nil, false
+	}
+	return req, client, true
+}
+
+func (oh *OIDCHandlers) issueCode(c *gin.Context, req *services.AuthorizationRequest, session *models.Session) {
+	code, err := oh.oidcService.IssueAuthorizationCode(c.Request.Context(), req, session)
+	if err != nil {
+		oh.respondAuthorizeError(c, req, true, err)
+		return
+	}
+	oh.redirectToClient(c, req, url.Values{"code": {code}})
+}
+
+// currentSession returns the provider session of the browser, if any.
+func (oh *OIDCHandlers) currentSession(c *gin.Context) (*models.Session, error) {
+	token, err := c.Cookie(oidcSessionCookie)
+	if err != nil || token == "" {
+		return nil, nil
+	}
+	return oh.sessionService.AuthenticateRefreshToken(c.Request.Context(), token)
+}
+
+// respondAuthorizeError reports an error of the authorization request. Errors
+// are sent to the client's redirect URI only once it has been validated.
+func (oh *OIDCHandlers) respondAuthorizeError(c *gin.Context, req *services.AuthorizationRequest, redirect bool, err error) {
+	var oauthErr *services.OAuthError
+	isOAuthErr := errors.As(err, &oauthErr)
+	if !isOAuthErr {
+		slog.ErrorContext(c.Request.Context(), "authorization request failed", "error", err)
+	}
+	if !redirect {
+		message := "Something went wrong"
+		statusCode := http.StatusInternalServerError
+		if isOAuthErr {
+			message = oauthErr.Description
+			statusCode = oauthErr.StatusCode
+		}
+		oh.renderError(c, statusCode, message)
+		return
+	}
+	params := url.Values{"error": {"server_error"}}
+	if isOAuthErr {
+		params.Set("error", oauthErr.Code)
+		if oauthErr.Description != "" {
+			params.Set("error_description", oauthErr.Description)
+		}
+	}
+	oh.redirectToClient(c, req, params)
+}
+
+func (oh *OIDCHandlers) redirectToClient(c *gin.Context, req *services.AuthorizationRequest, params url.Values) {
+	redirectURI, err := url.Parse(req.RedirectTo())
+	if err != nil {
+		oh.renderError(c, http.StatusBadRequest, "Invalid redirect_uri")
+		return
+	}
+	query := redirectURI.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+	c.Redirect(http.StatusSeeOther, redirectURI.String())
+}
+
+// pageError returns the message to show on a page for err, internal errors are logged and masked.
+func (oh *OIDCHandlers) pageError(c *gin.Context, err error) string {
+	msg, statusCode := httperror.GetMessageAndStatusCode(err)
+	if statusCode >= http.StatusInternalServerError {
+		slog.ErrorContext(c.Request.Context(), "request failed", "error", err)
+		return "Something went wrong, try again later"
+	}
+	return msg
+}
+
+// loginToken returns the CSRF token of the login forms of the request. The
+// browser is given a random id first, if it has none.
+func (oh *OIDCHandlers) loginToken(c *gin.Context, request string) (string, error) {
+	browserID, err := c.Cookie(oidcCSRFCookie)
+	if err != nil || browserID == "" {
+		b := make([]byte, 32)
+		if _, err = rand.Read(b); err != nil {
+			return "", err
+		}
+		browserID = base64.RawURLEncoding.EncodeToString(b)
+		// The id lives as long as the browser session, like the forms.
+		oh.setCookie(c, oidcCSRFCookie, browserID, 0)
+	}
+	return oh.oidcService.LoginToken(browserID, request), nil
+}
+
+func (oh *OIDCHandlers) setCookie(c *gin.Context, name string, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, oh.basePath, "", strings.HasPrefix(oh.oidcService.IssuerURL, "https://"), true)
+}
+
+func (oh *OIDCHandlers) renderError(c *gin.Context, statusCode int, message string) {
+	oh.render(c, statusCode, "error", &oidcPage{Title: "Sign in failed", Error: message})
+}
+
+func (oh *OIDCHandlers) render(c *gin.Context, statusCode int, name string, page *oidcPage) {
+	page.LoginEmailPath = oh.basePath + "/authorize/login/email"
+	page.LoginCodePath = oh.basePath + "/authorize/login/code"
+	page.ConsentPath = oh.basePath + "/authorize/consent"
+	if name == "login_email" || name == "login_code" {
+		csrfToken, err := oh.loginToken(c, page.Request)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to create login token", "error", err)
+			name, page = "error", &oidcPage{Title: "Sign in failed", Error: "Something went wrong, try again later"}
+			statusCode = http.StatusInternalServerError
+		}
+		page.CSRFToken = csrfToken
+	}
+	if name == "login_email" && oh.federationHandlers != nil {
+		// The user comes back to the authorization request after logging in.
+		returnTo := oh.basePath + "/authorize?" + page.Request
+		for _, provider := range oh.federationHandlers.federationService.Providers() {
+			page.Providers = append(page.Providers, federationLink{
+				DisplayName: provider.DisplayName(),
+				URL:         oh.federationHandlers.LoginPath(provider.Name(), returnTo),
+			})
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	// The pages must not be framed, so that clicks can't be hijacked.
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Status(statusCode)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := oidcTemplates.ExecuteTemplate(c.Writer, name, page); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to render page", "template", name, "error", err)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIHandlers serve the OpenAPI document of the REST API.
+type OpenAPIHandlers struct {
+	document []byte
+}
+
+func NewOpenAPIHandlers(document []byte) *OpenAPIHandlers {
+	return &OpenAPIHandlers{
+		document: document,
+	}
+}
+
+func (oh *OpenAPIHandlers) DocumentHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", oh.document)
+}
@@ -4,7 +4,9 @@ import (
 	"net/http"
 	"strings"
 
+	"auth/internal/metrics"
 	"auth/internal/services"
+	"auth/pkg/httperror"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,25 +15,31 @@ func NewAuthMiddleware(sessionService *services.SessionService) gin.HandlerFunc
 	return func(c *gin.Context) {
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
+			abortWithError(c, httperror.NewWithCode(nil, httperror.CodeUnauthorized, "Authorization header is required", http.StatusUnauthorized))
 			return
 		}
 
 		clearToken, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header"})
-			c.Abort()
+			abortWithError(c, httperror.NewWithCode(nil, httperror.CodeUnauthorized, "Invalid Authorization header", http.StatusUnauthorized))
 			return
 		}
 
-		claims, err := sessionService.ParseToken(clearToken)
+		claims, err := sessionService.ParseAccessToken(clearToken)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			metrics.TokenValidations.WithLabelValues(metrics.ResultInvalid).Inc()
+			abortWithError(c, httperror.NewWithCode(err, httperror.CodeInvalidToken, "Invalid token", http.StatusUnauthorized))
 			return
 		}
+		metrics.TokenValidations.WithLabelValues(metrics.ResultValid).Inc()
 		c.Set(gin.AuthUserKey, claims.UserID)
 		c.Next()
 	}
 }
+
+// abortWithError writes err as problem details, like the handlers do.
+func abortWithError(c *gin.Context, err error) {
+	problem := httperror.NewProblem(err, c.Request.URL.Path)
+	c.Header("Content-Type", httperror.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
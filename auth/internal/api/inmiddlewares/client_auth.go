@@ -0,0 +1,39 @@
+package inmiddlewares
+
+import (
+	"net/http"
+
+	"auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIDKey is the context key the authenticated OAuth2 client id is stored under.
+const ClientIDKey = "client_id"
+
+// NewClientAuthMiddleware authenticates OAuth2 clients using HTTP Basic
+// authentication or, as RFC 6749 also allows, client_id and client_secret
+// form parameters.
+func NewClientAuthMiddleware(clients services.IClientAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret, ok := c.Request.BasicAuth()
+		if !ok {
+			clientID = c.PostForm("client_id")
+			clientSecret = c.PostForm("client_secret")
+		}
+		if clientID == "" || clientSecret == "" {
+			c.Header("WWW-Authenticate", `Basic realm="auth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			c.Abort()
+			return
+		}
+		if err := clients.AuthenticateClient(c.Request.Context(), clientID, clientSecret); err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="auth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			c.Abort()
+			return
+		}
+		c.Set(ClientIDKey, clientID)
+		c.Next()
+	}
+}
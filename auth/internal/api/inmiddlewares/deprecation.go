@@ -0,0 +1,26 @@
+package inmiddlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewDeprecationMiddleware marks the responses of deprecated routes with the
+// Deprecation and Sunset headers, see RFC 9745 and RFC 8594. The routes
+// served under prefix link to their successor under successorPrefix.
+func NewDeprecationMiddleware(deprecation, sunset time.Time, prefix, successorPrefix string) gin.HandlerFunc {
+	deprecationValue := "@" + strconv.FormatInt(deprecation.Unix(), 10)
+	sunsetValue := sunset.UTC().Format(http.TimeFormat)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecationValue)
+		c.Header("Sunset", sunsetValue)
+		if rest, ok := strings.CutPrefix(c.Request.URL.Path, prefix); ok {
+			c.Writer.Header().Add("Link", "<"+successorPrefix+rest+`>; rel="successor-version"`)
+		}
+		c.Next()
+	}
+}
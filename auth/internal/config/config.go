@@ -1,46 +1,163 @@
 package config
 
 import (
-	"fmt"
 	"time"
-
-	"github.com/caarlos0/env"
 )
 
+// Config is the configuration of the service. Every setting has an
+// environment variable, a key in the configuration file and a command line
+// flag, see Load. Settings tagged as secret may be read from files and are
+// redacted when the configuration is printed.
 type Config struct {
 	// Common
 	ProjectName string `env:"PROJECT_NAME" envDefault:"auth"`
-	IsDev       bool   `env:"IS_DEV" envDefault:"true"`
+	IsDev       bool   `env:"IS_DEV" envDefault:"false"`
+	LogLevel    string `env:"LOG_LEVEL" envDefault:"info"`
+	// CONFIG_WATCH_INTERVAL is how often the configuration file, the secret
+	// files and the OIDC signing key are checked for changes, which are
+	// reloaded like on SIGHUP. Zero disables watching.
+	ConfigWatchInterval time.Duration `env:"CONFIG_WATCH_INTERVAL" envDefault:"10s"`
 
 	// Server
 	ServerAddress     string `env:"SERVER_ADDRESS" envDefault:"0.0.0.0:8080"`
 	GPRCServerAddress string `env:"GRPC_SERVER_ADDRESS" envDefault:"0.0.0.0:9090"`
+	// LEGACY_API_SUNSET is the date, as YYYY-MM-DD, after which the routes
+	// of the REST API without a version may be removed. It is announced in
+	// the Sunset header of their responses.
+	LegacyAPISunset string `env:"LEGACY_API_SUNSET" envDefault:"2027-04-30"`
+
+	// Health
+	ReadinessTimeout   time.Duration `env:"READINESS_TIMEOUT" envDefault:"3s"`
+	ReadinessCheckSMTP bool          `env:"READINESS_CHECK_SMTP" envDefault:"false"`
+	ShutdownDelay      time.Duration `env:"SHUTDOWN_DELAY" envDefault:"0s"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"15s"`
+
+	// Tracing
+	TracingExporter     string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingOTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT" envDefault:"localhost:4317"`
+	TracingOTLPInsecure bool    `env:"TRACING_OTLP_INSECURE" envDefault:"true"`
+	TracingSampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+
+	// Storage
+	StorageDriver string `env:"STORAGE_DRIVER" envDefault:"postgres"`
+	AutoMigrate   bool   `env:"AUTO_MIGRATE" envDefault:"true"`
+
+	// SQLite
+	SQLitePath string `env:"SQLITE_PATH" envDefault:"auth.db"`
 
 	// PSQL
 	PSQLHost     string `env:"PSQL_HOST" envDefault:"localhost"`
 	PSQLPort     string `env:"PSQL_PORT" envDefault:"5432"`
 	PSQLUsername string `env:"PSQL_USERNAME" envDefault:"postgres"`
-	PSQLPassword string `env:"PSQL_PASSWORD" envDefault:"351762"`
+	PSQLPassword string `env:"PSQL_PASSWORD" secret:"true"`
 	PSQLDBName   string `env:"PSQL_DB_NAME" envDefault:"gophkeeper_auth"`
 
 	// JWT
-	JWTSecretKey  string        `env:"JWT_SECRET_KEY" envDefault:"supersecretkey"`
-	JWTAccessExp  time.Duration `env:"JWT_ACCESS_EXP" envDefault:"15m"`
-	JWTRefreshExp time.Duration `env:"JWT_REFRESH_EXP" envDefault:"168h"`
+	// JWT_SECRET_KEY defaults to a development key, which is refused outside
+	// development.
+	JWTSecretKey string `env:"JWT_SECRET_KEY" envDefault:"supersecretkey" secret:"true"`
+	// JWT_PREVIOUS_SECRET_KEYS only verify tokens, so that the tokens signed
+	// with them remain valid after JWT_SECRET_KEY has been rotated. Tokens
+	// signed with a key listed in neither are rejected once reloaded.
+	JWTPreviousSecretKeys []string      `env:"JWT_PREVIOUS_SECRET_KEYS" envSeparator:"," secret:"true"`
+	JWTAccessExp          time.Duration `env:"JWT_ACCESS_EXP" envDefault:"15m"`
+	JWTRefreshExp         time.Duration `env:"JWT_REFRESH_EXP" envDefault:"168h"`
+	JWTIssuer             string        `env:"JWT_ISSUER" envDefault:"gophkeeper-auth"`
+	// JWT_AUDIENCE identifies this service, JWT_AUDIENCES lists the downstream
+	// services access tokens are also issued for.
+	JWTAudience  string        `env:"JWT_AUDIENCE" envDefault:"gophkeeper-auth"`
+	JWTAudiences []string      `env:"JWT_AUDIENCES" envDefault:"gophkeeper-vault" envSeparator:","`
+	JWTLeeway    time.Duration `env:"JWT_LEEWAY" envDefault:"30s"`
+
+	// OAUTH_CLIENTS lists "client_id:client_secret" pairs allowed to
+	// introspect and revoke the access tokens issued to them, that is the
+	// tokens they are an audience of, e.g. listed in JWT_AUDIENCES.
+	OAuthClients []string `env:"OAUTH_CLIENTS" envSeparator:"," secret:"true"`
+
+	// Device authorization grant
+	DeviceClientIDs       []string      `env:"DEVICE_CLIENT_IDS" envDefault:"gophkeeper-cli" envSeparator:","`
+	DeviceVerificationURI string        `env:"DEVICE_VERIFICATION_URI" envDefault:"http://localhost:8080/device"`
+	DeviceCodeExp         time.Duration `env:"DEVICE_CODE_EXP" envDefault:"10m"`
+	DevicePollInterval    time.Duration `env:"DEVICE_POLL_INTERVAL" envDefault:"5s"`
+
+	// OpenID Connect provider
+	OIDCEnabled bool `env:"OIDC_ENABLED" envDefault:"false"`
+	// OIDC_ISSUER_URL is the public URL of the service.
+	OIDCIssuerURL string `env:"OIDC_ISSUER_URL" envDefault:"http://localhost:8080"`
+	// OIDC_SIGNING_KEY_FILE is a PEM encoded RSA private key ID tokens are
+	// signed with. A temporary key is generated in development when it is empty.
+	OIDCSigningKeyFile string        `env:"OIDC_SIGNING_KEY_FILE"`
+	OIDCCodeExp        time.Duration `env:"OIDC_CODE_EXP" envDefault:"1m"`
+	OIDCIDTokenExp     time.Duration `env:"OIDC_ID_TOKEN_EXP" envDefault:"1h"`
+
+	// Login with upstream identity providers
+	// UPSTREAM_PROVIDERS_FILE is a JSON list of providers, federation is
+	// disabled when it is empty.
+	UpstreamProvidersFile string `env:"UPSTREAM_PROVIDERS_FILE"`
+	// PUBLIC_URL is the URL browsers reach the service at, the provider
+	// callback URLs are built from it: PUBLIC_URL/api/auth/v1/federation/<provider>/callback,
+	// and PUBLIC_URL/api/auth/federation/<provider>/callback for logins
+	// started from the deprecated routes.
+	PublicURL string `env:"PUBLIC_URL" envDefault:"http://localhost:8080"`
+	// FEDERATION_ALLOWED_ORIGINS are the origins users may return to after
+	// logging in, besides the service itself.
+	FederationAllowedOrigins []string      `env:"FEDERATION_ALLOWED_ORIGINS" envSeparator:","`
+	FederationLoginExp       time.Duration `env:"FEDERATION_LOGIN_EXP" envDefault:"10m"`
+
+	// REFRESH_TOKEN_FORMAT is either "jwt" or "opaque"
+	RefreshTokenFormat string `env:"REFRESH_TOKEN_FORMAT" envDefault:"jwt"`
 
 	// EMAIL_SENDER
-	SMTPHost     string `env:"SMTP_HOST" envDefault:"smtp.yandex.ru"`
+	// EMAIL_PROVIDERS lists the providers emails are sent through, the next
+	// one being tried when a provider fails: "smtp", "http", "maildir",
+	// "stdout" or "mailbox". It defaults to "mailbox" in development and
+	// "smtp" otherwise.
+	EmailProviders []string `env:"EMAIL_PROVIDERS" envSeparator:","`
+
+	SMTPHost     string `env:"SMTP_HOST"`
 	SMTPPort     string `env:"SMTP_PORT" envDefault:"587"`
-	SMTPUsername string `env:"SMTP_USERNAME" envDefault:"egor2001zhukov@yandex.ru"`
-	SMTPPassword string `env:"SMTP_PASSWORD" envDefault:"spotgfkmxtzdtqvc"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true"`
+	// SMTP_FROM is the sender address of all providers, SMTP_USERNAME is used
+	// when it is empty.
+	SMTPFrom     string `env:"SMTP_FROM"`
+	SMTPFromName string `env:"SMTP_FROM_NAME" envDefault:"auth"`
+	// SMTP_TLS_MODE is "starttls", "tls" for implicit TLS or "none".
+	SMTPTLSMode string        `env:"SMTP_TLS_MODE" envDefault:"starttls"`
+	SMTPTimeout time.Duration `env:"SMTP_TIMEOUT" envDefault:"10s"`
+	// EMAIL_API_URL is the endpoint of the "http" provider, see emailsender.HTTPSender.
+	EmailAPIURL     string        `env:"EMAIL_API_URL"`
+	EmailAPIKey     string        `env:"EMAIL_API_KEY" secret:"true"`
+	EmailAPITimeout time.Duration `env:"EMAIL_API_TIMEOUT" envDefault:"10s"`
+	// EMAIL_MAILDIR is the directory the "maildir" provider writes to.
+	EmailMaildir string `env:"EMAIL_MAILDIR" envDefault:"mail"`
+	// DEV_MAILBOX_SIZE is the number of messages kept by the "mailbox"
+	// provider, which is only available in development and served at /dev/mailbox.
+	DevMailboxSize int `env:"DEV_MAILBOX_SIZE" envDefault:"100"`
+
+	// Email outbox, see services.OutboxSettings
+	OutboxWorkers      int           `env:"OUTBOX_WORKERS" envDefault:"4"`
+	OutboxPollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL" envDefault:"2s"`
+	OutboxMaxAttempts  int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"8"`
+	OutboxBackoffBase  time.Duration `env:"OUTBOX_BACKOFF_BASE" envDefault:"10s"`
+	OutboxBackoffMax   time.Duration `env:"OUTBOX_BACKOFF_MAX" envDefault:"30m"`
+	OutboxLease        time.Duration `env:"OUTBOX_LEASE" envDefault:"2m"`
+	OutboxRetention    time.Duration `env:"OUTBOX_RETENTION" envDefault:"168h"`
+
+	// Email templates
+	// EMAIL_TEMPLATES_DIR overrides the embedded templates, see emailtemplates.Engine.
+	EmailTemplatesDir   string `env:"EMAIL_TEMPLATES_DIR"`
+	EmailDefaultLocale  string `env:"EMAIL_DEFAULT_LOCALE" envDefault:"en"`
+	EmailLogoURL        string `env:"EMAIL_LOGO_URL"`
+	EmailSupportAddress string `env:"EMAIL_SUPPORT_ADDRESS"`
+	EmailPrimaryColor   string `env:"EMAIL_PRIMARY_COLOR" envDefault:"#4f46e5"`
+
+	// files are the files the configuration has been read from, see Files.
+	files []string
 }
 
-func MustLoad() *Config {
-	cfg := new(Config)
-	err := env.Parse(cfg)
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	}
-	return cfg
+// Files returns the configuration file and the files secrets have been read
+// from, the configuration is reloaded when they change.
+func (cfg *Config) Files() []string {
+	return cfg.files
 }
@@ -0,0 +1,293 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"auth/internal/config"
+)
+
+// validSecretKey is long enough for JWT_SECRET_KEY outside development.
+const validSecretKey = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetenv unsets the variables for the duration of the test.
+func unsetenv(t *testing.T, envs ...string) {
+	t.Helper()
+	for _, env := range envs {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeFile(t, "config.yaml", "log_level: warn\njwt_secret_key: from-config-file\n")
+	secretFile := writeFile(t, "jwt_secret_key", "from-secret-file\n")
+	for _, tt := range []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		wantLogLevel  string
+		wantSecretKey string
+	}{
+		{
+			name:          "defaults",
+			wantLogLevel:  "info",
+			wantSecretKey: "supersecretkey",
+		},
+		{
+			name:          "file",
+			args:          []string{"-config", configFile},
+			wantLogLevel:  "warn",
+			wantSecretKey: "from-config-file",
+		},
+		{
+			name:          "file from CONFIG_FILE",
+			env:           map[string]string{"CONFIG_FILE": configFile},
+			wantLogLevel:  "warn",
+			wantSecretKey: "from-config-file",
+		},
+		{
+			name:          "env over file",
+			args:          []string{"-config", configFile},
+			env:           map[string]string{"LOG_LEVEL": "error", "JWT_SECRET_KEY": "from-env"},
+			wantLogLevel:  "error",
+			wantSecretKey: "from-env",
+		},
+		{
+			name:          "secret file over file",
+			args:          []string{"-config", configFile},
+			env:           map[string]string{"JWT_SECRET_KEY_FILE": secretFile},
+			wantLogLevel:  "warn",
+			wantSecretKey: "from-secret-file",
+		},
+		{
+			name:          "flags over env",
+			args:          []string{"-config", configFile, "-log-level", "debug", "-jwt-secret-key=from-flag"},
+			env:           map[string]string{"LOG_LEVEL": "error", "JWT_SECRET_KEY": "from-env"},
+			wantLogLevel:  "debug",
+			wantSecretKey: "from-flag",
+		},
+		{
+			name:          "flags over secret file",
+			args:          []string{"-jwt-secret-key=from-flag"},
+			env:           map[string]string{"JWT_SECRET_KEY_FILE": secretFile},
+			wantLogLevel:  "info",
+			wantSecretKey: "from-flag",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "CONFIG_FILE", "LOG_LEVEL", "JWT_SECRET_KEY", "JWT_SECRET_KEY_FILE")
+			for env, value := range tt.env {
+				t.Setenv(env, value)
+			}
+			cfg, _, err := config.Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.LogLevel != tt.wantLogLevel {
+				t.Errorf("LOG_LEVEL is %q, want %q", cfg.LogLevel, tt.wantLogLevel)
+			}
+			if cfg.JWTSecretKey != tt.wantSecretKey {
+				t.Errorf("JWT_SECRET_KEY is %q, want %q", cfg.JWTSecretKey, tt.wantSecretKey)
+			}
+		})
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "no newline", data: "secret", want: "secret"},
+		{name: "trailing newline", data: "secret\n", want: "secret"},
+		{name: "trailing CRLF", data: "secret\r\n", want: "secret"},
+		{name: "several trailing newlines", data: "secret\n\n", want: "secret"},
+		{name: "inner spaces kept", data: " sec ret \n", want: " sec ret "},
+		{name: "missing file", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "smtp_password")
+			if !tt.wantErr {
+				path = writeFile(t, "smtp_password", tt.data)
+			}
+			unsetenv(t, "SMTP_PASSWORD")
+			t.Setenv("SMTP_PASSWORD_FILE", path)
+			cfg, _, err := config.Load(nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Load succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.SMTPPassword != tt.want {
+				t.Errorf("SMTP_PASSWORD is %q, want %q", cfg.SMTPPassword, tt.want)
+			}
+			if !slices.Contains(cfg.Files(), path) {
+				t.Errorf("the files of the configuration are %v, want them to include %s", cfg.Files(), path)
+			}
+		})
+	}
+
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("SMTP_PASSWORD", "secret")
+		t.Setenv("SMTP_PASSWORD_FILE", writeFile(t, "smtp_password", "secret"))
+		if _, _, err := config.Load(nil); err == nil {
+			t.Error("Load succeeded with both SMTP_PASSWORD and SMTP_PASSWORD_FILE, want an error")
+		}
+	})
+
+	t.Run("not a secret", func(t *testing.T) {
+		unsetenv(t, "LOG_LEVEL")
+		t.Setenv("LOG_LEVEL_FILE", writeFile(t, "log_level", "debug"))
+		cfg, _, err := config.Load(nil)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if cfg.LogLevel != "info" {
+			t.Errorf("LOG_LEVEL is %q, want LOG_LEVEL_FILE ignored", cfg.LogLevel)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		// wantErr is a part of the expected error, empty if the
+		// configuration is valid.
+		wantErr string
+	}{
+		{
+			name: "development defaults",
+			args: []string{"-is-dev"},
+		},
+		{
+			name:    "default secret key",
+			args:    []string{"-email-providers=maildir"},
+			wantErr: "JWT_SECRET_KEY must be changed",
+		},
+		{
+			name:    "short secret key",
+			args:    []string{"-email-providers=maildir", "-jwt-secret-key=short-secret-key"},
+			wantErr: "JWT_SECRET_KEY must be at least 32 characters long",
+		},
+		{
+			name: "short secret key in development",
+			args: []string{"-is-dev", "-jwt-secret-key=short-secret-key"},
+		},
+		{
+			name: "production",
+			args: []string{"-email-providers=maildir", "-jwt-secret-key=" + validSecretKey},
+		},
+		{
+			name:    "mailbox in production",
+			args:    []string{"-email-providers=mailbox", "-jwt-secret-key=" + validSecretKey},
+			wantErr: "the mailbox email provider is only available in development",
+		},
+		{
+			name:    "stdout in production",
+			args:    []string{"-email-providers=stdout", "-jwt-secret-key=" + validSecretKey},
+			wantErr: "the stdout email provider is only available in development",
+		},
+		{
+			name:    "smtp without host",
+			args:    []string{"-email-providers=smtp", "-jwt-secret-key=" + validSecretKey},
+			wantErr: "SMTP_HOST is required",
+		},
+		{
+			name:    "invalid log level",
+			args:    []string{"-is-dev", "-log-level=loud"},
+			wantErr: "LOG_LEVEL must be one of",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "CONFIG_FILE", "IS_DEV", "JWT_SECRET_KEY", "JWT_SECRET_KEY_FILE", "EMAIL_PROVIDERS", "SMTP_HOST", "LOG_LEVEL")
+			cfg, _, err := config.Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			err = cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	unsetenv(t, "CONFIG_FILE", "JWT_SECRET_KEY", "JWT_SECRET_KEY_FILE", "JWT_PREVIOUS_SECRET_KEYS", "SMTP_PASSWORD", "SMTP_PASSWORD_FILE", "SMTP_HOST")
+	cfg, _, err := config.Load([]string{
+		"-jwt-secret-key=current-secret-key",
+		"-jwt-previous-secret-keys=previous-secret-key",
+		"-smtp-host=smtp.example.com",
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, tt := range []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: "yaml",
+			want: []string{
+				"jwt_secret_key: '[REDACTED]'\n",
+				"jwt_previous_secret_keys: '[REDACTED]'\n",
+				"smtp_password: \"\"\n",
+				"smtp_host: smtp.example.com\n",
+			},
+		},
+		{
+			format: "env",
+			want: []string{
+				"JWT_SECRET_KEY=[REDACTED]\n",
+				"JWT_PREVIOUS_SECRET_KEYS=[REDACTED]\n",
+				"SMTP_PASSWORD=\n",
+				"SMTP_HOST=smtp.example.com\n",
+			},
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			var b strings.Builder
+			if err := cfg.Print(&b, tt.format); err != nil {
+				t.Fatalf("Print: %v", err)
+			}
+			out := b.String()
+			for _, secret := range []string{"current-secret-key", "previous-secret-key"} {
+				if strings.Contains(out, secret) {
+					t.Errorf("the printed configuration contains the secret %q", secret)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("the printed configuration doesn't contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+
+	if err := cfg.Print(new(strings.Builder), "json"); err == nil {
+		t.Error("Print succeeded with an unknown format, want an error")
+	}
+}
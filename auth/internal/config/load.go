@@ -0,0 +1,296 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSuffix is appended to the variable of a secret to read it from a file,
+// e.g. JWT_SECRET_KEY_FILE=/run/secrets/jwt_secret_key.
+const fileSuffix = "_FILE"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a setting of Config, described by the tags of its struct field.
+type field struct {
+	// env is the name of the environment variable, the key in the
+	// configuration file is the same in lower case.
+	env          string
+	defaultValue string
+	separator    string
+	secret       bool
+	value        reflect.Value
+}
+
+func (f *field) key() string {
+	return strings.ToLower(f.env)
+}
+
+func (f *field) flagName() string {
+	return strings.ReplaceAll(f.key(), "_", "-")
+}
+
+func fields(cfg *Config) []*field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	fields := make([]*field, 0, t.NumField())
+	for i := range t.NumField() {
+		structField := t.Field(i)
+		env := structField.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+		separator := structField.Tag.Get("envSeparator")
+		if separator == "" {
+			separator = ","
+		}
+		fields = append(fields, &field{
+			env:          env,
+			defaultValue: structField.Tag.Get("envDefault"),
+			separator:    separator,
+			secret:       structField.Tag.Get("secret") == "true",
+			value:        v.Field(i),
+		})
+	}
+	return fields
+}
+
+// Load reads the configuration from, in increasing order of precedence, the
+// defaults, the configuration file, the environment and the command line
+// flags preceding the command. The file is given by the -config flag or
+// CONFIG_FILE, its keys are the names of the variables in lower case.
+// Secrets can be read from the file named by their variable with the _FILE
+// suffix. The arguments following the flags are returned.
+//
+// The configuration is not validated, see Validate.
+func Load(args []string) (*Config, []string, error) {
+	cfg := new(Config)
+	fields := fields(cfg)
+
+	flags := flag.NewFlagSet("auth", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "configuration file, YAML or TOML")
+	flagValues := map[string]string{}
+	for _, f := range fields {
+		usage := "overrides " + f.env
+		set := func(value string) error {
+			flagValues[f.env] = value
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			flags.BoolFunc(f.flagName(), usage, func(value string) error {
+				return set(value)
+			})
+		} else {
+			flags.Func(f.flagName(), usage, set)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return nil, nil, err
+	}
+
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		var err error
+		fileValues, err = readFile(*configFile, fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.files = append(cfg.files, *configFile)
+	}
+
+	for _, f := range fields {
+		value, path, err := lookup(f, fileValues, flagValues)
+		if err != nil {
+			return nil, nil, err
+		}
+		if path != "" {
+			cfg.files = append(cfg.files, path)
+		}
+		if err = setValue(f, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", f.env, err)
+		}
+	}
+	return cfg, flags.Args(), nil
+}
+
+// Changed returns the variables of the settings that differ between the
+// configurations.
+func (cfg *Config) Changed(other *Config) []string {
+	otherFields := fields(other)
+	var changed []string
+	for i, f := range fields(cfg) {
+		if !reflect.DeepEqual(f.value.Interface(), otherFields[i].value.Interface()) {
+			changed = append(changed, f.env)
+		}
+	}
+	return changed
+}
+
+// Retain sets the settings other than the given ones back to their values
+// in previous, and returns the variables of those that differed. A reloaded
+// configuration keeps the settings which can't change without a restart.
+func (cfg *Config) Retain(previous *Config, except []string) []string {
+	previousFields := fields(previous)
+	var retained []string
+	for i, f := range fields(cfg) {
+		if slices.Contains(except, f.env) {
+			continue
+		}
+		previousValue := previousFields[i].value
+		if !reflect.DeepEqual(f.value.Interface(), previousValue.Interface()) {
+			retained = append(retained, f.env)
+			f.value.Set(previousValue)
+		}
+	}
+	return retained
+}
+
+// lookup returns the value of the field from the source with the highest
+// precedence, and the path of the file it has been read from for secrets.
+func lookup(f *field, fileValues map[string]string, flagValues map[string]string) (string, string, error) {
+	if value, ok := flagValues[f.env]; ok {
+		return value, "", nil
+	}
+	value, ok := os.LookupEnv(f.env)
+	if f.secret {
+		path, fromFile := os.LookupEnv(f.env + fileSuffix)
+		if ok && fromFile {
+			return "", "", fmt.Errorf("both %s and %s%s are set", f.env, f.env, fileSuffix)
+		}
+		if fromFile {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to read %s%s: %w", f.env, fileSuffix, err)
+			}
+			return strings.TrimRight(string(data), "\r\n"), path, nil
+		}
+	}
+	if ok {
+		return value, "", nil
+	}
+	if value, ok := fileValues[f.key()]; ok {
+		return value, "", nil
+	}
+	return f.defaultValue, "", nil
+}
+
+func setValue(f *field, value string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case v.Kind() == reflect.Float64:
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(value, f.separator) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// readFile reads a YAML or TOML configuration file, depending on its
+// extension, into the string form the values have in the environment.
+func readFile(path string, fields []*field) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("configuration file %s is neither YAML nor TOML", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %s: %w", path, err)
+	}
+	byKey := map[string]*field{}
+	for _, f := range fields {
+		byKey[f.key()] = f
+	}
+	values := map[string]string{}
+	for key, value := range raw {
+		f, ok := byKey[strings.ToLower(key)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q in configuration file %s", key, path)
+		}
+		s, err := fileValue(value, f.separator)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in configuration file %s: %w", key, path, err)
+		}
+		values[f.key()] = s
+	}
+	return values, nil
+}
+
+func fileValue(value any, separator string) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			s, err := fileValue(item, separator)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, separator), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the values of secrets in the printed configuration.
+const redacted = "[REDACTED]"
+
+// Print writes the configuration as a YAML configuration file, or as
+// environment variables with format "env". Secrets are redacted, unless
+// they are empty.
+func (cfg *Config) Print(w io.Writer, format string) error {
+	fields := fields(cfg)
+	switch format {
+	case "yaml", "":
+		doc := &yaml.Node{Kind: yaml.MappingNode}
+		for _, f := range fields {
+			value := &yaml.Node{}
+			if err := value.Encode(printValue(f)); err != nil {
+				return err
+			}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key()}, value)
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "env":
+		for _, f := range fields {
+			value := printValue(f)
+			if items, ok := value.([]string); ok {
+				value = strings.Join(items, f.separator)
+			}
+			if _, err := fmt.Fprintf(w, "%s=%v\n", f.env, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected yaml or env", format)
+	}
+}
+
+func printValue(f *field) any {
+	if f.secret && f.value.Len() > 0 {
+		return redacted
+	}
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	if f.value.Kind() == reflect.Slice && f.value.IsNil() {
+		return []string{}
+	}
+	return f.value.Interface()
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minSecretKeyLength is the minimum length of JWT_SECRET_KEY outside
+// development, HS256 keys should have at least 256 bits.
+const minSecretKeyLength = 32
+
+// EmailProviderNames returns the email providers to use, see EMAIL_PROVIDERS.
+func (cfg *Config) EmailProviderNames() []string {
+	if len(cfg.EmailProviders) > 0 {
+		return cfg.EmailProviders
+	}
+	if cfg.IsDev {
+		return []string{"mailbox"}
+	}
+	return []string{"smtp"}
+}
+
+// Validate checks the configuration and reports all problems at once.
+// Outside development, secrets left at their development defaults and
+// settings meant for development only are refused.
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	oneOf := func(name string, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), "%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	}
+
+	oneOf("LOG_LEVEL", strings.ToLower(cfg.LogLevel), "debug", "info", "warn", "error")
+	oneOf("STORAGE_DRIVER", cfg.StorageDriver, "postgres", "sqlite", "memory")
+	oneOf("TRACING_EXPORTER", cfg.TracingExporter, "none", "otlp")
+	check(cfg.TracingSampleRatio >= 0 && cfg.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+	oneOf("REFRESH_TOKEN_FORMAT", cfg.RefreshTokenFormat, "jwt", "opaque")
+	check(cfg.JWTAccessExp > 0, "JWT_ACCESS_EXP must be positive")
+	check(cfg.JWTRefreshExp > 0, "JWT_REFRESH_EXP must be positive")
+	check(cfg.OutboxWorkers >= 1, "OUTBOX_WORKERS must be at least 1")
+	check(cfg.OutboxMaxAttempts >= 1, "OUTBOX_MAX_ATTEMPTS must be at least 1")
+	check(cfg.OutboxPollInterval > 0, "OUTBOX_POLL_INTERVAL must be positive")
+	check(cfg.ConfigWatchInterval >= 0, "CONFIG_WATCH_INTERVAL must not be negative")
+	_, err := time.Parse(time.DateOnly, cfg.LegacyAPISunset)
+	check(err == nil, "LEGACY_API_SUNSET must be a date formatted as YYYY-MM-DD, got %q", cfg.LegacyAPISunset)
+
+	providers := cfg.EmailProviderNames()
+	for _, provider := range providers {
+		oneOf("EMAIL_PROVIDERS", provider, "smtp", "http", "maildir", "stdout", "mailbox")
+	}
+	if slices.Contains(providers, "smtp") {
+		oneOf("SMTP_TLS_MODE", cfg.SMTPTLSMode, "starttls", "tls", "none")
+		check(cfg.SMTPHost != "", "SMTP_HOST is required by the smtp email provider")
+		check(cfg.SMTPFrom != "" || cfg.SMTPUsername != "", "SMTP_FROM or SMTP_USERNAME is required by the smtp email provider")
+	}
+	if slices.Contains(providers, "http") {
+		check(cfg.EmailAPIURL != "", "EMAIL_API_URL is required by the http email provider")
+	}
+
+	if !cfg.IsDev {
+		for _, f := range fields(cfg) {
+			if f.secret && f.defaultValue != "" {
+				check(f.value.String() != f.defaultValue, "%s must be changed from its development default", f.env)
+			}
+		}
+		check(len(cfg.JWTSecretKey) >= minSecretKeyLength, "JWT_SECRET_KEY must be at least %d characters long", minSecretKeyLength)
+		check(!slices.Contains(providers, "stdout"), "the stdout email provider is only available in development, it logs login codes")
+		check(!slices.Contains(providers, "mailbox"), "the mailbox email provider is only available in development")
+	}
+	return errors.Join(errs...)
+}
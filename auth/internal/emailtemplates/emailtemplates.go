@@ -0,0 +1,264 @@
+package emailtemplates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"auth/pkg/emailsender"
+
+	"golang.org/x/text/language"
+)
+
+// Names of the emails sent by the service.
+const (
+	// LoginCode is rendered with Code and ExpiresInMinutes.
+	LoginCode = "login_code"
+	// MagicLink is rendered with Link and ExpiresInMinutes.
+	MagicLink = "magic_link"
+	// NewDevice is rendered with Device, Location and IP.
+	NewDevice = "new_device"
+	// EmailChange is rendered with NewEmail and Link.
+	EmailChange = "email_change"
+	// AccountDeletion is rendered with Date and Link.
+	AccountDeletion = "account_deletion"
+)
+
+var Names = []string{LoginCode, MagicLink, NewDevice, EmailChange, AccountDeletion}
+
+//go:embed templates
+var embedded embed.FS
+
+// Branding is available to all templates.
+type Branding struct {
+	ProjectName  string
+	LogoURL      string
+	SupportEmail string
+	PrimaryColor string
+	PublicURL    string
+}
+
+// Engine renders the emails in the language of the recipient.
+//
+// Every email is made of the files <locale>/<name>.subject.txt,
+// <locale>/<name>.txt and <locale>/<name>.html, the HTML being wrapped into
+// layout.html. Files missing for a locale are taken from the default locale,
+// so a translation may cover only some of them.
+type Engine struct {
+	branding      Branding
+	defaultLocale string
+	locales       []string
+	matcher       language.Matcher
+	templates     map[string]map[string]*localized
+}
+
+type localized struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var htmlFuncs = htmltemplate.FuncMap{
+	"button": func(url string, label string, color string) map[string]string {
+		return map[string]string{"URL": url, "Label": label, "Color": color}
+	},
+}
+
+// New parses the templates. Templates in dir, which may be empty, take
+// precedence over the embedded ones, so that deployments can change the
+// wording or add locales.
+func New(dir string, defaultLocale string, branding Branding) (*Engine, error) {
+	fsys, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("email templates directory %s does not exist", dir)
+		}
+		fsys = overlayFS{upper: os.DirFS(dir), lower: fsys}
+	}
+	locales, err := listLocales(fsys)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		branding:      branding,
+		defaultLocale: defaultLocale,
+		templates:     map[string]map[string]*localized{},
+	}
+	// The default locale goes first, the matcher falls back to it.
+	tags := []language.Tag{}
+	for _, locale := range append([]string{defaultLocale}, locales...) {
+		if _, ok := e.templates[locale]; ok {
+			continue
+		}
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("invalid locale directory %q: %w", locale, err)
+		}
+		e.templates[locale] = map[string]*localized{}
+		for _, name := range Names {
+			t, err := e.parse(fsys, locale, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s template for locale %s: %w", name, locale, err)
+			}
+			e.templates[locale][name] = t
+		}
+		e.locales = append(e.locales, locale)
+		tags = append(tags, tag)
+	}
+	e.matcher = language.NewMatcher(tags)
+	return e, nil
+}
+
+func (e *Engine) parse(fsys fs.FS, locale string, name string) (*localized, error) {
+	file := func(name string) (string, error) {
+		for _, p := range []string{path.Join(locale, name), path.Join(e.defaultLocale, name)} {
+			if _, err := fs.Stat(fsys, p); err == nil {
+				return p, nil
+			}
+		}
+		return "", fmt.Errorf("%s is missing", path.Join(e.defaultLocale, name))
+	}
+	subjectFile, err := file(name + ".subject.txt")
+	if err != nil {
+		return nil, err
+	}
+	textFile, err := file(name + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	htmlFile, err := file(name + ".html")
+	if err != nil {
+		return nil, err
+	}
+	supportFile, err := file("support.html")
+	if err != nil {
+		return nil, err
+	}
+	t := &localized{}
+	if t.subject, err = texttemplate.ParseFS(fsys, subjectFile); err != nil {
+		return nil, err
+	}
+	if t.text, err = texttemplate.ParseFS(fsys, textFile); err != nil {
+		return nil, err
+	}
+	t.html, err = htmltemplate.New(name).Funcs(htmlFuncs).ParseFS(fsys, "layout.html", supportFile, htmlFile)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MatchLocale returns the supported locale closest to the preferred one.
+// The preference is a language tag or an Accept-Language header value.
+func (e *Engine) MatchLocale(preference string) string {
+	tags, _, err := language.ParseAcceptLanguage(preference)
+	if err != nil || len(tags) == 0 {
+		return e.defaultLocale
+	}
+	_, index, confidence := e.matcher.Match(tags...)
+	if confidence == language.No {
+		return e.defaultLocale
+	}
+	return e.locales[index]
+}
+
+// Render renders the named email in the locale matching the preference,
+// see MatchLocale. The recipients of the message are left for the caller.
+func (e *Engine) Render(name string, preference string, data map[string]any) (*emailsender.Message, error) {
+	locale := e.MatchLocale(preference)
+	t, ok := e.templates[locale][name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	values := map[string]any{
+		"ProjectName":  e.branding.ProjectName,
+		"LogoURL":      e.branding.LogoURL,
+		"SupportEmail": e.branding.SupportEmail,
+		"PrimaryColor": e.branding.PrimaryColor,
+		"PublicURL":    e.branding.PublicURL,
+		"Locale":       locale,
+	}
+	for key, value := range data {
+		values[key] = value
+	}
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	msg := &emailsender.Message{Subject: strings.TrimSpace(buf.String())}
+	values["Subject"] = msg.Subject
+
+	buf.Reset()
+	if err := t.text.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	msg.Text = buf.String()
+
+	buf.Reset()
+	if err := t.html.ExecuteTemplate(&buf, "layout", values); err != nil {
+		return nil, err
+	}
+	msg.HTML = buf.String()
+	return msg, nil
+}
+
+// listLocales returns the locale directories.
+func listLocales(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	locales := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			locales = append(locales, entry.Name())
+		}
+	}
+	return locales, nil
+}
+
+// overlayFS serves files of upper and, where upper has none, of lower.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir merges the entries of both file systems.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(o.lower, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	upper, err := fs.ReadDir(o.upper, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		seen[entry.Name()] = true
+	}
+	for _, entry := range upper {
+		if !seen[entry.Name()] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
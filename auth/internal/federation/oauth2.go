@@ -0,0 +1,120 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"auth/internal/services"
+
+	"golang.org/x/oauth2"
+)
+
+// oauth2Provider logs users in with a provider which only supports plain
+// OAuth2. The identity is read from its userinfo endpoint.
+type oauth2Provider struct {
+	base
+	config *oauth2.Config
+}
+
+func newOAuth2Provider(cfg ProviderConfig) (*oauth2Provider, error) {
+	if cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "" {
+		return nil, fmt.Errorf("provider %s: auth_url, token_url and userinfo_url are required", cfg.Name)
+	}
+	if cfg.SubjectField == "" {
+		cfg.SubjectField = "sub"
+	}
+	if cfg.EmailField == "" {
+		cfg.EmailField = "email"
+	}
+	if cfg.EmailVerifiedField == "" && !cfg.TrustEmail {
+		cfg.EmailVerifiedField = "email_verified"
+	}
+	return &oauth2Provider{
+		base: base{
+			cfg:        cfg,
+			httpClient: &http.Client{Timeout: requestTimeout},
+		},
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  cfg.AuthURL,
+				TokenURL: cfg.TokenURL,
+			},
+			Scopes: cfg.Scopes,
+		},
+	}, nil
+}
+
+func (p *oauth2Provider) configFor(redirectURI string) *oauth2.Config {
+	config := *p.config
+	config.RedirectURL = redirectURI
+	return &config
+}
+
+func (p *oauth2Provider) AuthCodeURL(_ context.Context, state string, _ string, verifier string, redirectURI string) (string, error) {
+	return p.configFor(redirectURI).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *oauth2Provider) Exchange(ctx context.Context, code string, verifier string, _ string, redirectURI string) (*services.UpstreamIdentity, error) {
+	config := p.configFor(redirectURI)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	userInfo, err := p.fetchUserInfo(ctx, config.Client(ctx, token))
+	if err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	identity := &services.UpstreamIdentity{
+		Subject:       fieldString(userInfo[p.cfg.SubjectField]),
+		Email:         fieldString(userInfo[p.cfg.EmailField]),
+		EmailVerified: p.cfg.TrustEmail || parseBool(userInfo[p.cfg.EmailVerifiedField]),
+	}
+	if identity.Subject == "" {
+		return nil, providerError(ctx, p.cfg.Name, fmt.Errorf("userinfo has no %s field", p.cfg.SubjectField))
+	}
+	if err = p.checkEmailDomain(identity.Email); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (p *oauth2Provider) fetchUserInfo(ctx context.Context, client *http.Client) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo request failed with status %d", resp.StatusCode)
+	}
+	userInfo := map[string]any{}
+	decoder := json.NewDecoder(resp.Body)
+	// Numeric ids, e.g. of GitHub, must not be turned into floats.
+	decoder.UseNumber()
+	if err = decoder.Decode(&userInfo); err != nil {
+		return nil, fmt.Errorf("invalid userinfo response: %w", err)
+	}
+	return userInfo, nil
+}
+
+// fieldString returns a string or numeric userinfo field as a string.
+func fieldString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
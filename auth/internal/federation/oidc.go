@@ -0,0 +1,133 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"auth/internal/services"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider logs users in with an OpenID Connect provider. The identity is
+// taken from the verified ID token.
+type oidcProvider struct {
+	base
+
+	mu sync.Mutex
+	// provider is discovered on the first login, so that an unreachable
+	// provider does not prevent the service from starting.
+	provider *oidc.Provider
+}
+
+func newOIDCProvider(cfg ProviderConfig) (*oidcProvider, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("provider %s: issuer is required", cfg.Name)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email"}
+	}
+	if !slices.Contains(cfg.Scopes, oidc.ScopeOpenID) {
+		cfg.Scopes = append([]string{oidc.ScopeOpenID}, cfg.Scopes...)
+	}
+	return &oidcProvider{
+		base: base{
+			cfg:        cfg,
+			httpClient: &http.Client{Timeout: requestTimeout},
+		},
+	}, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	// The provider keeps the context to fetch its keys later, so it must not
+	// be the context of the request.
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.WithoutCancel(ctx), p.httpClient), p.cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *oidcProvider) config(provider *oidc.Provider, redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURI,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string, redirectURI string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", providerError(ctx, p.cfg.Name, err)
+	}
+	return p.config(provider, redirectURI).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, verifier string, nonce string, redirectURI string) (*services.UpstreamIdentity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	ctx = oidc.ClientContext(ctx, p.httpClient)
+	token, err := p.config(provider, redirectURI).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, providerError(ctx, p.cfg.Name, errors.New("token response has no id_token"))
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, providerError(ctx, p.cfg.Name, errors.New("id_token nonce mismatch"))
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+	}
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, providerError(ctx, p.cfg.Name, err)
+	}
+	identity := &services.UpstreamIdentity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: parseBool(claims.EmailVerified),
+	}
+	// Providers may leave the email out of the ID token and only return it
+	// from the userinfo endpoint.
+	if identity.Email == "" && provider.UserInfoEndpoint() != "" {
+		userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, providerError(ctx, p.cfg.Name, err)
+		}
+		if userInfo.Subject != idToken.Subject {
+			return nil, providerError(ctx, p.cfg.Name, errors.New("userinfo subject mismatch"))
+		}
+		identity.Email = userInfo.Email
+		identity.EmailVerified = userInfo.EmailVerified
+	}
+	if p.cfg.TrustEmail {
+		identity.EmailVerified = true
+	}
+	if err = p.checkEmailDomain(identity.Email); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
@@ -0,0 +1,351 @@
+package federation_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/federation"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/httperror"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	stubClientID     = "atlas"
+	stubClientSecret = "stub-secret"
+	stubKeyID        = "stub-key"
+	stubRedirectURI  = "http://auth.test/api/auth/v1/federation/stub/callback"
+)
+
+// stubAccount is the account the stub IdP logs in.
+type stubAccount struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// stubAuthorization is an authorization code issued by the stub IdP.
+type stubAuthorization struct {
+	nonce     string
+	challenge string
+}
+
+// stubIdP is a minimal OpenID Connect provider. Its authorization endpoint
+// logs the account in without a login page and redirects back at once.
+type stubIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	account stubAccount
+	codes   map[string]stubAuthorization
+	// nonce, if set, replaces the nonce of the authorization request in the ID token.
+	nonce string
+}
+
+func newStubIdP(t *testing.T, account stubAccount) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the signing key: %v", err)
+	}
+	idp := &stubIdP{key: key, account: account, codes: map[string]stubAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *stubIdP) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *stubIdP) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": stubKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *stubIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != stubClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code := uuid.NewString()
+	idp.mu.Lock()
+	idp.codes[code] = stubAuthorization{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	idp.mu.Unlock()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirectURI.RawQuery = url.Values{"state": {query.Get("state")}, "code": {code}}.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != stubClientID || clientSecret != stubClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	idp.mu.Lock()
+	authorization, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	account, nonce := idp.account, idp.nonce
+	idp.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if nonce == "" {
+		nonce = authorization.nonce
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.URL,
+		"sub":            account.Subject,
+		"aud":            stubClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          account.Email,
+		"email_verified": account.EmailVerified,
+	})
+	token.Header["kid"] = stubKeyID
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": uuid.NewString(),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// federationTest is a federation service logging in with a stub IdP.
+type federationTest struct {
+	idp         *stubIdP
+	store       *memory.MemoryStorage
+	authService *services.AuthService
+	service     *services.FederationService
+}
+
+func newFederationTest(t *testing.T, account stubAccount) *federationTest {
+	t.Helper()
+	idp := newStubIdP(t, account)
+	provider, err := federation.NewProvider(federation.ProviderConfig{
+		Name:         "stub",
+		Type:         federation.TypeOIDC,
+		ClientID:     stubClientID,
+		ClientSecret: stubClientSecret,
+		Issuer:       idp.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the provider: %v", err)
+	}
+	store := memory.New()
+	sessionService := services.NewSessionService(services.TokenSettings{
+		SecretKey:          "federation-test-secret-key",
+		AccessExp:          time.Minute,
+		RefreshExp:         time.Hour,
+		RefreshTokenFormat: services.RefreshTokenFormatOpaque,
+		Issuer:             "auth",
+		Audience:           "auth",
+	}, store)
+	authService := services.NewAuthService(store, nil, nil)
+	return &federationTest{
+		idp:         idp,
+		store:       store,
+		authService: authService,
+		service: services.NewFederationService(
+			services.FederationSettings{PublicURL: "http://auth.test", LoginExp: time.Minute},
+			[]services.UpstreamProvider{provider},
+			store,
+			authService,
+			sessionService,
+		),
+	}
+}
+
+// authorize starts a login and follows it to the stub IdP, returning the
+// login state and the parameters of the redirect back to the service.
+func (ft *federationTest) authorize(t *testing.T) (string, url.Values) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, loginState, err := ft.service.StartLogin(ctx, "stub", stubRedirectURI, "")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization request returned %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback URL: %v", err)
+	}
+	return loginState, callback.Query()
+}
+
+func (ft *federationTest) finish(t *testing.T, loginState string, callback url.Values) (*services.Tokens, error) {
+	t.Helper()
+	tokens, _, err := ft.service.FinishLogin(
+		context.Background(), "stub", loginState, callback.Get("state"), callback.Get("code"),
+		stubRedirectURI, "federation-test", "127.0.0.1",
+	)
+	return tokens, err
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	account := stubAccount{Subject: "stub-user", Email: "Alice@Example.com", EmailVerified: true}
+	ft := newFederationTest(t, account)
+	user, _, err := ft.authService.GetOrCreateUser(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("failed to create the user: %v", err)
+	}
+
+	loginState, callback := ft.authorize(t)
+	tokens, err := ft.finish(t, loginState, callback)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Error("FinishLogin returned no tokens")
+	}
+	identity, err := ft.store.GetIdentity(ctx, "stub", account.Subject)
+	if err != nil {
+		t.Fatalf("the identity is not linked: %v", err)
+	}
+	if identity.UserID != user.ID {
+		t.Errorf("the identity is linked to user %s, want the user with the same email %s", identity.UserID, user.ID)
+	}
+
+	// The next login finds the user by the subject.
+	loginState, callback = ft.authorize(t)
+	if _, err = ft.finish(t, loginState, callback); err != nil {
+		t.Fatalf("FinishLogin of a linked identity: %v", err)
+	}
+	identities, err := ft.store.GetIdentitiesList(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetIdentitiesList: %v", err)
+	}
+	if len(identities) != 1 {
+		t.Errorf("the user has %d identities, want 1", len(identities))
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	account := stubAccount{Subject: "stub-user", Email: "alice@example.com", EmailVerified: false}
+	ft := newFederationTest(t, account)
+	user, _, err := ft.authService.GetOrCreateUser(ctx, account.Email)
+	if err != nil {
+		t.Fatalf("failed to create the user: %v", err)
+	}
+
+	loginState, callback := ft.authorize(t)
+	_, err = ft.finish(t, loginState, callback)
+	if code := httperror.GetCode(err); code != httperror.CodeEmailNotVerified {
+		t.Fatalf("FinishLogin returned %v (code %q), want code %q", err, code, httperror.CodeEmailNotVerified)
+	}
+	if _, err = ft.store.GetIdentity(ctx, "stub", account.Subject); !httperror.IsNotFound(err) {
+		t.Errorf("an unverified identity is linked: %v", err)
+	}
+	identities, err := ft.store.GetIdentitiesList(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetIdentitiesList: %v", err)
+	}
+	if len(identities) != 0 {
+		t.Errorf("the user has %d identities, want none", len(identities))
+	}
+}
+
+func TestOIDCLoginStateMismatch(t *testing.T) {
+	account := stubAccount{Subject: "stub-user", Email: "alice@example.com", EmailVerified: true}
+
+	t.Run("state", func(t *testing.T) {
+		ft := newFederationTest(t, account)
+		loginState, callback := ft.authorize(t)
+		// The callback of another login must not complete this one.
+		otherLoginState, _ := ft.authorize(t)
+		_, err := ft.finish(t, otherLoginState, callback)
+		if code := httperror.GetCode(err); code != httperror.CodeLoginStateMismatch {
+			t.Fatalf("FinishLogin returned %v (code %q), want code %q", err, code, httperror.CodeLoginStateMismatch)
+		}
+		// Nor a tampered login state.
+		_, err = ft.finish(t, loginState+"x", callback)
+		if code := httperror.GetCode(err); code != httperror.CodeLoginExpired {
+			t.Fatalf("FinishLogin returned %v (code %q), want code %q", err, code, httperror.CodeLoginExpired)
+		}
+	})
+
+	t.Run("nonce", func(t *testing.T) {
+		ft := newFederationTest(t, account)
+		ft.idp.nonce = "replayed-nonce"
+		loginState, callback := ft.authorize(t)
+		_, err := ft.finish(t, loginState, callback)
+		if code := httperror.GetCode(err); code != httperror.CodeProviderError {
+			t.Fatalf("FinishLogin returned %v (code %q), want code %q", err, code, httperror.CodeProviderError)
+		}
+		if _, err = ft.store.GetIdentity(context.Background(), "stub", account.Subject); !httperror.IsNotFound(err) {
+			t.Errorf("the identity is linked despite the nonce mismatch: %v", err)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/internal/services"
+	"auth/pkg/httperror"
+)
+
+// Provider types.
+const (
+	TypeOIDC   = "oidc"
+	TypeOAuth2 = "oauth2"
+)
+
+// requestTimeout bounds every request made to a provider.
+const requestTimeout = 10 * time.Second
+
+// Names are part of the login and callback paths.
+var nameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ProviderConfig describes an upstream provider in the providers file.
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Type         string   `json:"type"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+	// AllowedDomains restricts the login to emails of the given domains,
+	// e.g. for a corporate IdP which also has guest accounts.
+	AllowedDomains []string `json:"allowed_domains"`
+
+	// Issuer is the URL the OpenID Connect configuration is discovered at.
+	Issuer string `json:"issuer"`
+
+	// Endpoints and claim names of plain OAuth2 providers.
+	AuthURL            string `json:"auth_url"`
+	TokenURL           string `json:"token_url"`
+	UserInfoURL        string `json:"userinfo_url"`
+	SubjectField       string `json:"subject_field"`
+	EmailField         string `json:"email_field"`
+	EmailVerifiedField string `json:"email_verified_field"`
+	// TrustEmail treats all emails as verified, for providers which only
+	// return verified emails but do not say so.
+	TrustEmail bool `json:"trust_email"`
+}
+
+// LoadProviders reads the JSON list of providers. Client secrets missing from
+// the file are read from the UPSTREAM_<NAME>_CLIENT_SECRET environment
+// variables, so that the file can be kept without secrets.
+func LoadProviders(path string) ([]services.UpstreamProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers file: %w", err)
+	}
+	var configs []ProviderConfig
+	if err = json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse providers file %s: %w", path, err)
+	}
+	providers := make([]services.UpstreamProvider, 0, len(configs))
+	names := map[string]bool{}
+	for _, cfg := range configs {
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("provider %q is configured twice", cfg.Name)
+		}
+		names[cfg.Name] = true
+		provider, err := NewProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// NewProvider returns the provider described by cfg.
+func NewProvider(cfg ProviderConfig) (services.UpstreamProvider, error) {
+	if !nameRegexp.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid provider name %q", cfg.Name)
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("provider %s: client_id is required", cfg.Name)
+	}
+	if cfg.ClientSecret == "" {
+		cfg.ClientSecret = os.Getenv("UPSTREAM_" + strings.ToUpper(strings.ReplaceAll(cfg.Name, "-", "_")) + "_CLIENT_SECRET")
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	switch cfg.Type {
+	case TypeOIDC:
+		return newOIDCProvider(cfg)
+	case TypeOAuth2:
+		return newOAuth2Provider(cfg)
+	default:
+		return nil, fmt.Errorf("provider %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// base holds what all provider types have in common.
+type base struct {
+	cfg        ProviderConfig
+	httpClient *http.Client
+}
+
+func (b *base) Name() string {
+	return b.cfg.Name
+}
+
+func (b *base) DisplayName() string {
+	return b.cfg.DisplayName
+}
+
+// checkEmailDomain refuses emails outside of the allowed domains.
+func (b *base) checkEmailDomain(email string) error {
+	if len(b.cfg.AllowedDomains) == 0 {
+		return nil
+	}
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	if !slices.Contains(b.cfg.AllowedDomains, domain) {
+		return httperror.NewWithCode(nil, httperror.CodeEmailDomainNotAllowed, "The email domain is not allowed for this identity provider", http.StatusForbidden)
+	}
+	return nil
+}
+
+// providerError masks errors of talking to the provider, they are not useful
+// for the user. They are logged here, as the masked error does not print its cause.
+func providerError(ctx context.Context, provider string, err error) error {
+	slog.WarnContext(ctx, "upstream provider request failed", "provider", provider, "error", err)
+	return httperror.NewWithCode(
+		fmt.Errorf("provider %s: %w", provider, err),
+		httperror.CodeProviderError,
+		"Failed to log in with the identity provider",
+		http.StatusBadGateway,
+	)
+}
+
+// parseBool accepts booleans and, as sent by some providers, their strings.
+func parseBool(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
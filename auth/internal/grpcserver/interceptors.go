@@ -0,0 +1,79 @@
+package grpcserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"auth/internal/api/inmiddlewares"
+	"auth/internal/logger"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const requestIDMetadataKey = "x-request-id"
+
+func requestIDInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDMetadataKey); len(values) > 0 && inmiddlewares.IsValidRequestID(values[0]) {
+			requestID = values[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	ctx = logger.WithRequestID(ctx, requestID)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, requestID))
+	return handler(ctx, req)
+}
+
+// errorInterceptor converts HTTPErrors into gRPC statuses carrying their
+// codes, other errors are logged and masked.
+func errorInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	if _, ok := status.FromError(err); !ok {
+		slog.ErrorContext(ctx, "grpc request failed", "method", info.FullMethod, "error", err)
+	}
+	return resp, httperror.ToGRPC(err)
+}
+
+func loggingInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	slog.Log(
+		ctx,
+		level,
+		"grpc request",
+		"method", info.FullMethod,
+		"grpc_code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
@@ -0,0 +1,53 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"auth/internal/logger"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDInterceptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		reused    bool
+	}{
+		{name: "valid", requestID: "request-1", reused: true},
+		{name: "missing"},
+		{name: "too long", requestID: strings.Repeat("a", 129)},
+		{name: "line break", requestID: "request-1\nlevel=ERROR"},
+		{name: "space", requestID: "request 1"},
+		{name: "non-ASCII", requestID: "requête"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.requestID != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(requestIDMetadataKey, tt.requestID))
+			}
+			var got string
+			_, err := requestIDInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ any) (any, error) {
+				got = logger.RequestIDFromContext(ctx)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("requestIDInterceptor: %v", err)
+			}
+			if tt.reused {
+				if got != tt.requestID {
+					t.Errorf("the request id is %q, want %q", got, tt.requestID)
+				}
+				return
+			}
+			if err := uuid.Validate(got); err != nil {
+				t.Errorf("the request id %q replacing %q is not a generated UUID", got, tt.requestID)
+			}
+		})
+	}
+}
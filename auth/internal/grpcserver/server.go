@@ -2,14 +2,20 @@ package grpcserver
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 
+	"auth/internal/metrics"
 	"auth/internal/services"
+	"auth/pkg/httperror"
 	pb "auth/proto"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 type gprcAuthServer struct {
@@ -17,33 +23,68 @@ type gprcAuthServer struct {
 
 	Addr           string
 	sessionService *services.SessionService
+	server         *grpc.Server
+	health         *health.Server
 }
 
 func NewAuthGRPCServer(addr string, sessionService *services.SessionService) *gprcAuthServer {
-	return &gprcAuthServer{
-
+	s := &gprcAuthServer{
 		Addr:           addr,
 		sessionService: sessionService,
+		health:         health.NewServer(),
 	}
+	s.server = grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(requestIDInterceptor, loggingInterceptor, metrics.UnaryServerInterceptor, errorInterceptor),
+	)
+	pb.RegisterAuthServer(s.server, s)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	reflection.Register(s.server)
+	return s
 }
 
 func (s *gprcAuthServer) AuthUser(ctx context.Context, req *pb.AuthUserRequest) (*pb.AuthUserResponse, error) {
-	claims, err := s.sessionService.ParseToken(req.Token)
+	audiences := s.sessionService.KnownAudiences()
+	if req.Audience != "" {
+		audiences = []string{req.Audience}
+	}
+	claims, err := s.sessionService.ParseAccessToken(req.Token, audiences...)
 	if err != nil {
-		return nil, err
+		metrics.TokenValidations.WithLabelValues(metrics.ResultInvalid).Inc()
+		return nil, httperror.NewWithCode(err, httperror.CodeInvalidToken, "Invalid token", http.StatusUnauthorized)
 	}
+	metrics.TokenValidations.WithLabelValues(metrics.ResultValid).Inc()
 	return &pb.AuthUserResponse{UserId: claims.UserID.String()}, nil
 }
 
-func (s *gprcAuthServer) Run() {
+// Run serves gRPC requests until Shutdown is called.
+func (s *gprcAuthServer) Run() error {
 	listen, err := net.Listen("tcp", s.Addr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	server := grpc.NewServer()
-	pb.RegisterAuthServer(server, s)
-	log.Printf("gRPC server is running at %s\n", s.Addr)
-	if err := server.Serve(listen); err != nil {
-		log.Fatal(err)
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(pb.Auth_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	slog.Info("gRPC server is running", "addr", s.Addr)
+	return s.server.Serve(listen)
+}
+
+// SetNotServing reports every service as not serving to health checking clients.
+func (s *gprcAuthServer) SetNotServing() {
+	s.health.Shutdown()
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests.
+func (s *gprcAuthServer) Shutdown(ctx context.Context) {
+	s.health.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
 	}
 }
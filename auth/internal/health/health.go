@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// Check reports whether a single dependency of the service is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker aggregates readiness checks and tracks the shutdown state.
+type Checker struct {
+	timeout      time.Duration
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check. It must be called before the checker is used.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// SetShuttingDown makes every following readiness check fail.
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Check runs all registered checks concurrently and returns the errors by check name.
+func (c *Checker) Check(ctx context.Context) map[string]error {
+	results := make(map[string]error, len(c.checks)+1)
+	if c.shuttingDown.Load() {
+		results["shutdown"] = ErrShuttingDown
+		return results
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := nc.check(ctx)
+			mu.Lock()
+			results[nc.name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
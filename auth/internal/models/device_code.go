@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device code statuses.
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+)
+
+// DeviceCode is a pending OAuth 2.0 device authorization (RFC 8628).
+type DeviceCode struct {
+	ID             uuid.UUID     `db:"id"`
+	DeviceCodeHash string        `db:"device_code_hash"`
+	UserCode       string        `db:"user_code"`
+	ClientID       string        `db:"client_id"`
+	Scope          string        `db:"scope"`
+	Status         string        `db:"status"`
+	UserID         uuid.NullUUID `db:"user_id"`
+	ExpiresAt      time.Time     `db:"expires_at"`
+	LastPolledAt   time.Time     `db:"last_polled_at"`
+	// IntervalSeconds is the minimum time the client has to wait between polls.
+	IntervalSeconds int `db:"interval_seconds"`
+}
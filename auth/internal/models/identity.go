@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links a user to an account at an upstream identity provider.
+type Identity struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	UserID   uuid.UUID `db:"user_id" json:"-"`
+	Provider string    `db:"provider" json:"provider"`
+	// Subject identifies the account at the provider, it never changes
+	// unlike the email.
+	Subject   string    `db:"subject" json:"-"`
+	Email     string    `db:"email" json:"email"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthClient is an application registered to log users in through the
+// OpenID Connect provider.
+type OAuthClient struct {
+	ID string `db:"id"`
+	// SecretHash is empty for public clients, which can't keep a secret
+	// and authenticate with PKCE only.
+	SecretHash   string    `db:"secret_hash"`
+	Name         string    `db:"name"`
+	RedirectURIs []string  `db:"redirect_uris"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+func (c *OAuthClient) HasRedirectURI(redirectURI string) bool {
+	return slices.Contains(c.RedirectURIs, redirectURI)
+}
+
+// AuthorizationCode is issued to a client once the user has authenticated
+// and consented, and is exchanged for tokens at the token endpoint.
+type AuthorizationCode struct {
+	CodeHash  string    `db:"code_hash"`
+	ClientID  string    `db:"client_id"`
+	UserID    uuid.UUID `db:"user_id"`
+	SessionID uuid.UUID `db:"session_id"`
+	// RedirectURI is empty if it has been omitted from the authorization request.
+	RedirectURI   string    `db:"redirect_uri"`
+	Scope         string    `db:"scope"`
+	Nonce         string    `db:"nonce"`
+	CodeChallenge string    `db:"code_challenge"`
+	ExpiresAt     time.Time `db:"expires_at"`
+}
+
+// Consent records the scopes a user has allowed a client to access.
+type Consent struct {
+	UserID    uuid.UUID `db:"user_id"`
+	ClientID  string    `db:"client_id"`
+	Scope     string    `db:"scope"`
+	CreatedAt time.Time `db:"created_at"`
+}
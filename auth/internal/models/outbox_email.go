@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox email statuses.
+const (
+	OutboxEmailStatusPending = "pending"
+	OutboxEmailStatusSent    = "sent"
+	// OutboxEmailStatusDead marks emails given up on, they are kept for
+	// inspection without their content.
+	OutboxEmailStatusDead = "dead"
+)
+
+// OutboxEmail is an email waiting to be sent or already handled by the
+// outbox worker. It is written in the same transaction as the data it is
+// about, so that no email is lost or sent for a rolled back change.
+type OutboxEmail struct {
+	ID uuid.UUID `db:"id"`
+	// ReferenceID is the object the email is about, e.g. the email code, its
+	// delivery is looked up by it.
+	ReferenceID   uuid.NullUUID `db:"reference_id"`
+	Recipient     string        `db:"recipient"`
+	Subject       string        `db:"subject"`
+	TextBody      string        `db:"text_body"`
+	HTMLBody      string        `db:"html_body"`
+	Status        string        `db:"status"`
+	Attempts      int           `db:"attempts"`
+	NextAttemptAt time.Time     `db:"next_attempt_at"`
+	LastError     string        `db:"last_error"`
+	CreatedAt     time.Time     `db:"created_at"`
+	UpdatedAt     time.Time     `db:"updated_at"`
+}
@@ -9,7 +9,7 @@ import (
 
 type Session struct {
 	ID         uuid.UUID `db:"id" json:"id"`
-	Token      string    `db:"token" json:"-"`
+	TokenHash  string    `db:"token_hash" json:"-"`
 	UserID     uuid.UUID `db:"user_id" json:"-"`
 	IP         net.IP    `db:"ip" json:"-"`
 	Location   string    `db:"location" json:"location"`
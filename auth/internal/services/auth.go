@@ -2,50 +2,79 @@ package services
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"regexp"
 	"time"
 
+	"auth/internal/emailtemplates"
+	"auth/internal/metrics"
 	"auth/internal/models"
-	"auth/pkg/emailsender"
 	"auth/pkg/httperror"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("auth/internal/services")
+
 var emailRegexp = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
 
+// maxEmailCodeAttempts is the number of checks allowed for a single email code.
+const maxEmailCodeAttempts = 3
+
+// emailCodeExp is the time an email code can be used for.
+const emailCodeExp = 15 * time.Minute
+
+// Transactor runs fn in a single storage transaction. Store calls made with
+// the context passed to fn take part in that transaction.
+type Transactor interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 type AuthStore interface {
+	Transactor
+
 	InsertEmailCode(ctx context.Context, emailCode *models.EmailCode) error
-	UpdateEmailCode(ctx context.Context, emailCode *models.EmailCode) error
+	IncrementEmailCodeAttempts(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error)
+	ConsumeEmailCode(ctx context.Context, emailCodeID uuid.UUID) error
 	DeleteEmailCode(ctx context.Context, emailCodeID uuid.UUID) error
 	GetEmailCodeByID(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error)
 
-	InsertUser(ctx context.Context, user *models.User) error
+	UpsertUser(ctx context.Context, user *models.User) (*models.User, bool, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 }
 
 type AuthService struct {
-	AuthStore   AuthStore
-	emailSender emailsender.IEmailSender
+	AuthStore      AuthStore
+	outbox         *OutboxService
+	emailTemplates *emailtemplates.Engine
 }
 
-func NewAuthService(authStore AuthStore, emailSender emailsender.IEmailSender) *AuthService {
+func NewAuthService(
+	authStore AuthStore,
+	outbox *OutboxService,
+	emailTemplates *emailtemplates.Engine,
+) *AuthService {
 	return &AuthService{
-		AuthStore:   authStore,
-		emailSender: emailSender,
+		AuthStore:      authStore,
+		outbox:         outbox,
+		emailTemplates: emailTemplates,
 	}
 }
 
+// GenerateEmailCode queues a login code for the email. The email is written in
+// the locale, a language tag or an Accept-Language value, if it is supported.
+// Its delivery is reported by GetEmailCodeDelivery.
 func (as *AuthService) GenerateEmailCode(
 	ctx context.Context,
 	email string,
+	locale string,
 ) (*models.EmailCode, error) {
 	is_valid := emailRegexp.MatchString(email)
 	if !is_valid {
-		return nil, httperror.New(nil, "Email is not valid", http.StatusBadRequest)
+		return nil, httperror.NewWithCode(nil, httperror.CodeInvalidEmail, "Email is not valid", http.StatusBadRequest)
 	}
 	randInt := rand.Intn(10000)
 	if randInt < 1000 {
@@ -54,89 +83,133 @@ func (as *AuthService) GenerateEmailCode(
 	emailCode := &models.EmailCode{
 		ID:        uuid.New(),
 		Email:     email,
-		Code:      uint16(randInt),                  // Генерация 4-значного кода
-		ExpiresAt: time.Now().Add(15 * time.Minute), // Время действия кода
+		Code:      uint16(randInt),              // Генерация 4-значного кода
+		ExpiresAt: time.Now().Add(emailCodeExp), // Время действия кода
 	}
-	err := as.AuthStore.InsertEmailCode(
-		ctx,
-		emailCode,
-	)
+	msg, err := as.emailTemplates.Render(emailtemplates.LoginCode, locale, map[string]any{
+		"Code":             emailCode.Code,
+		"ExpiresInMinutes": int(emailCodeExp.Minutes()),
+	})
 	if err != nil {
 		return nil, err
 	}
-	as.emailSender.Send(
-		"Вход в приложение auth",
-		fmt.Sprintf("Ваш код подтверждения в моб. приложении auth - %v", emailCode.Code),
-		emailCode.Email,
-	)
+	msg.To = []string{emailCode.Email}
+	// The code is only usable if its email is going to be sent.
+	err = as.AuthStore.WithinTx(ctx, func(ctx context.Context) error {
+		err := as.AuthStore.InsertEmailCode(
+			ctx,
+			emailCode,
+		)
+		if err != nil {
+			return err
+		}
+		return as.outbox.Enqueue(ctx, msg, uuid.NullUUID{UUID: emailCode.ID, Valid: true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.EmailCodesSent.Inc()
+	as.outbox.Notify()
 	return emailCode, nil
 }
 
+// GetEmailCodeDelivery reports whether the email with the code has been sent.
+func (as *AuthService) GetEmailCodeDelivery(ctx context.Context, emailCodeID uuid.UUID) (*DeliveryStatus, error) {
+	return as.outbox.GetDeliveryStatus(ctx, emailCodeID)
+}
+
 func (as *AuthService) CheckEmailCode(
 	ctx context.Context,
 	emailCodeID uuid.UUID,
 	code uint16,
 ) (*models.User, bool, error) {
-	emailCode, err := as.AuthStore.GetEmailCodeByID(
+	// The attempt is counted before the comparison, so parallel guesses
+	// can't exceed the limit.
+	emailCode, err := as.AuthStore.IncrementEmailCodeAttempts(
 		ctx,
 		emailCodeID,
 	)
 	if err != nil {
 		return nil, false, err
 	}
-	if emailCode.ExpiresAt.Before(time.Now()) || emailCode.NumberOfAttempts > 2 {
-		as.AuthStore.DeleteEmailCode(ctx, emailCode.ID)
-		return nil, false, httperror.New(
-			nil,
-			"Code is gone",
-			http.StatusGone,
-		)
+	if emailCode.NumberOfAttempts > maxEmailCodeAttempts {
+		as.deleteEmailCode(ctx, emailCode.ID)
+		metrics.EmailCodesFailed.WithLabelValues(metrics.ReasonGone).Inc()
+		return nil, false, errCodeGone(httperror.CodeAttemptsExceeded)
+	}
+	if emailCode.ExpiresAt.Before(time.Now()) {
+		as.deleteEmailCode(ctx, emailCode.ID)
+		metrics.EmailCodesFailed.WithLabelValues(metrics.ReasonGone).Inc()
+		return nil, false, errCodeGone(httperror.CodeExpired)
 	}
 	if code != emailCode.Code {
-		emailCode.NumberOfAttempts += 1
-		err = as.AuthStore.UpdateEmailCode(ctx, emailCode)
-		if err != nil {
-			return nil, false, err
-		}
-		return nil, false, httperror.New(
+		metrics.EmailCodesFailed.WithLabelValues(metrics.ReasonIncorrect).Inc()
+		err := httperror.NewWithCode(
 			nil,
+			httperror.CodeIncorrect,
 			"Incorrect code",
 			http.StatusPreconditionFailed,
 		)
+		return nil, false, httperror.WithDetails(err, map[string]any{
+			"attempts_left": maxEmailCodeAttempts - int(emailCode.NumberOfAttempts),
+		})
 	}
-	var isNewUser bool
-	user, err := as.AuthStore.GetUserByEmail(ctx, emailCode.Email)
-	if err != nil {
-		_, statusCode := httperror.GetMessageAndStatusCode(err)
-		if statusCode != http.StatusNotFound {
-			return nil, false, err
-		}
-		user, err = as.CreateUser(
-			ctx,
-			emailCode.Email,
-		)
+	var (
+		user      *models.User
+		isNewUser bool
+	)
+	err = as.AuthStore.WithinTx(ctx, func(ctx context.Context) error {
+		err := as.AuthStore.ConsumeEmailCode(ctx, emailCode.ID)
 		if err != nil {
-			return nil, false, err
+			if httperror.IsNotFound(err) {
+				// The code has been used by a concurrent check.
+				metrics.EmailCodesFailed.WithLabelValues(metrics.ReasonGone).Inc()
+				return errCodeGone(httperror.CodeExpired)
+			}
+			return err
 		}
-		isNewUser = true
+		user, isNewUser, err = as.GetOrCreateUser(ctx, emailCode.Email)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
 	}
-	as.AuthStore.DeleteEmailCode(ctx, emailCode.ID)
 	return user, isNewUser, nil
 }
 
-func (as *AuthService) CreateUser(ctx context.Context, email string) (*models.User, error) {
-	user := &models.User{
-		ID:        uuid.New(),
-		Email:     email,
-		CreatedAt: time.Now(),
-		IsSuper:   false,
+func errCodeGone(code string) error {
+	return httperror.NewWithCode(
+		nil,
+		code,
+		"Code is gone",
+		http.StatusGone,
+	)
+}
+
+func (as *AuthService) deleteEmailCode(ctx context.Context, emailCodeID uuid.UUID) {
+	err := as.AuthStore.DeleteEmailCode(ctx, emailCodeID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete email code", "email_code_id", emailCodeID, "error", err)
 	}
-	err := as.AuthStore.InsertUser(
+}
+
+// GetOrCreateUser returns the user with the given email, creating it if needed.
+// The second result reports whether the user has been created.
+func (as *AuthService) GetOrCreateUser(ctx context.Context, email string) (*models.User, bool, error) {
+	user, created, err := as.AuthStore.UpsertUser(
 		ctx,
-		user,
+		&models.User{
+			ID:        uuid.New(),
+			Email:     email,
+			CreatedAt: time.Now(),
+			IsSuper:   false,
+		},
 	)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if created {
+		metrics.UsersCreated.Inc()
 	}
-	return user, nil
+	return user, created, nil
 }
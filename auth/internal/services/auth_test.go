@@ -0,0 +1,131 @@
+package services_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+const testCode = 1234
+
+func newAuthTest(t *testing.T) (*services.AuthService, *memory.MemoryStorage) {
+	t.Helper()
+	store := memory.New()
+	return services.NewAuthService(store, nil, nil), store
+}
+
+func insertEmailCode(t *testing.T, store *memory.MemoryStorage, email string) uuid.UUID {
+	t.Helper()
+	emailCode := &models.EmailCode{
+		ID:        uuid.New(),
+		Email:     email,
+		Code:      testCode,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	if err := store.InsertEmailCode(context.Background(), emailCode); err != nil {
+		t.Fatalf("InsertEmailCode: %v", err)
+	}
+	return emailCode.ID
+}
+
+// checkConcurrently checks the email codes in parallel, each the given number
+// of times, and counts the results by error code, "" counting the successful
+// checks. It returns the users created by the checks.
+func checkConcurrently(authService *services.AuthService, checks map[uuid.UUID]int, code uint16) (map[string]int, []*models.User) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = map[string]int{}
+		users   []*models.User
+	)
+	for emailCodeID, n := range checks {
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user, isNew, err := authService.CheckEmailCode(context.Background(), emailCodeID, code)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					results[httperror.GetCode(err)]++
+					return
+				}
+				results[""]++
+				if isNew {
+					users = append(users, user)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return results, users
+}
+
+func TestCheckEmailCodeConcurrentWrongGuesses(t *testing.T) {
+	authService, store := newAuthTest(t)
+	emailCodeID := insertEmailCode(t, store, "alice@example.com")
+
+	const n = 10
+	results, _ := checkConcurrently(authService, map[uuid.UUID]int{emailCodeID: n}, testCode+1)
+
+	// Only the allowed attempts are compared, the code is gone after them.
+	if results[httperror.CodeIncorrect] != 3 {
+		t.Errorf("%d concurrent wrong guesses got %d incorrect answers, want 3: %v", n, results[httperror.CodeIncorrect], results)
+	}
+	if gone := results[httperror.CodeAttemptsExceeded] + results[httperror.CodeEmailCodeNotFound]; gone != n-3 {
+		t.Errorf("%d guesses after the limit have been rejected, want %d: %v", gone, n-3, results)
+	}
+	if _, _, err := authService.CheckEmailCode(context.Background(), emailCodeID, testCode); !httperror.IsNotFound(err) {
+		t.Errorf("the right code after the limit returned %v, want not found", err)
+	}
+}
+
+func TestCheckEmailCodeConsumedOnce(t *testing.T) {
+	authService, store := newAuthTest(t)
+	emailCodeID := insertEmailCode(t, store, "alice@example.com")
+
+	// As many checks as attempts allowed, so that they all race to consume the code.
+	results, _ := checkConcurrently(authService, map[uuid.UUID]int{emailCodeID: 3}, testCode)
+	if results[""] != 1 {
+		t.Errorf("the code has been used %d times, want once: %v", results[""], results)
+	}
+	// The other checks find the code consumed, before or after counting their attempt.
+	if gone := results[httperror.CodeExpired] + results[httperror.CodeEmailCodeNotFound]; gone != 2 {
+		t.Errorf("%d concurrent checks found the code gone, want 2: %v", gone, results)
+	}
+	if _, _, err := authService.CheckEmailCode(context.Background(), emailCodeID, testCode); !httperror.IsNotFound(err) {
+		t.Errorf("checking a used code returned %v, want not found", err)
+	}
+}
+
+func TestCheckEmailCodeConcurrentFirstLogins(t *testing.T) {
+	authService, store := newAuthTest(t)
+	const email = "alice@example.com"
+	checks := map[uuid.UUID]int{}
+	for range 8 {
+		checks[insertEmailCode(t, store, email)] = 1
+	}
+
+	results, created := checkConcurrently(authService, checks, testCode)
+	if results[""] != len(checks) {
+		t.Fatalf("%d of %d concurrent first logins succeeded: %v", results[""], len(checks), results)
+	}
+	if len(created) != 1 {
+		t.Fatalf("concurrent first logins created %d users, want 1", len(created))
+	}
+	user, err := store.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.ID != created[0].ID {
+		t.Errorf("the stored user is %s, want the created user %s", user.ID, created[0].ID)
+	}
+}
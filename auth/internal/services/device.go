@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+// DeviceGrantType is the grant type used to exchange a device code for tokens.
+const DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet has no vowels, so user codes can't spell words, and
+// no characters that are easily confused with each other.
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// slowDownStep is added to the polling interval of a client polling too fast.
+const slowDownStep = 5 * time.Second
+
+// deviceCodePrefix tags device codes the same way refresh tokens are tagged.
+const deviceCodePrefix = "gkdc_"
+
+// expiredDeviceCodeRetention keeps expired codes for a while, so that clients
+// polling shortly after the expiry are told expired_token rather than that
+// the code is unknown.
+const expiredDeviceCodeRetention = time.Hour
+
+type IDeviceCodeStore interface {
+	Transactor
+
+	InsertDeviceCode(ctx context.Context, deviceCode *models.DeviceCode) error
+	GetDeviceCodeByHash(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error)
+	GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error)
+	UpdateDeviceCodePolling(ctx context.Context, deviceCodeID uuid.UUID, lastPolledAt time.Time, intervalSeconds int) error
+	DecideDeviceCode(ctx context.Context, deviceCodeID uuid.UUID, status string, userID uuid.UUID) error
+	ConsumeDeviceCode(ctx context.Context, deviceCodeID uuid.UUID) error
+	DeleteExpiredDeviceCodes(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DeviceSettings configures the device authorization grant.
+type DeviceSettings struct {
+	// ClientIDs are the public clients allowed to use the grant.
+	ClientIDs []string
+	// VerificationURI is the page users enter the user code on.
+	VerificationURI string
+	CodeExp         time.Duration
+	PollInterval    time.Duration
+}
+
+// DeviceService implements the OAuth 2.0 Device Authorization Grant (RFC 8628).
+type DeviceService struct {
+	DeviceSettings
+	store          IDeviceCodeStore
+	sessionService *SessionService
+}
+
+// DeviceAuthorization is the RFC 8628 device authorization response.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func NewDeviceService(
+	settings DeviceSettings,
+	store IDeviceCodeStore,
+	sessionService *SessionService,
+) *DeviceService {
+	return &DeviceService{
+		DeviceSettings: settings,
+		store:          store,
+		sessionService: sessionService,
+	}
+}
+
+// Authorize starts a device authorization for the client.
+func (ds *DeviceService) Authorize(ctx context.Context, clientID string, scope string) (*DeviceAuthorization, error) {
+	if !slices.Contains(ds.ClientIDs, clientID) {
+		return nil, NewOAuthError(OAuthErrorInvalidClient, "unknown client", http.StatusUnauthorized)
+	}
+	deviceCode, err := newOpaqueToken(deviceCodePrefix)
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = ds.store.InsertDeviceCode(ctx, &models.DeviceCode{
+		ID:              uuid.New(),
+		DeviceCodeHash:  hashToken(deviceCode),
+		UserCode:        userCode,
+		ClientID:        clientID,
+		Scope:           scope,
+		Status:          models.DeviceCodeStatusPending,
+		ExpiresAt:       now.Add(ds.CodeExp),
+		LastPolledAt:    now,
+		IntervalSeconds: int(ds.PollInterval.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	formattedUserCode := formatUserCode(userCode)
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formattedUserCode,
+		VerificationURI:         ds.VerificationURI,
+		VerificationURIComplete: ds.VerificationURI + "?user_code=" + url.QueryEscape(formattedUserCode),
+		ExpiresIn:               int(ds.CodeExp.Seconds()),
+		Interval:                int(ds.PollInterval.Seconds()),
+	}, nil
+}
+
+// GetPendingAuthorization returns the authorization the user code belongs to
+// so that the user can check which client asks for access before deciding.
+func (ds *DeviceService) GetPendingAuthorization(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	deviceCode, err := ds.store.GetDeviceCodeByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	if deviceCode.Status != models.DeviceCodeStatusPending || time.Now().After(deviceCode.ExpiresAt) {
+		return nil, httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	return deviceCode, nil
+}
+
+// Decide approves or denies the pending authorization on behalf of the user.
+func (ds *DeviceService) Decide(ctx context.Context, userID uuid.UUID, userCode string, approve bool) error {
+	deviceCode, err := ds.GetPendingAuthorization(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	status := models.DeviceCodeStatusDenied
+	if approve {
+		status = models.DeviceCodeStatusApproved
+	}
+	return ds.store.DecideDeviceCode(ctx, deviceCode.ID, status, userID)
+}
+
+// Poll exchanges an approved device code for the tokens of a new session.
+// Until the user decides it reports why no tokens are issued yet. The access
+// token is issued for the given audiences, see SessionService.CheckAudiences.
+func (ds *DeviceService) Poll(ctx context.Context, clientID string, code string, userAgent string, ip string, audiences ...string) (*Tokens, *models.DeviceCode, error) {
+	deviceCode, err := ds.store.GetDeviceCodeByHash(ctx, hashToken(code))
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, nil, NewOAuthError(OAuthErrorInvalidGrant, "unknown device code", http.StatusBadRequest)
+		}
+		return nil, nil, err
+	}
+	if deviceCode.ClientID != clientID {
+		return nil, nil, NewOAuthError(OAuthErrorInvalidGrant, "device code was issued to another client", http.StatusBadRequest)
+	}
+	now := time.Now()
+	if now.After(deviceCode.ExpiresAt) {
+		ds.consumeDeviceCode(ctx, deviceCode.ID)
+		return nil, nil, NewOAuthError(OAuthErrorExpiredToken, "", http.StatusBadRequest)
+	}
+	switch deviceCode.Status {
+	case models.DeviceCodeStatusDenied:
+		ds.consumeDeviceCode(ctx, deviceCode.ID)
+		return nil, nil, NewOAuthError(OAuthErrorAccessDenied, "", http.StatusBadRequest)
+	case models.DeviceCodeStatusPending:
+		interval := time.Duration(deviceCode.IntervalSeconds) * time.Second
+		oauthErr := NewOAuthError(OAuthErrorAuthorizationPending, "", http.StatusBadRequest)
+		if now.Sub(deviceCode.LastPolledAt) < interval {
+			interval += slowDownStep
+			oauthErr = NewOAuthError(OAuthErrorSlowDown, "", http.StatusBadRequest)
+		}
+		err = ds.store.UpdateDeviceCodePolling(ctx, deviceCode.ID, now, int(interval.Seconds()))
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, oauthErr
+	}
+
+	// The session is prepared first, so that the transaction is not kept
+	// open while its location is looked up.
+	session, tokens, err := ds.sessionService.newSession(ctx, deviceCode.UserID.UUID, userAgent, ip, audiences...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The code is only consumed together with the session, so that it can be
+	// polled again if the session can't be created.
+	err = ds.store.WithinTx(ctx, func(ctx context.Context) error {
+		// Consuming fails if a concurrent poll has already received the tokens.
+		err := ds.store.ConsumeDeviceCode(ctx, deviceCode.ID)
+		if err != nil {
+			if httperror.IsNotFound(err) {
+				return NewOAuthError(OAuthErrorInvalidGrant, "device code has already been used", http.StatusBadRequest)
+			}
+			return err
+		}
+		return ds.sessionService.insertSession(ctx, session)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, deviceCode, nil
+}
+
+// DeleteExpiredCodes deletes the device codes which have expired without
+// having been exchanged for tokens.
+func (ds *DeviceService) DeleteExpiredCodes(ctx context.Context) {
+	deleted, err := ds.store.DeleteExpiredDeviceCodes(ctx, time.Now().Add(-expiredDeviceCodeRetention))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete expired device codes", "error", err)
+		return
+	}
+	if deleted > 0 {
+		slog.InfoContext(ctx, "deleted expired device codes", "count", deleted)
+	}
+}
+
+func (ds *DeviceService) consumeDeviceCode(ctx context.Context, deviceCodeID uuid.UUID) {
+	err := ds.store.ConsumeDeviceCode(ctx, deviceCodeID)
+	if err != nil && !httperror.IsNotFound(err) {
+		slog.ErrorContext(ctx, "failed to delete device code", "device_code_id", deviceCodeID, "error", err)
+	}
+}
+
+func newUserCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for range userCodeLength {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate user code: %w", err)
+		}
+		sb.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// formatUserCode splits the code in halves to make it easier to read and type.
+func formatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode undoes formatting and the case changes users tend to make.
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
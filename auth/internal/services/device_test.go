@@ -0,0 +1,198 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testDeviceClientID = "cli"
+	testPollInterval   = 5 * time.Second
+)
+
+type deviceTest struct {
+	device *services.DeviceService
+	store  *memory.MemoryStorage
+	userID uuid.UUID
+}
+
+func newDeviceTest(t *testing.T, codeExp time.Duration) *deviceTest {
+	t.Helper()
+	sessionService, store := newSessionTest(t, testTokenSettings())
+	device := services.NewDeviceService(services.DeviceSettings{
+		ClientIDs:       []string{testDeviceClientID},
+		VerificationURI: "https://auth.example.com/device",
+		CodeExp:         codeExp,
+		PollInterval:    testPollInterval,
+	}, store, sessionService)
+	return &deviceTest{device: device, store: store, userID: insertUser(t, store)}
+}
+
+func (dt *deviceTest) authorize(t *testing.T) *services.DeviceAuthorization {
+	t.Helper()
+	authorization, err := dt.device.Authorize(context.Background(), testDeviceClientID, "vault")
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	return authorization
+}
+
+// stored returns the device code of the authorization as stored.
+func (dt *deviceTest) stored(t *testing.T, authorization *services.DeviceAuthorization) *models.DeviceCode {
+	t.Helper()
+	deviceCode, err := dt.store.GetDeviceCodeByUserCode(context.Background(), strings.ReplaceAll(authorization.UserCode, "-", ""))
+	if err != nil {
+		t.Fatalf("GetDeviceCodeByUserCode: %v", err)
+	}
+	return deviceCode
+}
+
+// pollLater makes the next poll come after the interval has elapsed.
+func (dt *deviceTest) pollLater(t *testing.T, authorization *services.DeviceAuthorization) {
+	t.Helper()
+	deviceCode := dt.stored(t, authorization)
+	lastPolledAt := time.Now().Add(-time.Duration(deviceCode.IntervalSeconds)*time.Second - time.Second)
+	if err := dt.store.UpdateDeviceCodePolling(context.Background(), deviceCode.ID, lastPolledAt, deviceCode.IntervalSeconds); err != nil {
+		t.Fatalf("UpdateDeviceCodePolling: %v", err)
+	}
+}
+
+func (dt *deviceTest) poll(authorization *services.DeviceAuthorization) (*services.Tokens, error) {
+	tokens, _, err := dt.device.Poll(context.Background(), testDeviceClientID, authorization.DeviceCode, "test", "127.0.0.1")
+	return tokens, err
+}
+
+func (dt *deviceTest) decide(t *testing.T, authorization *services.DeviceAuthorization, approve bool) {
+	t.Helper()
+	if err := dt.device.Decide(context.Background(), dt.userID, authorization.UserCode, approve); err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+}
+
+func oauthErrorCode(err error) string {
+	var oauthErr *services.OAuthError
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+func TestDevicePollPending(t *testing.T) {
+	dt := newDeviceTest(t, time.Minute)
+	authorization := dt.authorize(t)
+
+	dt.pollLater(t, authorization)
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorAuthorizationPending {
+		t.Fatalf("polling an undecided code returned %v, want authorization_pending", err)
+	}
+	if interval := dt.stored(t, authorization).IntervalSeconds; interval != 5 {
+		t.Errorf("the interval is %ds after a poll in time, want 5s", interval)
+	}
+
+	// Each poll before the interval has elapsed slows the client down further.
+	for _, wantInterval := range []int{10, 15} {
+		if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorSlowDown {
+			t.Fatalf("polling too fast returned %v, want slow_down", err)
+		}
+		if interval := dt.stored(t, authorization).IntervalSeconds; interval != wantInterval {
+			t.Errorf("the interval is %ds after polling too fast, want %ds", interval, wantInterval)
+		}
+	}
+	dt.pollLater(t, authorization)
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorAuthorizationPending {
+		t.Fatalf("polling at the slower interval returned %v, want authorization_pending", err)
+	}
+	if interval := dt.stored(t, authorization).IntervalSeconds; interval != 15 {
+		t.Errorf("the interval is %ds after a poll in time, want it kept at 15s", interval)
+	}
+}
+
+func TestDevicePollExpired(t *testing.T) {
+	dt := newDeviceTest(t, -time.Second)
+	authorization := dt.authorize(t)
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorExpiredToken {
+		t.Fatalf("polling an expired code returned %v, want expired_token", err)
+	}
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorInvalidGrant {
+		t.Errorf("polling an expired code again returned %v, want invalid_grant", err)
+	}
+	if err := dt.device.Decide(context.Background(), dt.userID, authorization.UserCode, true); !httperror.IsNotFound(err) {
+		t.Errorf("approving an expired code returned %v, want not found", err)
+	}
+}
+
+func TestDevicePollDenied(t *testing.T) {
+	dt := newDeviceTest(t, time.Minute)
+	authorization := dt.authorize(t)
+	dt.decide(t, authorization, false)
+	if err := dt.device.Decide(context.Background(), dt.userID, authorization.UserCode, true); !httperror.IsNotFound(err) {
+		t.Errorf("approving a denied code returned %v, want not found", err)
+	}
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorAccessDenied {
+		t.Fatalf("polling a denied code returned %v, want access_denied", err)
+	}
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorInvalidGrant {
+		t.Errorf("polling a denied code again returned %v, want invalid_grant", err)
+	}
+}
+
+func TestDevicePollApprovedOnce(t *testing.T) {
+	dt := newDeviceTest(t, time.Minute)
+	authorization := dt.authorize(t)
+	dt.decide(t, authorization, true)
+
+	if _, _, err := dt.device.Poll(context.Background(), "other-client", authorization.DeviceCode, "test", "127.0.0.1"); oauthErrorCode(err) != services.OAuthErrorInvalidGrant {
+		t.Errorf("polling the code of another client returned %v, want invalid_grant", err)
+	}
+
+	// Concurrent polls race to exchange the code, only one gets the tokens.
+	const n = 5
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		issued  []*services.Tokens
+		refused int
+	)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens, err := dt.poll(authorization)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				issued = append(issued, tokens)
+			case oauthErrorCode(err) == services.OAuthErrorInvalidGrant:
+				refused++
+			default:
+				t.Errorf("Poll: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(issued) != 1 || refused != n-1 {
+		t.Fatalf("%d concurrent polls issued %d tokens and refused %d, want 1 and %d", n, len(issued), refused, n-1)
+	}
+	if issued[0].AccessToken == "" || issued[0].RefreshToken == "" {
+		t.Errorf("the issued tokens are incomplete: %+v", issued[0])
+	}
+	sessions, err := dt.store.GetSessionsList(context.Background(), dt.userID)
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("the approval created %d sessions, want 1: %v", len(sessions), err)
+	}
+	if _, err := dt.poll(authorization); oauthErrorCode(err) != services.OAuthErrorInvalidGrant {
+		t.Errorf("polling a used code returned %v, want invalid_grant", err)
+	}
+}
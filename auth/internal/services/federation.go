@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+// UpstreamIdentity is the account a user has logged in with at an upstream provider.
+type UpstreamIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// UpstreamProvider is an external OpenID Connect or OAuth2 identity provider
+// users can log in with instead of an email code.
+type UpstreamProvider interface {
+	Name() string
+	DisplayName() string
+	// AuthCodeURL returns the URL the user is sent to for logging in. The
+	// verifier is the PKCE code verifier, only its S256 challenge is sent.
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string, redirectURI string) (string, error)
+	// Exchange redeems the authorization code and returns the logged in account.
+	Exchange(ctx context.Context, code string, verifier string, nonce string, redirectURI string) (*UpstreamIdentity, error)
+}
+
+type IIdentityStore interface {
+	Transactor
+
+	GetIdentity(ctx context.Context, provider string, subject string) (*models.Identity, error)
+	GetIdentitiesList(ctx context.Context, userID uuid.UUID) ([]*models.Identity, error)
+	UpsertIdentity(ctx context.Context, identity *models.Identity) (*models.Identity, bool, error)
+	UpdateIdentityEmail(ctx context.Context, identityID uuid.UUID, email string) error
+
+	GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+}
+
+// FederationSettings configures logging in with upstream providers.
+type FederationSettings struct {
+	// PublicURL is the URL the service is reachable at by browsers, the
+	// callback URLs registered at the providers are built from it.
+	PublicURL string
+	// AllowedOrigins are the origins users may be sent back to after logging
+	// in, besides paths of the service itself.
+	AllowedOrigins []string
+	// LoginExp limits the time a user has to log in at the provider.
+	LoginExp time.Duration
+}
+
+// FederationService logs users in with upstream identity providers. Accounts
+// are linked to users by their verified email.
+type FederationService struct {
+	FederationSettings
+	providers      []UpstreamProvider
+	store          IIdentityStore
+	authService    *AuthService
+	sessionService *SessionService
+}
+
+// FederatedLogin is the state of a login in progress. It is kept by the
+// browser in a signed cookie, so that no storage is needed until the user
+// comes back from the provider.
+type FederatedLogin struct {
+	Provider  string `json:"p"`
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ReturnTo  string `json:"r,omitempty"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func NewFederationService(
+	settings FederationSettings,
+	providers []UpstreamProvider,
+	store IIdentityStore,
+	authService *AuthService,
+	sessionService *SessionService,
+) *FederationService {
+	return &FederationService{
+		FederationSettings: settings,
+		providers:          providers,
+		store:              store,
+		authService:        authService,
+		sessionService:     sessionService,
+	}
+}
+
+func (fs *FederationService) Providers() []UpstreamProvider {
+	return fs.providers
+}
+
+func (fs *FederationService) Provider(name string) (UpstreamProvider, error) {
+	for _, provider := range fs.providers {
+		if provider.Name() == name {
+			return provider, nil
+		}
+	}
+	return nil, httperror.NewWithCode(nil, httperror.CodeProviderNotFound, "Identity provider not found", http.StatusNotFound)
+}
+
+// StartLogin returns the URL of the provider's login page and the login state
+// to keep until the user is redirected back to redirectURI.
+func (fs *FederationService) StartLogin(ctx context.Context, providerName string, redirectURI string, returnTo string) (string, string, error) {
+	provider, err := fs.Provider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+	if returnTo != "" && !fs.isAllowedReturnTo(returnTo) {
+		return "", "", httperror.NewWithCode(nil, httperror.CodeReturnToNotAllowed, "return_to is not allowed", http.StatusBadRequest)
+	}
+	login := &FederatedLogin{
+		Provider:  provider.Name(),
+		ReturnTo:  returnTo,
+		ExpiresAt: time.Now().Add(fs.LoginExp).Unix(),
+	}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		*value, err = newOpaqueToken("")
+		if err != nil {
+			return "", "", err
+		}
+	}
+	authURL, err := provider.AuthCodeURL(ctx, login.State, login.Nonce, login.Verifier, redirectURI)
+	if err != nil {
+		return "", "", err
+	}
+	loginState, err := fs.encodeLogin(login)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, loginState, nil
+}
+
+// FinishLogin handles the redirect back from the provider: it redeems the
+// code, links the account to a user and starts a session for the user.
+func (fs *FederationService) FinishLogin(
+	ctx context.Context,
+	providerName string,
+	loginState string,
+	state string,
+	code string,
+	redirectURI string,
+	userAgent string,
+	ip string,
+) (*Tokens, *FederatedLogin, error) {
+	login, err := fs.decodeLogin(loginState)
+	if err != nil {
+		return nil, nil, err
+	}
+	if login.Provider != providerName || !hmac.Equal([]byte(login.State), []byte(state)) {
+		return nil, nil, httperror.NewWithCode(nil, httperror.CodeLoginStateMismatch, "Login state mismatch, try again", http.StatusBadRequest)
+	}
+	provider, err := fs.Provider(providerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	upstream, err := provider.Exchange(ctx, code, login.Verifier, login.Nonce, redirectURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := fs.linkIdentity(ctx, provider.Name(), upstream)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := fs.sessionService.CreateSession(ctx, user.ID, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, login, nil
+}
+
+// linkIdentity returns the user the upstream account is linked to. Accounts
+// logging in for the first time are linked to the user with the same email,
+// which is created if needed, but only if the provider has verified the
+// email, otherwise anyone could take over an account by registering its
+// email at the provider.
+func (fs *FederationService) linkIdentity(ctx context.Context, providerName string, upstream *UpstreamIdentity) (*models.User, error) {
+	if upstream.Subject == "" {
+		return nil, fmt.Errorf("identity provider %s returned no subject", providerName)
+	}
+	email := strings.ToLower(strings.TrimSpace(upstream.Email))
+	identity, err := fs.store.GetIdentity(ctx, providerName, upstream.Subject)
+	if err == nil {
+		if email != "" && email != identity.Email {
+			// The email is informational only, the link is kept by the subject.
+			err = fs.store.UpdateIdentityEmail(ctx, identity.ID, email)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return fs.store.GetUserByID(ctx, identity.UserID)
+	}
+	if !httperror.IsNotFound(err) {
+		return nil, err
+	}
+	if !upstream.EmailVerified || !emailRegexp.MatchString(email) {
+		return nil, httperror.NewWithCode(nil, httperror.CodeEmailNotVerified, "The identity provider has not confirmed a valid email", http.StatusForbidden)
+	}
+	var user *models.User
+	err = fs.store.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		user, _, err = fs.authService.GetOrCreateUser(ctx, email)
+		if err != nil {
+			return err
+		}
+		identity, _, err = fs.store.UpsertIdentity(ctx, &models.Identity{
+			ID:        uuid.New(),
+			UserID:    user.ID,
+			Provider:  providerName,
+			Subject:   upstream.Subject,
+			Email:     email,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		// A parallel login may have linked the account first.
+		if identity.UserID != user.ID {
+			user, err = fs.store.GetUserByID(ctx, identity.UserID)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (fs *FederationService) GetIdentitiesList(ctx context.Context, userID uuid.UUID) ([]*models.Identity, error) {
+	return fs.store.GetIdentitiesList(ctx, userID)
+}
+
+// isAllowedReturnTo accepts paths of the service and URLs of the allowed
+// origins, so that the login can't be used as an open redirect.
+func (fs *FederationService) isAllowedReturnTo(returnTo string) bool {
+	target, err := url.Parse(returnTo)
+	if err != nil {
+		return false
+	}
+	if target.Scheme == "" && target.Host == "" {
+		// "//host" and "/\host" are treated by browsers as other hosts.
+		return strings.HasPrefix(target.Path, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\")
+	}
+	origin := target.Scheme + "://" + target.Host
+	return origin == strings.TrimSuffix(fs.PublicURL, "/") || slices.Contains(fs.AllowedOrigins, origin)
+}
+
+func (fs *FederationService) encodeLogin(login *FederatedLogin) (string, error) {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + fs.sessionService.MAC("federated-login:"+payload), nil
+}
+
+func (fs *FederationService) decodeLogin(loginState string) (*FederatedLogin, error) {
+	errExpired := httperror.NewWithCode(nil, httperror.CodeLoginExpired, "The login has expired, try again", http.StatusBadRequest)
+	payload, signature, ok := strings.Cut(loginState, ".")
+	if !ok || !fs.sessionService.VerifyMAC("federated-login:"+payload, signature) {
+		return nil, errExpired
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errExpired
+	}
+	login := &FederatedLogin{}
+	if err = json.Unmarshal(data, login); err != nil {
+		return nil, errExpired
+	}
+	if time.Now().Unix() > login.ExpiresAt {
+		return nil, errExpired
+	}
+	return login, nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+)
+
+// OAuth2 error codes defined by RFC 6749, RFC 6750, RFC 8628, RFC 8707 and
+// OpenID Connect Core.
+const (
+	OAuthErrorInvalidRequest       = "invalid_request"
+	OAuthErrorInvalidClient        = "invalid_client"
+	OAuthErrorUnauthorizedClient   = "unauthorized_client"
+	OAuthErrorInvalidGrant         = "invalid_grant"
+	OAuthErrorUnsupportedGrantType = "unsupported_grant_type"
+	OAuthErrorAuthorizationPending = "authorization_pending"
+	OAuthErrorSlowDown             = "slow_down"
+	OAuthErrorAccessDenied         = "access_denied"
+	OAuthErrorExpiredToken         = "expired_token"
+	OAuthErrorInvalidTarget        = "invalid_target"
+
+	OAuthErrorUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrorInvalidScope            = "invalid_scope"
+	OAuthErrorInvalidToken            = "invalid_token"
+	OAuthErrorInsufficientScope       = "insufficient_scope"
+	OAuthErrorLoginRequired           = "login_required"
+	OAuthErrorConsentRequired         = "consent_required"
+)
+
+// OAuthError is an error reported in the RFC 6749 error response format.
+type OAuthError struct {
+	Code        string
+	Description string
+	StatusCode  int
+}
+
+func NewOAuthError(code string, description string, statusCode int) error {
+	return &OAuthError{
+		Code:        code,
+		Description: description,
+		StatusCode:  statusCode,
+	}
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// IClientAuthenticator verifies the credentials of OAuth2 clients
+// calling the introspection and revocation endpoints.
+type IClientAuthenticator interface {
+	AuthenticateClient(ctx context.Context, clientID string, clientSecret string) error
+}
+
+// StaticClients authenticates clients against a fixed set of client ids and secrets.
+type StaticClients map[string]string
+
+// ParseStaticClients parses a list of "client_id:client_secret" pairs.
+func ParseStaticClients(entries []string) (StaticClients, error) {
+	clients := make(StaticClients, len(entries))
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		clientID, clientSecret, ok := strings.Cut(entry, ":")
+		if !ok || clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("invalid client %q, expected client_id:client_secret", entry)
+		}
+		clients[clientID] = clientSecret
+	}
+	return clients, nil
+}
+
+func (sc StaticClients) AuthenticateClient(_ context.Context, clientID string, clientSecret string) error {
+	expected, ok := sc[clientID]
+	// Compare fixed size digests so that neither the secret nor its length leaks through timing.
+	expectedSum := sha256.Sum256([]byte(expected))
+	actualSum := sha256.Sum256([]byte(clientSecret))
+	if subtle.ConstantTimeCompare(expectedSum[:], actualSum[:]) != 1 || !ok {
+		return httperror.NewWithCode(nil, httperror.CodeInvalidClient, "Invalid client credentials", http.StatusUnauthorized)
+	}
+	return nil
+}
+
+// TokenInfo is the RFC 7662 introspection response.
+type TokenInfo struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	JWTID     string   `json:"jti,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+}
+
+// IntrospectToken reports whether the access token is active and describes
+// it. Only the tokens issued to the client, which is one of their audiences,
+// are described to it, as RFC 7662 §4 requires. The tokens of other clients,
+// refresh tokens, which are never issued to the clients calling it, and
+// unknown, expired and malformed tokens are reported as inactive rather than
+// as errors. Access tokens are active only while their session exists.
+func (s *SessionService) IntrospectToken(ctx context.Context, clientID string, token string) (*TokenInfo, error) {
+	claims, session, err := s.getSessionByAccessToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || !claims.issuedTo(clientID) {
+		return &TokenInfo{Active: false}, nil
+	}
+	info := &TokenInfo{
+		Active:    true,
+		Scope:     claims.Scope,
+		TokenType: "Bearer",
+		Subject:   claims.UserID.String(),
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		JWTID:     claims.ID,
+		SessionID: claims.SessionID.String(),
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		info.NotBefore = claims.NotBefore.Unix()
+	}
+	return info, nil
+}
+
+// RevokeToken ends the session of an access token issued to the client, see
+// IntrospectToken. As required by RFC 7009 invalid and already revoked tokens
+// are not reported as errors, but the valid tokens which have not been issued
+// to the client, including every refresh token, are refused.
+func (s *SessionService) RevokeToken(ctx context.Context, clientID string, token string) error {
+	claims, session, err := s.getSessionByAccessToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		session, err = s.lookupRefreshToken(ctx, token)
+		if err != nil || session == nil {
+			return err
+		}
+		return errNotIssuedToClient
+	}
+	if !claims.issuedTo(clientID) {
+		return errNotIssuedToClient
+	}
+	err = s.DeleteSession(ctx, session.ID)
+	if err != nil && !httperror.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// errNotIssuedToClient refuses to revoke a token of another client, RFC 7009 §2.1.
+var errNotIssuedToClient = NewOAuthError(OAuthErrorUnauthorizedClient, "token was issued to another client", http.StatusBadRequest)
+
+// issuedTo reports whether the access token has been issued to the client:
+// the tokens of the OAuth2 clients have their client as audience, the
+// tokens of the service are issued to the downstream services they are for.
+func (claims *Claims) issuedTo(clientID string) bool {
+	return slices.Contains(claims.Audience, clientID)
+}
+
+// lookupRefreshToken is getSessionByRefreshToken that reports tokens
+// which are not refresh tokens of an existing session as a nil session.
+func (s *SessionService) lookupRefreshToken(ctx context.Context, token string) (*models.Session, error) {
+	session, err := s.getSessionByRefreshToken(ctx, token)
+	if err != nil {
+		if _, statusCode := httperror.GetMessageAndStatusCode(err); statusCode < http.StatusInternalServerError {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.LastLogin.Add(s.Settings().RefreshExp).Before(time.Now()) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// getSessionByAccessToken returns the claims and the session of a valid access
+// token, or a nil session when the token is invalid or its session is gone.
+func (s *SessionService) getSessionByAccessToken(ctx context.Context, token string) (*Claims, *models.Session, error) {
+	claims, err := s.parseToken(token)
+	if err != nil || claims.TokenType != TokenTypeAccess || claims.Issuer != s.Settings().Issuer {
+		return nil, nil, nil
+	}
+	session, err := s.sessionStore.GetSession(ctx, claims.SessionID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return claims, session, nil
+}
@@ -0,0 +1,521 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Scopes supported by the OpenID Connect provider.
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+)
+
+var SupportedScopes = []string{ScopeOpenID, ScopeEmail}
+
+// CodeChallengeMethodS256 is the only PKCE method accepted, "plain" offers
+// no protection against intercepted authorization requests.
+const CodeChallengeMethodS256 = "S256"
+
+const (
+	authorizationCodePrefix = "gkac_"
+	clientSecretPrefix      = "gkcs_"
+)
+
+type IOIDCStore interface {
+	GetOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	GetOAuthClients(ctx context.Context) ([]*models.OAuthClient, error)
+	InsertOAuthClient(ctx context.Context, client *models.OAuthClient) error
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+
+	InsertAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+
+	GetConsent(ctx context.Context, userID uuid.UUID, clientID string) (*models.Consent, error)
+	UpsertConsent(ctx context.Context, consent *models.Consent) error
+
+	GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error)
+}
+
+// OIDCSettings configures the OpenID Connect provider.
+type OIDCSettings struct {
+	// IssuerURL is the public URL of the service, it is the iss claim of ID tokens.
+	IssuerURL  string
+	CodeExp    time.Duration
+	IDTokenExp time.Duration
+}
+
+// OIDCService implements a minimal OpenID Connect provider: the authorization
+// code flow with PKCE for registered clients.
+type OIDCService struct {
+	OIDCSettings
+	store          IOIDCStore
+	sessionService *SessionService
+	// signingKeys holds the key ID tokens are signed with first, followed by
+	// the keys it replaced, which are still published for verification.
+	signingKeys atomic.Pointer[[]*SigningKey]
+}
+
+// AuthorizationRequest holds the parameters of an authorization request.
+type AuthorizationRequest struct {
+	ResponseType string
+	ClientID     string
+	// RedirectURI is the redirect URI as sent by the client, it may be omitted
+	// by clients with a single registered redirect URI.
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+
+	// redirectTo is the URI the response is sent to, set once the request
+	// has been validated.
+	redirectTo string
+}
+
+// OIDCTokens is the token response of the authorization code grant.
+type OIDCTokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// IDTokenClaims are the claims of an ID token.
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	Nonce         string           `json:"nonce,omitempty"`
+	SessionID     string           `json:"sid,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+}
+
+func NewOIDCService(
+	settings OIDCSettings,
+	store IOIDCStore,
+	sessionService *SessionService,
+	signingKey *SigningKey,
+) *OIDCService {
+	oidc := &OIDCService{
+		OIDCSettings:   settings,
+		store:          store,
+		sessionService: sessionService,
+	}
+	oidc.signingKeys.Store(&[]*SigningKey{signingKey})
+	return oidc
+}
+
+// SigningKey returns the key ID tokens are signed with.
+func (oidc *OIDCService) SigningKey() *SigningKey {
+	return (*oidc.signingKeys.Load())[0]
+}
+
+// SetSigningKey replaces the key ID tokens are signed with. The replaced key
+// is still published in the JWKS, so that the tokens it signed can be
+// verified until they expire, and is dropped at the next rotation.
+func (oidc *OIDCService) SetSigningKey(signingKey *SigningKey) {
+	current := oidc.SigningKey()
+	if current.ID == signingKey.ID {
+		return
+	}
+	oidc.signingKeys.Store(&[]*SigningKey{signingKey, current})
+}
+
+func ParseAuthorizationRequest(values url.Values) *AuthorizationRequest {
+	return &AuthorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Prompt:              values.Get("prompt"),
+	}
+}
+
+// Encode returns the request as a query string, so that it can be carried
+// through the login and consent forms.
+func (r *AuthorizationRequest) Encode() string {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         r.ResponseType,
+		"client_id":             r.ClientID,
+		"redirect_uri":          r.RedirectURI,
+		"scope":                 r.Scope,
+		"state":                 r.State,
+		"nonce":                 r.Nonce,
+		"code_challenge":        r.CodeChallenge,
+		"code_challenge_method": r.CodeChallengeMethod,
+		"prompt":                r.Prompt,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values.Encode()
+}
+
+// RedirectTo returns the URI the response is sent to: the redirect_uri or,
+// when it has been omitted, the only one registered for the client.
+func (r *AuthorizationRequest) RedirectTo() string {
+	return r.redirectTo
+}
+
+// Scopes returns the requested scopes.
+func (r *AuthorizationRequest) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// ValidateAuthorizationRequest checks the request and returns the client it
+// is made by. Errors returned without a client must be shown to the user,
+// because the redirect URI can't be trusted. Errors returned with the client
+// are sent back to the client's redirect URI.
+func (oidc *OIDCService) ValidateAuthorizationRequest(ctx context.Context, req *AuthorizationRequest) (*models.OAuthClient, error) {
+	client, err := oidc.store.GetOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, NewOAuthError(OAuthErrorInvalidClient, "unknown client", http.StatusBadRequest)
+		}
+		return nil, err
+	}
+	req.redirectTo = req.RedirectURI
+	if req.redirectTo == "" && len(client.RedirectURIs) == 1 {
+		req.redirectTo = client.RedirectURIs[0]
+	}
+	if !client.HasRedirectURI(req.redirectTo) {
+		return nil, NewOAuthError(OAuthErrorInvalidRequest, "redirect_uri is not registered for the client", http.StatusBadRequest)
+	}
+	if req.ResponseType != "code" {
+		return client, NewOAuthError(OAuthErrorUnsupportedResponseType, "only the code response type is supported", http.StatusBadRequest)
+	}
+	scopes := req.Scopes()
+	if !slices.Contains(scopes, ScopeOpenID) {
+		return client, NewOAuthError(OAuthErrorInvalidScope, "the openid scope is required", http.StatusBadRequest)
+	}
+	// Unknown scopes are ignored as allowed by RFC 6749, the token response
+	// tells the client which scopes have been granted.
+	scopes = slices.DeleteFunc(scopes, func(scope string) bool {
+		return !slices.Contains(SupportedScopes, scope)
+	})
+	slices.Sort(scopes)
+	req.Scope = strings.Join(slices.Compact(scopes), " ")
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return client, NewOAuthError(OAuthErrorInvalidRequest, "PKCE with the S256 method is required", http.StatusBadRequest)
+	}
+	return client, nil
+}
+
+// HasConsent reports whether the user has already allowed the client every requested scope.
+func (oidc *OIDCService) HasConsent(ctx context.Context, userID uuid.UUID, clientID string, scope string) (bool, error) {
+	consent, err := oidc.store.GetConsent(ctx, userID, clientID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, requested := range strings.Fields(scope) {
+		if !slices.Contains(granted, requested) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (oidc *OIDCService) GrantConsent(ctx context.Context, userID uuid.UUID, clientID string, scope string) error {
+	return oidc.store.UpsertConsent(ctx, &models.Consent{
+		UserID:    userID,
+		ClientID:  clientID,
+		Scope:     scope,
+		CreatedAt: time.Now(),
+	})
+}
+
+// ConsentToken protects the consent form against cross-site request forgery.
+// It binds the form to the provider session and the client it has been shown for.
+func (oidc *OIDCService) ConsentToken(sessionID uuid.UUID, clientID string) string {
+	return oidc.sessionService.MAC(consentTokenData(sessionID, clientID))
+}
+
+func (oidc *OIDCService) VerifyConsentToken(sessionID uuid.UUID, clientID string, token string) bool {
+	return oidc.sessionService.VerifyMAC(consentTokenData(sessionID, clientID), token)
+}
+
+func consentTokenData(sessionID uuid.UUID, clientID string) string {
+	return "consent\x00" + sessionID.String() + "\x00" + clientID
+}
+
+// LoginToken protects the login forms against cross-site request forgery,
+// which would log the user in to the attacker's account. The browser has no
+// provider session yet, so the forms are bound to a random id of the
+// browser instead, and to the authorization request they have been shown for.
+func (oidc *OIDCService) LoginToken(browserID string, request string) string {
+	return oidc.sessionService.MAC(loginTokenData(browserID, request))
+}
+
+func (oidc *OIDCService) VerifyLoginToken(browserID string, request string, token string) bool {
+	return browserID != "" && oidc.sessionService.VerifyMAC(loginTokenData(browserID, request), token)
+}
+
+func loginTokenData(browserID string, request string) string {
+	return "login\x00" + browserID + "\x00" + request
+}
+
+// IssueAuthorizationCode creates the code the client exchanges for tokens.
+func (oidc *OIDCService) IssueAuthorizationCode(ctx context.Context, req *AuthorizationRequest, session *models.Session) (string, error) {
+	code, err := newOpaqueToken(authorizationCodePrefix)
+	if err != nil {
+		return "", err
+	}
+	err = oidc.store.InsertAuthorizationCode(ctx, &models.AuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientID:      req.ClientID,
+		UserID:        session.UserID,
+		SessionID:     session.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oidc.CodeExp),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// AuthenticateClient checks the credentials of a registered client. Public
+// clients have no secret and must not send one.
+func (oidc *OIDCService) AuthenticateClient(ctx context.Context, clientID string, clientSecret string) error {
+	_, err := oidc.authenticateClient(ctx, clientID, clientSecret)
+	return err
+}
+
+func (oidc *OIDCService) authenticateClient(ctx context.Context, clientID string, clientSecret string) (*models.OAuthClient, error) {
+	invalidClient := NewOAuthError(OAuthErrorInvalidClient, "", http.StatusUnauthorized)
+	client, err := oidc.store.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, invalidClient
+		}
+		return nil, err
+	}
+	if client.IsPublic() {
+		if clientSecret != "" {
+			return nil, invalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(clientSecret))) != 1 {
+		return nil, invalidClient
+	}
+	return client, nil
+}
+
+// ExchangeAuthorizationCode implements the authorization_code grant. The
+// access token is bound to the provider session the user has logged in with,
+// so ending that session also revokes the tokens issued to clients.
+func (oidc *OIDCService) ExchangeAuthorizationCode(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	code string,
+	redirectURI string,
+	codeVerifier string,
+) (*OIDCTokens, error) {
+	client, err := oidc.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	invalidGrant := func(description string) error {
+		return NewOAuthError(OAuthErrorInvalidGrant, description, http.StatusBadRequest)
+	}
+	authorizationCode, err := oidc.store.ConsumeAuthorizationCode(ctx, hashToken(code))
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, invalidGrant("unknown authorization code")
+		}
+		return nil, err
+	}
+	if authorizationCode.ClientID != client.ID {
+		return nil, invalidGrant("authorization code was issued to another client")
+	}
+	// The redirect_uri is only required if it has been sent with the
+	// authorization request, see RFC 6749 section 4.1.3.
+	if authorizationCode.RedirectURI != "" && authorizationCode.RedirectURI != redirectURI {
+		return nil, invalidGrant("redirect_uri does not match the authorization request")
+	}
+	if authorizationCode.RedirectURI == "" && redirectURI != "" && !client.HasRedirectURI(redirectURI) {
+		return nil, invalidGrant("redirect_uri is not registered for the client")
+	}
+	if time.Now().After(authorizationCode.ExpiresAt) {
+		return nil, invalidGrant("authorization code has expired")
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(authorizationCode.CodeChallenge)) != 1 {
+		return nil, invalidGrant("code_verifier does not match the code challenge")
+	}
+
+	session, err := oidc.store.GetSession(ctx, authorizationCode.SessionID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, invalidGrant("session has ended")
+		}
+		return nil, err
+	}
+	user, err := oidc.store.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := oidc.sessionService.CreateClientAccessToken(user.ID, session.ID, client.ID, authorizationCode.Scope)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := oidc.createIDToken(user, session, client.ID, authorizationCode)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCTokens{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oidc.sessionService.Settings().AccessExp.Seconds()),
+		IDToken:     idToken,
+		Scope:       authorizationCode.Scope,
+	}, nil
+}
+
+func (oidc *OIDCService) createIDToken(user *models.User, session *models.Session, clientID string, code *models.AuthorizationCode) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    oidc.IssuerURL,
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidc.IDTokenExp)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		AuthTime:  jwt.NewNumericDate(session.LastLogin),
+		Nonce:     code.Nonce,
+		SessionID: session.ID.String(),
+	}
+	if slices.Contains(strings.Fields(code.Scope), ScopeEmail) {
+		emailVerified := true
+		claims.Email = user.Email
+		claims.EmailVerified = &emailVerified
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	signingKey := oidc.SigningKey()
+	token.Header["kid"] = signingKey.ID
+	idToken, err := token.SignedString(signingKey.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign ID token: %w", err)
+	}
+	return idToken, nil
+}
+
+// UserInfo returns the claims about the user the access token has been issued for.
+func (oidc *OIDCService) UserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	invalidToken := NewOAuthError(OAuthErrorInvalidToken, "", http.StatusUnauthorized)
+	claims, err := oidc.sessionService.ParseClientAccessToken(accessToken)
+	if err != nil {
+		return nil, invalidToken
+	}
+	scopes := strings.Fields(claims.Scope)
+	if !slices.Contains(scopes, ScopeOpenID) {
+		return nil, NewOAuthError(OAuthErrorInsufficientScope, "", http.StatusForbidden)
+	}
+	if _, err := oidc.store.GetSession(ctx, claims.SessionID); err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+	user, err := oidc.store.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+	info := map[string]any{"sub": user.ID.String()}
+	if slices.Contains(scopes, ScopeEmail) {
+		info["email"] = user.Email
+		info["email_verified"] = true
+	}
+	return info, nil
+}
+
+// JWKS returns the keys ID tokens can be verified with.
+func (oidc *OIDCService) JWKS() JSONWebKeySet {
+	signingKeys := *oidc.signingKeys.Load()
+	keys := make([]JSONWebKey, 0, len(signingKeys))
+	for _, signingKey := range signingKeys {
+		keys = append(keys, signingKey.JSONWebKey())
+	}
+	return JSONWebKeySet{Keys: keys}
+}
+
+// CreateClient registers a client and returns its secret, which is not stored
+// and can't be shown again. Public clients get no secret.
+func (oidc *OIDCService) CreateClient(ctx context.Context, name string, redirectURIs []string, public bool) (*models.OAuthClient, string, error) {
+	if len(redirectURIs) == 0 {
+		return nil, "", errors.New("at least one redirect URI is required")
+	}
+	for _, redirectURI := range redirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(redirectURI, " \t\n") {
+			return nil, "", fmt.Errorf("invalid redirect URI %q", redirectURI)
+		}
+	}
+	client := &models.OAuthClient{
+		ID:           uuid.NewString(),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now(),
+	}
+	var secret string
+	if !public {
+		var err error
+		secret, err = newOpaqueToken(clientSecretPrefix)
+		if err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+	if err := oidc.store.InsertOAuthClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func (oidc *OIDCService) GetClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	return oidc.store.GetOAuthClients(ctx)
+}
+
+func (oidc *OIDCService) DeleteClient(ctx context.Context, clientID string) error {
+	return oidc.store.DeleteOAuthClient(ctx, clientID)
+}
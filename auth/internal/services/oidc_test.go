@@ -0,0 +1,170 @@
+package services_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testRedirectURI  = "https://client.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUwDo5WfCbDFoBxDxGgOw"
+)
+
+type oidcTest struct {
+	oidc    *services.OIDCService
+	client  *models.OAuthClient
+	secret  string
+	session *models.Session
+}
+
+func newOIDCTest(t *testing.T, redirectURIs ...string) *oidcTest {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.New()
+	sessionService := services.NewSessionService(services.TokenSettings{
+		SecretKey:          "oidc-test-secret-key",
+		AccessExp:          time.Minute,
+		RefreshExp:         time.Hour,
+		RefreshTokenFormat: services.RefreshTokenFormatOpaque,
+		Issuer:             "auth",
+		Audience:           "auth",
+	}, store)
+	signingKey, err := services.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	oidc := services.NewOIDCService(
+		services.OIDCSettings{IssuerURL: "https://auth.example.com", CodeExp: time.Minute, IDTokenExp: time.Minute},
+		store,
+		sessionService,
+		signingKey,
+	)
+	client, secret, err := oidc.CreateClient(ctx, "client", redirectURIs, false)
+	if err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "alice@example.com", CreatedAt: time.Now()}
+	if err := store.InsertUser(ctx, user); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if _, err := sessionService.CreateSession(ctx, user.ID, "test", "127.0.0.1"); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	sessions, err := store.GetSessionsList(ctx, user.ID)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("GetSessionsList returned %d sessions: %v", len(sessions), err)
+	}
+	return &oidcTest{oidc: oidc, client: client, secret: secret, session: sessions[0]}
+}
+
+// authorize validates the authorization request and issues a code for it.
+func (ot *oidcTest) authorize(t *testing.T, redirectURI string) (*services.AuthorizationRequest, string) {
+	t.Helper()
+	challenge := sha256.Sum256([]byte(testCodeVerifier))
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {ot.client.ID},
+		"scope":                 {"openid"},
+		"state":                 {"state"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if redirectURI != "" {
+		values.Set("redirect_uri", redirectURI)
+	}
+	req := services.ParseAuthorizationRequest(values)
+	if _, err := ot.oidc.ValidateAuthorizationRequest(context.Background(), req); err != nil {
+		t.Fatalf("ValidateAuthorizationRequest: %v", err)
+	}
+	code, err := ot.oidc.IssueAuthorizationCode(context.Background(), req, ot.session)
+	if err != nil {
+		t.Fatalf("IssueAuthorizationCode: %v", err)
+	}
+	return req, code
+}
+
+func (ot *oidcTest) exchange(code string, redirectURI string) error {
+	_, err := ot.oidc.ExchangeAuthorizationCode(context.Background(), ot.client.ID, ot.secret, code, redirectURI, testCodeVerifier)
+	return err
+}
+
+func isInvalidGrant(err error) bool {
+	var oauthErr *services.OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == services.OAuthErrorInvalidGrant
+}
+
+func TestAuthorizationCodeRedirectURI(t *testing.T) {
+	const otherRedirectURI = "https://client.example.com/other"
+
+	t.Run("sent", func(t *testing.T) {
+		ot := newOIDCTest(t, testRedirectURI)
+		req, code := ot.authorize(t, testRedirectURI)
+		if req.RedirectTo() != testRedirectURI {
+			t.Errorf("the response is sent to %q, want %q", req.RedirectTo(), testRedirectURI)
+		}
+		if err := ot.exchange(code, ""); !isInvalidGrant(err) {
+			t.Errorf("the exchange without the redirect_uri returned %v, want invalid_grant", err)
+		}
+		_, code = ot.authorize(t, testRedirectURI)
+		if err := ot.exchange(code, testRedirectURI); err != nil {
+			t.Errorf("the exchange with the redirect_uri failed: %v", err)
+		}
+	})
+
+	t.Run("omitted", func(t *testing.T) {
+		ot := newOIDCTest(t, testRedirectURI)
+		req, code := ot.authorize(t, "")
+		if req.RedirectTo() != testRedirectURI {
+			t.Errorf("the response is sent to %q, want the registered %q", req.RedirectTo(), testRedirectURI)
+		}
+		if err := ot.exchange(code, ""); err != nil {
+			t.Errorf("the exchange without the omitted redirect_uri failed: %v", err)
+		}
+		_, code = ot.authorize(t, "")
+		if err := ot.exchange(code, testRedirectURI); err != nil {
+			t.Errorf("the exchange with the registered redirect_uri failed: %v", err)
+		}
+		_, code = ot.authorize(t, "")
+		if err := ot.exchange(code, otherRedirectURI); !isInvalidGrant(err) {
+			t.Errorf("the exchange with an unregistered redirect_uri returned %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("omitted with several registered", func(t *testing.T) {
+		ot := newOIDCTest(t, testRedirectURI, otherRedirectURI)
+		req := services.ParseAuthorizationRequest(url.Values{"response_type": {"code"}, "client_id": {ot.client.ID}})
+		if _, err := ot.oidc.ValidateAuthorizationRequest(context.Background(), req); err == nil {
+			t.Error("the authorization request without a redirect_uri is valid, want it ambiguous")
+		}
+	})
+}
+
+func TestLoginToken(t *testing.T) {
+	ot := newOIDCTest(t, testRedirectURI)
+	token := ot.oidc.LoginToken("browser", "request")
+	if !ot.oidc.VerifyLoginToken("browser", "request", token) {
+		t.Error("the login token is not valid")
+	}
+	for _, tt := range []struct{ name, browserID, request, token string }{
+		{"another browser", "other-browser", "request", token},
+		{"another request", "browser", "other-request", token},
+		{"no browser", "", "request", ot.oidc.LoginToken("", "request")},
+		{"no token", "browser", "request", ""},
+		{"consent token", "browser", "request", ot.oidc.ConsentToken(ot.session.ID, ot.client.ID)},
+	} {
+		if ot.oidc.VerifyLoginToken(tt.browserID, tt.request, tt.token) {
+			t.Errorf("the login token of %s is valid", tt.name)
+		}
+	}
+}
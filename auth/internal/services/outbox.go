@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"auth/internal/metrics"
+	"auth/internal/models"
+	"auth/pkg/emailsender"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxLastErrorLength bounds the error stored for a failed delivery attempt.
+const maxLastErrorLength = 1000
+
+// Delivery statuses reported to clients.
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+type IOutboxStore interface {
+	InsertOutboxEmail(ctx context.Context, email *models.OutboxEmail) error
+	ClaimOutboxEmails(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]*models.OutboxEmail, error)
+	UpdateOutboxEmail(ctx context.Context, email *models.OutboxEmail) error
+	GetOutboxEmailByReference(ctx context.Context, referenceID uuid.UUID) (*models.OutboxEmail, error)
+	DeleteOutboxEmails(ctx context.Context, before time.Time) (int64, error)
+}
+
+// OutboxSettings configures the delivery of queued emails.
+type OutboxSettings struct {
+	// Workers is the number of emails sent concurrently.
+	Workers int
+	// PollInterval is how often the outbox is checked for due emails besides
+	// the emails queued by this instance, e.g. retries and other instances.
+	PollInterval time.Duration
+	// MaxAttempts is the number of attempts after which an email is dead.
+	MaxAttempts int
+	// The delay before a retry doubles with every attempt, from BackoffBase
+	// up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Lease is the time an email being sent is hidden from other workers. An
+	// email is sent again once it has passed, if the worker has died.
+	Lease time.Duration
+	// Retention is the time sent and dead emails are kept for.
+	Retention time.Duration
+}
+
+// OutboxService sends the emails queued in the outbox. Emails are queued in
+// the transaction of the change they are about and sent by a pool of workers,
+// so that a slow or failing mail server does not fail or block requests.
+type OutboxService struct {
+	settings    atomic.Pointer[OutboxSettings]
+	store       IOutboxStore
+	emailSender atomic.Pointer[emailsender.IEmailSender]
+	wake        chan struct{}
+	// reload wakes Run up when the settings have been updated.
+	reload chan struct{}
+	// cleanups are run along with the cleanup of the outbox.
+	cleanups []func(ctx context.Context)
+}
+
+// DeliveryStatus tells clients whether an email has been sent, so that they
+// can offer to send it again.
+type DeliveryStatus struct {
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func NewOutboxService(
+	settings OutboxSettings,
+	store IOutboxStore,
+	emailSender emailsender.IEmailSender,
+) *OutboxService {
+	ob := &OutboxService{
+		store:  store,
+		wake:   make(chan struct{}, 1),
+		reload: make(chan struct{}, 1),
+	}
+	ob.settings.Store(&settings)
+	ob.emailSender.Store(&emailSender)
+	return ob
+}
+
+// OnCleanup adds fn to the hourly cleanup of Run, for the services which have
+// expired rows to delete as well. It must be called before Run.
+func (ob *OutboxService) OnCleanup(fn func(ctx context.Context)) {
+	ob.cleanups = append(ob.cleanups, fn)
+}
+
+// Settings returns the current settings, which must not be modified.
+func (ob *OutboxService) Settings() *OutboxSettings {
+	return ob.settings.Load()
+}
+
+// UpdateSettings replaces the settings, e.g. when the configuration is
+// reloaded. The number of workers is only changed by a restart.
+func (ob *OutboxService) UpdateSettings(settings OutboxSettings) {
+	settings.Workers = ob.Settings().Workers
+	ob.settings.Store(&settings)
+	select {
+	case ob.reload <- struct{}{}:
+	default:
+	}
+}
+
+// EmailSender returns the sender emails are delivered with.
+func (ob *OutboxService) EmailSender() emailsender.IEmailSender {
+	return *ob.emailSender.Load()
+}
+
+// UpdateEmailSender replaces the sender, e.g. when the credentials of the
+// mail server have been rotated. Emails being sent are finished with the
+// previous sender.
+func (ob *OutboxService) UpdateEmailSender(emailSender emailsender.IEmailSender) {
+	ob.emailSender.Store(&emailSender)
+}
+
+// Enqueue queues the message, one email per recipient. The email is sent once
+// the transaction in ctx, if any, is committed, call Notify then to send it
+// without waiting for the next poll.
+func (ob *OutboxService) Enqueue(ctx context.Context, msg *emailsender.Message, referenceID uuid.NullUUID) error {
+	now := time.Now()
+	for _, recipient := range msg.To {
+		err := ob.store.InsertOutboxEmail(ctx, &models.OutboxEmail{
+			ID:            uuid.New(),
+			ReferenceID:   referenceID,
+			Recipient:     recipient,
+			Subject:       msg.Subject,
+			TextBody:      msg.Text,
+			HTMLBody:      msg.HTML,
+			Status:        models.OutboxEmailStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Notify wakes the workers up to send newly queued emails.
+func (ob *OutboxService) Notify() {
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// GetDeliveryStatus returns the delivery status of the latest email about the
+// referenced object.
+func (ob *OutboxService) GetDeliveryStatus(ctx context.Context, referenceID uuid.UUID) (*DeliveryStatus, error) {
+	email, err := ob.store.GetOutboxEmailByReference(ctx, referenceID)
+	if err != nil {
+		if httperror.IsNotFound(err) {
+			return nil, httperror.NewWithCode(err, httperror.CodeDeliveryNotFound, "Delivery not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	status := &DeliveryStatus{
+		Attempts:  email.Attempts,
+		UpdatedAt: email.UpdatedAt,
+	}
+	switch email.Status {
+	case models.OutboxEmailStatusSent:
+		status.Status = DeliveryStatusSent
+	case models.OutboxEmailStatusDead:
+		status.Status = DeliveryStatusFailed
+	default:
+		status.Status = DeliveryStatusPending
+		status.NextAttemptAt = &email.NextAttemptAt
+	}
+	return status, nil
+}
+
+// Run sends due emails until ctx is done. Emails being sent then are
+// finished before it returns.
+func (ob *OutboxService) Run(ctx context.Context) {
+	jobs := make(chan *models.OutboxEmail)
+	var wg sync.WaitGroup
+	for range ob.Settings().Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for email := range jobs {
+				ob.deliver(context.WithoutCancel(ctx), email)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	pollInterval := ob.Settings().PollInterval
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		ob.dispatch(ctx, jobs)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-ob.wake:
+		case <-ob.reload:
+			if interval := ob.Settings().PollInterval; interval != pollInterval {
+				pollInterval = interval
+				poll.Reset(pollInterval)
+			}
+		case <-cleanup.C:
+			ob.cleanup(ctx)
+		}
+	}
+}
+
+// dispatch hands the due emails to the workers.
+func (ob *OutboxService) dispatch(ctx context.Context, jobs chan<- *models.OutboxEmail) {
+	settings := ob.Settings()
+	for ctx.Err() == nil {
+		now := time.Now()
+		emails, err := ob.store.ClaimOutboxEmails(ctx, now, now.Add(settings.Lease), settings.Workers)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to claim outbox emails", "error", err)
+			return
+		}
+		for _, email := range emails {
+			select {
+			case jobs <- email:
+			case <-ctx.Done():
+				// The email is sent again once the lease has passed.
+				return
+			}
+		}
+		if len(emails) < settings.Workers {
+			return
+		}
+	}
+}
+
+func (ob *OutboxService) deliver(ctx context.Context, email *models.OutboxEmail) {
+	ctx, span := tracer.Start(
+		ctx,
+		"email.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("outbox_email.id", email.ID.String()),
+			attribute.Int("outbox_email.attempt", email.Attempts),
+		),
+	)
+	defer span.End()
+
+	err := ob.EmailSender().Send(ctx, &emailsender.Message{
+		To:      []string{email.Recipient},
+		Subject: email.Subject,
+		Text:    email.TextBody,
+		HTML:    email.HTMLBody,
+	})
+	now := time.Now()
+	email.UpdatedAt = now
+	if err == nil {
+		email.Status = models.OutboxEmailStatusSent
+		email.LastError = ""
+		metrics.EmailsDelivered.Inc()
+	} else {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metrics.EmailSendFailures.Inc()
+		email.LastError = err.Error()
+		if len(email.LastError) > maxLastErrorLength {
+			email.LastError = email.LastError[:maxLastErrorLength]
+		}
+		if email.Attempts >= ob.Settings().MaxAttempts || emailsender.IsPermanent(err) {
+			email.Status = models.OutboxEmailStatusDead
+			metrics.EmailsDeadLettered.Inc()
+			slog.ErrorContext(ctx, "failed to send email, giving up", "outbox_email_id", email.ID, "attempts", email.Attempts, "error", err)
+		} else {
+			email.NextAttemptAt = now.Add(ob.backoff(email.Attempts))
+			slog.WarnContext(ctx, "failed to send email, will retry", "outbox_email_id", email.ID, "attempts", email.Attempts, "retry_at", email.NextAttemptAt, "error", err)
+		}
+	}
+	if email.Status != models.OutboxEmailStatusPending {
+		// The body holds the login code, there is no need to keep it once
+		// the email won't be sent again.
+		email.Subject, email.TextBody, email.HTMLBody = "", "", ""
+	}
+	if err := ob.store.UpdateOutboxEmail(ctx, email); err != nil {
+		slog.ErrorContext(ctx, "failed to update outbox email", "outbox_email_id", email.ID, "error", err)
+	}
+}
+
+// backoff returns the delay before the attempt following the given one. Up to
+// a fifth of it is random, so that emails failed together are not retried
+// together.
+func (ob *OutboxService) backoff(attempts int) time.Duration {
+	settings := ob.Settings()
+	delay := settings.BackoffBase
+	for i := 1; i < attempts && delay < settings.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, settings.BackoffMax)
+	if jitter := int64(delay / 5); jitter > 0 {
+		delay -= time.Duration(rand.Int63n(jitter))
+	}
+	return delay
+}
+
+// cleanup deletes the emails handled longer than the retention ago and runs
+// the cleanups added by OnCleanup.
+func (ob *OutboxService) cleanup(ctx context.Context) {
+	deleted, err := ob.store.DeleteOutboxEmails(ctx, time.Now().Add(-ob.Settings().Retention))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete old outbox emails", "error", err)
+	} else if deleted > 0 {
+		slog.InfoContext(ctx, "deleted old outbox emails", "count", deleted)
+	}
+	for _, fn := range ob.cleanups {
+		fn(ctx)
+	}
+}
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/emailsender"
+
+	"github.com/google/uuid"
+)
+
+// senderFunc is an email sender which returns the result of the function.
+type senderFunc func(ctx context.Context, msg *emailsender.Message) error
+
+func (f senderFunc) Send(ctx context.Context, msg *emailsender.Message) error {
+	return f(ctx, msg)
+}
+
+// TestOutboxClearsHandledEmails checks that the content of an email, which
+// holds the login code, is not kept once the email won't be sent again.
+func TestOutboxClearsHandledEmails(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus string
+	}{
+		{name: "sent", wantStatus: models.OutboxEmailStatusSent},
+		{name: "permanent failure", err: &emailsender.APIError{StatusCode: http.StatusBadRequest}, wantStatus: models.OutboxEmailStatusDead},
+		{name: "last attempt", err: errors.New("connection refused"), wantStatus: models.OutboxEmailStatusDead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.New()
+			outbox := services.NewOutboxService(services.OutboxSettings{
+				Workers:      1,
+				PollInterval: 10 * time.Millisecond,
+				MaxAttempts:  1,
+				BackoffBase:  time.Millisecond,
+				BackoffMax:   time.Millisecond,
+				Lease:        time.Minute,
+				Retention:    time.Hour,
+			}, store, senderFunc(func(context.Context, *emailsender.Message) error {
+				return tt.err
+			}))
+			referenceID := uuid.New()
+			err := outbox.Enqueue(context.Background(), &emailsender.Message{
+				To:      []string{"alice@example.com"},
+				Subject: "Login code",
+				Text:    "Your code is 123456",
+				HTML:    "<p>Your code is <b>123456</b></p>",
+			}, uuid.NullUUID{UUID: referenceID, Valid: true})
+			if err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				outbox.Run(ctx)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			var email *models.OutboxEmail
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				email, err = store.GetOutboxEmailByReference(context.Background(), referenceID)
+				if err != nil {
+					t.Fatalf("GetOutboxEmailByReference: %v", err)
+				}
+				if email.Status != models.OutboxEmailStatusPending {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("the email has not been handled")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if email.Status != tt.wantStatus {
+				t.Errorf("the email is %s, want %s", email.Status, tt.wantStatus)
+			}
+			if email.Subject != "" || email.TextBody != "" || email.HTMLBody != "" {
+				t.Errorf("the content of the %s email is kept: %q, %q, %q", email.Status, email.Subject, email.TextBody, email.HTMLBody)
+			}
+			if email.Recipient != "alice@example.com" {
+				t.Errorf("the recipient is %q, want it kept for inspection", email.Recipient)
+			}
+			if tt.err != nil && email.LastError == "" {
+				t.Error("the error of the dead email is not kept")
+			}
+		})
+	}
+}
@@ -2,37 +2,86 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"auth/internal/metrics"
 	"auth/internal/models"
 	"auth/pkg/httperror"
 
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/mssola/user_agent"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ISessionStore interface {
 	GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error)
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error)
 	InsertSession(ctx context.Context, session models.Session) error
 	UpdateSession(ctx context.Context, session models.Session) error
 	DeleteSession(ctx context.Context, sessionID uuid.UUID) error
 	GetSessionsList(ctx context.Context, userID uuid.UUID) ([]*models.Session, error)
 }
 
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// Refresh token formats.
+const (
+	RefreshTokenFormatJWT    = "jwt"
+	RefreshTokenFormatOpaque = "opaque"
+)
+
+// opaqueRefreshTokenPrefix tags opaque refresh tokens so they are easy to
+// recognize, e.g. by secret scanners.
+const opaqueRefreshTokenPrefix = "gkrt_"
+
+// TokenSettings configures how SessionService issues and validates tokens.
+type TokenSettings struct {
+	// SecretKey signs the tokens.
+	SecretKey string
+	// PreviousSecretKeys only verify tokens, so that the tokens signed before
+	// the secret key has been rotated remain valid.
+	PreviousSecretKeys []string
+	AccessExp          time.Duration
+	RefreshExp         time.Duration
+	RefreshTokenFormat string
+	Issuer             string
+	// Audience identifies the auth service itself.
+	Audience string
+	// Audiences are the downstream services access tokens are issued for in
+	// addition to the auth service itself.
+	Audiences []string
+	// Leeway is the clock skew tolerated when validating exp, nbf and iat.
+	Leeway time.Duration
+}
+
 type SessionService struct {
-	SecretKey    string
-	AccessExp    time.Duration
-	RefreshExp   time.Duration
+	settings     atomic.Pointer[TokenSettings]
 	sessionStore ISessionStore
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
+	TokenType string `json:"typ"`
+	// Scope is the space separated list of scopes granted to the token.
+	Scope     string `json:"scope,omitempty"`
 	UserID    uuid.UUID
 	SessionID uuid.UUID
 }
@@ -43,69 +92,101 @@ type Tokens struct {
 }
 
 func NewSessionService(
-	secretKey string,
-	accessExp time.Duration,
-	refreshExp time.Duration,
+	settings TokenSettings,
 	sessionStore ISessionStore,
 ) *SessionService {
-	return &SessionService{
-		SecretKey:    secretKey,
-		AccessExp:    accessExp,
-		RefreshExp:   refreshExp,
+	s := &SessionService{
 		sessionStore: sessionStore,
 	}
+	s.settings.Store(&settings)
+	return s
 }
 
-func (s *SessionService) CreateSession(ctx context.Context, userID uuid.UUID, userAgent string, ip string) (*Tokens, error) {
-	sessionID := uuid.New()
-	accessToken, err := s.createToken(userID, sessionID, s.AccessExp)
+// Settings returns the current settings, which must not be modified.
+func (s *SessionService) Settings() *TokenSettings {
+	return s.settings.Load()
+}
+
+// UpdateSettings replaces the settings, e.g. when the configuration is
+// reloaded. A replaced secret key stops verifying tokens at once unless it
+// is listed in the previous keys, so that a leaked key can be revoked.
+func (s *SessionService) UpdateSettings(settings TokenSettings) {
+	s.settings.Store(&settings)
+}
+
+// CreateSession starts a session of the user. Its access token is issued for
+// the given audiences, see CheckAudiences.
+func (s *SessionService) CreateSession(ctx context.Context, userID uuid.UUID, userAgent string, ip string, audiences ...string) (*Tokens, error) {
+	session, tokens, err := s.newSession(ctx, userID, userAgent, ip, audiences...)
 	if err != nil {
 		return nil, err
 	}
-	refreshToken, err := s.createToken(userID, sessionID, s.RefreshExp)
+	err = s.insertSession(ctx, session)
 	if err != nil {
 		return nil, err
 	}
-	session := models.Session{
+	return tokens, nil
+}
+
+// newSession returns a session of the user and its tokens, which are only
+// valid once the session has been inserted.
+func (s *SessionService) newSession(ctx context.Context, userID uuid.UUID, userAgent string, ip string, audiences ...string) (*models.Session, *Tokens, error) {
+	sessionID := uuid.New()
+	accessToken, err := s.createAccessToken(userID, sessionID, audiences)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, err := s.createRefreshToken(userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	session := &models.Session{
 		ID:         sessionID,
-		Token:      refreshToken,
+		TokenHash:  hashToken(refreshToken),
 		IP:         net.ParseIP(ip),
-		Location:   s.getLocation(ip),
+		Location:   s.getLocation(ctx, ip),
 		ClientInfo: s.getClientInfo(userAgent),
 		LastLogin:  time.Now(),
 		UserID:     userID,
 	}
-	err = s.sessionStore.InsertSession(ctx, session)
-	if err != nil {
-		return nil, err
-	}
-	return &Tokens{
+	return session, &Tokens{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}, nil
 }
 
-func (s *SessionService) UpdateSession(ctx context.Context, token string, userAgent string, ip string) (*Tokens, error) {
-	claims, err := s.ParseToken(token)
+func (s *SessionService) insertSession(ctx context.Context, session *models.Session) error {
+	err := s.sessionStore.InsertSession(ctx, *session)
 	if err != nil {
-		return nil, httperror.New(err, "Invalid token", http.StatusBadRequest)
+		return err
+	}
+	metrics.SessionsCreated.Inc()
+	return nil
+}
+
+// UpdateSession rotates the refresh token of the session and issues an access
+// token for the given audiences, see CheckAudiences.
+func (s *SessionService) UpdateSession(ctx context.Context, token string, userAgent string, ip string, audiences ...string) (*Tokens, error) {
+	// Checked first, so that the refresh token is not rotated for nothing.
+	if err := s.CheckAudiences(audiences); err != nil {
+		return nil, err
 	}
-	session, err := s.sessionStore.GetSession(ctx, claims.SessionID)
+	session, err := s.getSessionByRefreshToken(ctx, token)
 	if err != nil {
 		return nil, err
 	}
-	accessToken, err := s.createToken(session.UserID, session.ID, s.AccessExp)
+	accessToken, err := s.createAccessToken(session.UserID, session.ID, audiences)
 	if err != nil {
 		return nil, err
 	}
-	refreshToken, err := s.createToken(session.UserID, session.ID, s.RefreshExp)
+	refreshToken, err := s.createRefreshToken(session.UserID, session.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	session.Token = refreshToken
+	session.TokenHash = hashToken(refreshToken)
 	session.IP = net.ParseIP(ip)
-	session.Location = s.getLocation(ip)
+	session.Location = s.getLocation(ctx, ip)
 	session.ClientInfo = s.getClientInfo(userAgent)
 	session.LastLogin = time.Now()
 
@@ -113,6 +194,7 @@ func (s *SessionService) UpdateSession(ctx context.Context, token string, userAg
 	if err != nil {
 		return nil, err
 	}
+	metrics.SessionsRefreshed.Inc()
 	return &Tokens{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -124,45 +206,268 @@ func (s *SessionService) GetSessionsList(ctx context.Context, userID uuid.UUID)
 }
 
 func (s *SessionService) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
-	return s.sessionStore.DeleteSession(ctx, sessionID)
+	err := s.sessionStore.DeleteSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	metrics.SessionsRevoked.Inc()
+	return nil
 }
 
 func (s *SessionService) DeleteSessionByToken(ctx context.Context, token string) error {
-	claims, err := s.ParseToken(token)
+	session, err := s.getSessionByRefreshToken(ctx, token)
 	if err != nil {
 		return err
 	}
-	return s.sessionStore.DeleteSession(ctx, claims.SessionID)
+	return s.DeleteSession(ctx, session.ID)
 }
 
-func (s *SessionService) createToken(userID uuid.UUID, sessionID uuid.UUID, exp time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// AuthenticateRefreshToken returns the session of the refresh token without
+// rotating the token, or nil if the token is not the current token of a session.
+func (s *SessionService) AuthenticateRefreshToken(ctx context.Context, token string) (*models.Session, error) {
+	return s.lookupRefreshToken(ctx, token)
+}
+
+// getSessionByRefreshToken returns the session the refresh token has been issued for.
+// Only the current refresh token of the session is accepted. Both refresh token
+// formats are accepted so that changing the format does not end existing sessions.
+func (s *SessionService) getSessionByRefreshToken(ctx context.Context, token string) (*models.Session, error) {
+	isOpaque := strings.HasPrefix(token, opaqueRefreshTokenPrefix)
+	var claims *Claims
+	if !isOpaque {
+		var err error
+		claims, err = s.parseToken(token)
+		if err != nil {
+			return nil, httperror.NewWithCode(err, httperror.CodeInvalidToken, "Invalid token", http.StatusBadRequest)
+		}
+		// Tokens issued before token types were introduced have no type.
+		// They are still checked against the stored hash below.
+		if claims.TokenType != TokenTypeRefresh && claims.TokenType != "" {
+			return nil, httperror.NewWithCode(nil, httperror.CodeInvalidToken, "Invalid token", http.StatusBadRequest)
+		}
+	}
+	tokenHash := hashToken(token)
+	session, err := s.sessionStore.GetSessionByTokenHash(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	// The store is not trusted to match the hash exactly, e.g. a collation
+	// ignoring the case or trailing spaces would. The comparison takes
+	// constant time, so that it tells nothing about the stored hash.
+	if subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(tokenHash)) != 1 {
+		return nil, httperror.NewWithCode(nil, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+	}
+	if isOpaque {
+		// Every refresh rotates the token and updates LastLogin, so it is
+		// the moment the current opaque token has been issued at.
+		if session.LastLogin.Add(s.Settings().RefreshExp).Before(time.Now()) {
+			return nil, httperror.NewWithCode(nil, httperror.CodeInvalidToken, "Invalid token", http.StatusBadRequest)
+		}
+	} else if session.ID != claims.SessionID {
+		return nil, httperror.NewWithCode(nil, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+	}
+	return session, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of the token. Only hashes of
+// refresh tokens are persisted, so a database leak does not expose credentials.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SessionService) createRefreshToken(userID uuid.UUID, sessionID uuid.UUID) (string, error) {
+	if s.Settings().RefreshTokenFormat == RefreshTokenFormatOpaque {
+		return newOpaqueToken(opaqueRefreshTokenPrefix)
+	}
+	return s.signClaims(s.newClaims(userID, sessionID, TokenTypeRefresh, s.Settings().RefreshExp))
+}
+
+// newOpaqueToken returns a random token carrying 256 bits of entropy.
+func newOpaqueToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createAccessToken issues an access token of the session for the audiences,
+// or for the auth service itself if none is given.
+func (s *SessionService) createAccessToken(userID uuid.UUID, sessionID uuid.UUID, audiences []string) (string, error) {
+	if err := s.CheckAudiences(audiences); err != nil {
+		return "", err
+	}
+	if len(audiences) == 0 {
+		audiences = []string{s.Settings().Audience}
+	}
+	claims := s.newClaims(userID, sessionID, TokenTypeAccess, s.Settings().AccessExp)
+	claims.Audience = slices.Compact(slices.Sorted(slices.Values(audiences)))
+	return s.signClaims(claims)
+}
+
+// CreateClientAccessToken issues an access token of the session for an OAuth2 client.
+// The token is only issued for the client: it is accepted by the userinfo
+// endpoint, see ParseClientAccessToken, but neither by the endpoints of the
+// auth service nor by the downstream services.
+func (s *SessionService) CreateClientAccessToken(userID uuid.UUID, sessionID uuid.UUID, clientID string, scope string) (string, error) {
+	claims := s.newClaims(userID, sessionID, TokenTypeAccess, s.Settings().AccessExp)
+	claims.Audience = jwt.ClaimStrings{clientID}
+	claims.Scope = scope
+	return s.signClaims(claims)
+}
+
+func (s *SessionService) newClaims(userID uuid.UUID, sessionID uuid.UUID, tokenType string, exp time.Duration) Claims {
+	now := time.Now()
+	return Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+			Issuer:    s.Settings().Issuer,
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(exp)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
 		},
+		TokenType: tokenType,
 		UserID:    userID,
 		SessionID: sessionID,
-	})
-	tokenString, err := token.SignedString([]byte(s.SecretKey))
+	}
+}
+
+func (s *SessionService) signClaims(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(s.Settings().SecretKey))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
 	return tokenString, nil
 }
 
-func (s *SessionService) ParseToken(token string) (*Claims, error) {
-	t, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.SecretKey), nil
-	})
+// MAC authenticates the data with the secret key, e.g. state kept by the
+// browser.
+func (s *SessionService) MAC(data string) string {
+	return mac([]byte(s.Settings().SecretKey), data)
+}
+
+// VerifyMAC checks a MAC made with the current or a previous secret key.
+func (s *SessionService) VerifyMAC(data string, sum string) bool {
+	for _, key := range s.Settings().verificationKeys() {
+		if hmac.Equal([]byte(mac(key, data)), []byte(sum)) {
+			return true
+		}
+	}
+	return false
+}
+
+func mac(key []byte, data string) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// verificationKeys returns the current secret key followed by the previous ones.
+func (settings *TokenSettings) verificationKeys() [][]byte {
+	keys := [][]byte{[]byte(settings.SecretKey)}
+	for _, key := range settings.PreviousSecretKeys {
+		if key != settings.SecretKey {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// CheckSigningKey reports whether the service is able to sign tokens.
+func (s *SessionService) CheckSigningKey(_ context.Context) error {
+	if s.Settings().SecretKey == "" {
+		return fmt.Errorf("signing key is not loaded")
+	}
+	return nil
+}
+
+// KnownAudiences returns every audience access tokens can be issued for.
+func (s *SessionService) KnownAudiences() []string {
+	settings := s.Settings()
+	return append([]string{settings.Audience}, settings.Audiences...)
+}
+
+// CheckAudiences reports an error if an access token can't be issued for one
+// of the audiences, which must be known. No audience stands for the auth
+// service itself.
+func (s *SessionService) CheckAudiences(audiences []string) error {
+	knownAudiences := s.KnownAudiences()
+	for _, audience := range audiences {
+		if !slices.Contains(knownAudiences, audience) {
+			return httperror.NewWithCode(nil, httperror.CodeInvalidAudience, fmt.Sprintf("Unknown audience %q", audience), http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// ParseAccessToken validates the access token and returns its claims.
+// The token must be issued for at least one of the given audiences,
+// the auth service's own audience is used when none is given.
+// Refresh tokens and the tokens of OAuth2 clients are rejected.
+func (s *SessionService) ParseAccessToken(token string, audiences ...string) (*Claims, error) {
+	claims, err := s.parseToken(token, jwt.WithIssuer(s.Settings().Issuer))
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %w", err)
+		return nil, err
+	}
+	if claims.TokenType != TokenTypeAccess {
+		return nil, fmt.Errorf("invalid token type")
+	}
+	knownAudiences := s.KnownAudiences()
+	if claims.Scope != "" || slices.ContainsFunc(claims.Audience, func(audience string) bool {
+		return !slices.Contains(knownAudiences, audience)
+	}) {
+		return nil, fmt.Errorf("token is issued for a client")
+	}
+	if len(audiences) == 0 {
+		audiences = []string{s.Settings().Audience}
+	}
+	if !slices.ContainsFunc(audiences, func(audience string) bool {
+		return slices.Contains(claims.Audience, audience)
+	}) {
+		return nil, fmt.Errorf("token is not issued for the audience")
+	}
+	return claims, nil
+}
+
+// ParseClientAccessToken validates an access token issued to an OAuth2 client
+// by CreateClientAccessToken and returns its claims. The tokens issued by
+// the auth service to its own clients are rejected.
+func (s *SessionService) ParseClientAccessToken(token string) (*Claims, error) {
+	claims, err := s.parseToken(token, jwt.WithIssuer(s.Settings().Issuer))
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != TokenTypeAccess {
+		return nil, fmt.Errorf("invalid token type")
+	}
+	if claims.Scope == "" || len(claims.Audience) != 1 || slices.Contains(s.KnownAudiences(), claims.Audience[0]) {
+		return nil, fmt.Errorf("token is not issued for a client")
 	}
-	claims, ok := t.Claims.(*Claims)
-	if !ok || !t.Valid {
-		return nil, fmt.Errorf("invalid token claims")
+	return claims, nil
+}
+
+func (s *SessionService) parseToken(token string, opts ...jwt.ParserOption) (*Claims, error) {
+	settings := s.Settings()
+	opts = append(
+		opts,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(settings.Leeway),
+	)
+	keys := jwt.VerificationKeySet{}
+	for _, key := range settings.verificationKeys() {
+		keys.Keys = append(keys.Keys, key)
 	}
-	if claims.ExpiresAt.Unix() < time.Now().Unix() {
-		return nil, fmt.Errorf("token expired")
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return keys, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 	return claims, nil
 }
@@ -190,17 +495,28 @@ func (s *SessionService) getClientInfo(userAgent string) string {
 	return defaultClientInfo
 }
 
-func (s *SessionService) getLocation(ip string) string {
+func (s *SessionService) getLocation(ctx context.Context, ip string) string {
 	const defaultLocation = "Unknown Location"
 
+	ctx, span := tracer.Start(ctx, "geolocation.lookup", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
 	var responseData struct {
 		Country string `json:"country"`
 		City    string `json:"city"`
 		Message string `json:"message"`
 	}
 
-	resp, err := http.Get(fmt.Sprintf("http://ip-api.com/json/%s", ip))
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://ip-api.com/json/%s", ip), nil)
+	if err != nil {
+		return defaultLocation
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return defaultLocation
 	}
 	defer resp.Body.Close()
@@ -0,0 +1,422 @@
+package services_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/services"
+	"auth/internal/storage/memory"
+	"auth/pkg/httperror"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	testSecretKey = "session-test-secret-key"
+	testIssuer    = "auth"
+	testAudience  = "auth"
+	testVault     = "vault"
+)
+
+func testTokenSettings() services.TokenSettings {
+	return services.TokenSettings{
+		SecretKey:          testSecretKey,
+		AccessExp:          time.Minute,
+		RefreshExp:         time.Hour,
+		RefreshTokenFormat: services.RefreshTokenFormatOpaque,
+		Issuer:             testIssuer,
+		Audience:           testAudience,
+		Audiences:          []string{testVault},
+		Leeway:             30 * time.Second,
+	}
+}
+
+func newSessionTest(t *testing.T, settings services.TokenSettings) (*services.SessionService, *memory.MemoryStorage) {
+	t.Helper()
+	store := memory.New()
+	return services.NewSessionService(settings, store), store
+}
+
+func insertUser(t *testing.T, store *memory.MemoryStorage) uuid.UUID {
+	t.Helper()
+	user := &models.User{ID: uuid.New(), Email: uuid.NewString() + "@example.com", CreatedAt: time.Now()}
+	if err := store.InsertUser(context.Background(), user); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	return user.ID
+}
+
+// accessClaims returns the claims of a valid access token, issued now.
+func accessClaims(audiences ...string) services.Claims {
+	now := time.Now()
+	userID := uuid.New()
+	return services.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   userID.String(),
+			Audience:  audiences,
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
+		TokenType: services.TokenTypeAccess,
+		UserID:    userID,
+		SessionID: uuid.New(),
+	}
+}
+
+func signToken(t *testing.T, claims services.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAccessTokenAudiences(t *testing.T) {
+	sessionService, store := newSessionTest(t, testTokenSettings())
+	ctx := context.Background()
+	userID := insertUser(t, store)
+	for _, tt := range []struct {
+		name      string
+		audiences []string
+		want      jwt.ClaimStrings
+	}{
+		{name: "default", want: jwt.ClaimStrings{testAudience}},
+		{name: "downstream service", audiences: []string{testVault}, want: jwt.ClaimStrings{testVault}},
+		{name: "several", audiences: []string{testVault, testAudience, testVault}, want: jwt.ClaimStrings{testAudience, testVault}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := sessionService.CreateSession(ctx, userID, "test", "127.0.0.1", tt.audiences...)
+			if err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+			claims, err := sessionService.ParseAccessToken(tokens.AccessToken, sessionService.KnownAudiences()...)
+			if err != nil {
+				t.Fatalf("ParseAccessToken: %v", err)
+			}
+			if !slices.Equal(claims.Audience, tt.want) {
+				t.Errorf("the token is issued for %v, want %v", claims.Audience, tt.want)
+			}
+			_, err = sessionService.ParseAccessToken(tokens.AccessToken)
+			if want := slices.Contains(tt.want, testAudience); (err == nil) != want {
+				t.Errorf("the auth service accepting the token is %t, want %t: %v", err == nil, want, err)
+			}
+		})
+	}
+
+	_, err := sessionService.CreateSession(ctx, userID, "test", "127.0.0.1", testVault, "unknown")
+	if httperror.GetCode(err) != httperror.CodeInvalidAudience {
+		t.Errorf("CreateSession for an unknown audience returned %v, want %s", err, httperror.CodeInvalidAudience)
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	sessionService, _ := newSessionTest(t, testTokenSettings())
+	for _, tt := range []struct {
+		name      string
+		claims    func(claims *services.Claims)
+		audiences []string
+		wantErr   bool
+	}{
+		{
+			name:   "valid",
+			claims: func(*services.Claims) {},
+		},
+		{
+			name:    "wrong issuer",
+			claims:  func(claims *services.Claims) { claims.Issuer = "other-issuer" },
+			wantErr: true,
+		},
+		{
+			name:    "no issuer",
+			claims:  func(claims *services.Claims) { claims.Issuer = "" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown audience",
+			claims:  func(claims *services.Claims) { claims.Audience = jwt.ClaimStrings{"other-service"} },
+			wantErr: true,
+		},
+		{
+			name:    "other service",
+			claims:  func(claims *services.Claims) { claims.Audience = jwt.ClaimStrings{testVault} },
+			wantErr: true,
+		},
+		{
+			name:      "requested audience",
+			claims:    func(claims *services.Claims) { claims.Audience = jwt.ClaimStrings{testVault} },
+			audiences: []string{testVault},
+		},
+		{
+			name:      "not the requested audience",
+			claims:    func(*services.Claims) {},
+			audiences: []string{testVault},
+			wantErr:   true,
+		},
+		{
+			name:    "no audience",
+			claims:  func(claims *services.Claims) { claims.Audience = nil },
+			wantErr: true,
+		},
+		{
+			name: "expired within the leeway",
+			claims: func(claims *services.Claims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+			},
+		},
+		{
+			name: "expired beyond the leeway",
+			claims: func(claims *services.Claims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			},
+			wantErr: true,
+		},
+		{
+			name: "not yet valid within the leeway",
+			claims: func(claims *services.Claims) {
+				claims.NotBefore = jwt.NewNumericDate(time.Now().Add(10 * time.Second))
+			},
+		},
+		{
+			name: "not yet valid beyond the leeway",
+			claims: func(claims *services.Claims) {
+				claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+			},
+			wantErr: true,
+		},
+		{
+			name: "issued in the future beyond the leeway",
+			claims: func(claims *services.Claims) {
+				claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no expiration",
+			claims:  func(claims *services.Claims) { claims.ExpiresAt = nil },
+			wantErr: true,
+		},
+		{
+			name:    "refresh token",
+			claims:  func(claims *services.Claims) { claims.TokenType = services.TokenTypeRefresh },
+			wantErr: true,
+		},
+		{
+			name:    "client token",
+			claims:  func(claims *services.Claims) { claims.Scope = "openid" },
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := accessClaims(testAudience)
+			tt.claims(&claims)
+			_, err := sessionService.ParseAccessToken(signToken(t, claims), tt.audiences...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAccessToken returned %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenLeeway(t *testing.T) {
+	settings := testTokenSettings()
+	settings.Leeway = 0
+	sessionService, _ := newSessionTest(t, settings)
+	claims := accessClaims(testAudience)
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+	if _, err := sessionService.ParseAccessToken(signToken(t, claims)); err == nil {
+		t.Error("a token expired 10s ago is accepted without leeway")
+	}
+}
+
+// hashToken is the hash refresh tokens are stored as.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertRefreshSession stores a session of the user whose refresh token is
+// the given token, as sessions are stored before the token types.
+func insertRefreshSession(t *testing.T, store *memory.MemoryStorage, userID uuid.UUID, sessionID uuid.UUID, token string) {
+	t.Helper()
+	err := store.InsertSession(context.Background(), models.Session{
+		ID:         sessionID,
+		TokenHash:  hashToken(token),
+		IP:         net.ParseIP("127.0.0.1"),
+		Location:   "Private Range",
+		ClientInfo: "test",
+		LastLogin:  time.Now(),
+		UserID:     userID,
+	})
+	if err != nil {
+		t.Fatalf("InsertSession: %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	for _, format := range []string{services.RefreshTokenFormatOpaque, services.RefreshTokenFormatJWT} {
+		t.Run(format, func(t *testing.T) {
+			settings := testTokenSettings()
+			settings.RefreshTokenFormat = format
+			sessionService, store := newSessionTest(t, settings)
+			userID := insertUser(t, store)
+			tokens, err := sessionService.CreateSession(ctx, userID, "test", "127.0.0.1")
+			if err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+			if isOpaque := strings.HasPrefix(tokens.RefreshToken, "gkrt_"); isOpaque != (format == services.RefreshTokenFormatOpaque) {
+				t.Errorf("the refresh token %q is opaque: %t, want the %s format", tokens.RefreshToken, isOpaque, format)
+			}
+			session, err := store.GetSessionByTokenHash(ctx, hashToken(tokens.RefreshToken))
+			if err != nil {
+				t.Fatalf("the session is not found by the hash of its refresh token: %v", err)
+			}
+			if session.TokenHash == tokens.RefreshToken {
+				t.Error("the refresh token is stored instead of its hash")
+			}
+
+			refreshed, err := sessionService.UpdateSession(ctx, tokens.RefreshToken, "test", "127.0.0.1")
+			if err != nil {
+				t.Fatalf("UpdateSession: %v", err)
+			}
+			if refreshed.RefreshToken == tokens.RefreshToken {
+				t.Error("the refresh token has not been rotated")
+			}
+			if _, err := sessionService.UpdateSession(ctx, tokens.RefreshToken, "test", "127.0.0.1"); !httperror.IsNotFound(err) {
+				t.Errorf("refreshing with the rotated token returned %v, want not found", err)
+			}
+			if _, err := sessionService.ParseAccessToken(refreshed.RefreshToken); err == nil {
+				t.Error("the refresh token is accepted as an access token")
+			}
+			if _, err := sessionService.UpdateSession(ctx, refreshed.AccessToken, "test", "127.0.0.1"); httperror.GetCode(err) != httperror.CodeInvalidToken {
+				t.Errorf("refreshing with the access token returned %v, want %s", err, httperror.CodeInvalidToken)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenLegacy(t *testing.T) {
+	ctx := context.Background()
+	// JWT refresh tokens remain accepted after the format has been changed
+	// to opaque tokens.
+	sessionService, store := newSessionTest(t, testTokenSettings())
+	userID := insertUser(t, store)
+
+	for _, tt := range []struct {
+		name      string
+		tokenType string
+		// otherSession signs the token for another session than the one
+		// it is stored for.
+		otherSession bool
+		wantCode     string
+	}{
+		{name: "no type", tokenType: ""},
+		{name: "refresh type", tokenType: services.TokenTypeRefresh},
+		{name: "access type", tokenType: services.TokenTypeAccess, wantCode: httperror.CodeInvalidToken},
+		{name: "other session", tokenType: "", otherSession: true, wantCode: httperror.CodeSessionNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := accessClaims()
+			claims.UserID = userID
+			claims.TokenType = tt.tokenType
+			sessionID := claims.SessionID
+			if tt.otherSession {
+				sessionID = uuid.New()
+			}
+			token := signToken(t, claims)
+			insertRefreshSession(t, store, userID, sessionID, token)
+
+			tokens, err := sessionService.UpdateSession(ctx, token, "test", "127.0.0.1")
+			if tt.wantCode != "" {
+				if httperror.GetCode(err) != tt.wantCode {
+					t.Errorf("UpdateSession returned %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateSession: %v", err)
+			}
+			if !strings.HasPrefix(tokens.RefreshToken, "gkrt_") {
+				t.Errorf("the rotated refresh token %q is not opaque", tokens.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenOpaque(t *testing.T) {
+	ctx := context.Background()
+	sessionService, store := newSessionTest(t, testTokenSettings())
+	userID := insertUser(t, store)
+
+	if _, err := sessionService.UpdateSession(ctx, "gkrt_unknown", "test", "127.0.0.1"); !httperror.IsNotFound(err) {
+		t.Errorf("refreshing with an unknown opaque token returned %v, want not found", err)
+	}
+
+	// An opaque token expires RefreshExp after it has been issued, when the
+	// session has last been refreshed.
+	const token = "gkrt_expired"
+	sessionID := uuid.New()
+	insertRefreshSession(t, store, userID, sessionID, token)
+	session, err := store.GetSession(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	session.LastLogin = time.Now().Add(-testTokenSettings().RefreshExp - time.Second)
+	if err := store.UpdateSession(ctx, *session); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if _, err := sessionService.UpdateSession(ctx, token, "test", "127.0.0.1"); httperror.GetCode(err) != httperror.CodeInvalidToken {
+		t.Errorf("refreshing with an expired opaque token returned %v, want %s", err, httperror.CodeInvalidToken)
+	}
+	if session, err := sessionService.AuthenticateRefreshToken(ctx, token); session != nil || err != nil {
+		t.Errorf("the expired opaque token authenticates %v, %v", session, err)
+	}
+}
+
+// caseInsensitiveStore matches the hashes of refresh tokens ignoring the
+// case, like a database with a case-insensitive collation.
+type caseInsensitiveStore struct {
+	*memory.MemoryStorage
+}
+
+func (s caseInsensitiveStore) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	session, err := s.MemoryStorage.GetSessionByTokenHash(ctx, tokenHash)
+	if httperror.IsNotFound(err) {
+		return s.MemoryStorage.GetSessionByTokenHash(ctx, strings.ToUpper(tokenHash))
+	}
+	return session, err
+}
+
+func TestRefreshTokenHashIsCompared(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	sessionService := services.NewSessionService(testTokenSettings(), caseInsensitiveStore{store})
+	userID := insertUser(t, store)
+	const token = "gkrt_token"
+	sessionID := uuid.New()
+	insertRefreshSession(t, store, userID, sessionID, token)
+	session, err := store.GetSession(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	session.TokenHash = strings.ToUpper(session.TokenHash)
+	if err := store.UpdateSession(ctx, *session); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+
+	if _, err := sessionService.UpdateSession(ctx, token, "test", "127.0.0.1"); httperror.GetCode(err) != httperror.CodeSessionNotFound {
+		t.Errorf("refreshing with a token matching another hash returned %v, want %s", err, httperror.CodeSessionNotFound)
+	}
+}
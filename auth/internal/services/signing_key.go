@@ -0,0 +1,101 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// SigningKey is the RSA key ID tokens are signed with. Unlike the access
+// tokens, ID tokens are verified by third parties, so they can't be signed
+// with the shared secret.
+type SigningKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// JSONWebKey is the public part of a signing key as described by RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JSONWebKeySet is the document served at the jwks_uri.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// LoadSigningKey reads a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	var privateKey *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var key any
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			privateKey, ok = key.(*rsa.PrivateKey)
+			if !ok {
+				err = fmt.Errorf("signing key is not an RSA key")
+			}
+		}
+	default:
+		err = fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	return newSigningKey(privateKey), nil
+}
+
+// GenerateSigningKey creates a new key. Tokens signed with it can't be
+// verified after a restart, so it is only suitable for development.
+func GenerateSigningKey() (*SigningKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return newSigningKey(privateKey), nil
+}
+
+func newSigningKey(privateKey *rsa.PrivateKey) *SigningKey {
+	// The key id is derived from the public key, so it stays the same across
+	// restarts and changes whenever the key is rotated.
+	der := x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)
+	sum := sha256.Sum256(der)
+	return &SigningKey{
+		ID:         base64.RawURLEncoding.EncodeToString(sum[:12]),
+		PrivateKey: privateKey,
+	}
+}
+
+func (k *SigningKey) JSONWebKey() JSONWebKey {
+	return JSONWebKey{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: "RS256",
+		KeyID:     k.ID,
+		Modulus:   base64.RawURLEncoding.EncodeToString(k.PrivateKey.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.PrivateKey.E)).Bytes()),
+	}
+}
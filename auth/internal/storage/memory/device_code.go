@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *MemoryStorage) GetDeviceCodeByHash(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error) {
+	defer storage.rlock(ctx)()
+
+	for _, deviceCode := range storage.deviceCodes {
+		if deviceCode.DeviceCodeHash == deviceCodeHash {
+			return &deviceCode, nil
+		}
+	}
+	return nil, httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+}
+
+func (storage *MemoryStorage) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	defer storage.rlock(ctx)()
+
+	for _, deviceCode := range storage.deviceCodes {
+		if deviceCode.UserCode == userCode {
+			return &deviceCode, nil
+		}
+	}
+	return nil, httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+}
+
+func (storage *MemoryStorage) InsertDeviceCode(ctx context.Context, deviceCode *models.DeviceCode) error {
+	defer storage.lock(ctx)()
+
+	for _, existing := range storage.deviceCodes {
+		if existing.ID == deviceCode.ID || existing.DeviceCodeHash == deviceCode.DeviceCodeHash || existing.UserCode == deviceCode.UserCode {
+			return fmt.Errorf("device code %s already exists", deviceCode.ID)
+		}
+	}
+	if deviceCode.UserID.Valid {
+		if _, ok := storage.users[deviceCode.UserID.UUID]; !ok {
+			return fmt.Errorf("user %s does not exist", deviceCode.UserID.UUID)
+		}
+	}
+	storage.deviceCodes[deviceCode.ID] = *deviceCode
+	return nil
+}
+
+// UpdateDeviceCodePolling records a poll of the token endpoint.
+func (storage *MemoryStorage) UpdateDeviceCodePolling(ctx context.Context, deviceCodeID uuid.UUID, lastPolledAt time.Time, intervalSeconds int) error {
+	defer storage.lock(ctx)()
+
+	deviceCode, ok := storage.deviceCodes[deviceCodeID]
+	if !ok {
+		return nil
+	}
+	deviceCode.LastPolledAt = lastPolledAt
+	deviceCode.IntervalSeconds = intervalSeconds
+	storage.deviceCodes[deviceCodeID] = deviceCode
+	return nil
+}
+
+// DecideDeviceCode approves or denies a pending device code and fails with
+// not found if it has already been decided.
+func (storage *MemoryStorage) DecideDeviceCode(ctx context.Context, deviceCodeID uuid.UUID, status string, userID uuid.UUID) error {
+	defer storage.lock(ctx)()
+
+	deviceCode, ok := storage.deviceCodes[deviceCodeID]
+	if !ok || deviceCode.Status != models.DeviceCodeStatusPending {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	if _, ok := storage.users[userID]; !ok {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+	deviceCode.Status = status
+	deviceCode.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	storage.deviceCodes[deviceCodeID] = deviceCode
+	return nil
+}
+
+// DeleteExpiredDeviceCodes deletes the codes expired before the given time
+// and returns how many have been deleted.
+func (storage *MemoryStorage) DeleteExpiredDeviceCodes(ctx context.Context, before time.Time) (int64, error) {
+	defer storage.lock(ctx)()
+
+	var deleted int64
+	for id, deviceCode := range storage.deviceCodes {
+		if deviceCode.ExpiresAt.Before(before) {
+			delete(storage.deviceCodes, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ConsumeDeviceCode deletes the code and fails with not found if it has already been used.
+func (storage *MemoryStorage) ConsumeDeviceCode(ctx context.Context, deviceCodeID uuid.UUID) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.deviceCodes[deviceCodeID]; !ok {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	delete(storage.deviceCodes, deviceCodeID)
+	return nil
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *MemoryStorage) GetEmailCodeByID(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
+	defer storage.rlock(ctx)()
+
+	emailCode, ok := storage.emailCodes[emailCodeID]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+	}
+	return &emailCode, nil
+}
+
+// IncrementEmailCodeAttempts atomically counts a new check attempt and returns the updated code.
+func (storage *MemoryStorage) IncrementEmailCodeAttempts(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
+	defer storage.lock(ctx)()
+
+	emailCode, ok := storage.emailCodes[emailCodeID]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+	}
+	emailCode.NumberOfAttempts += 1
+	storage.emailCodes[emailCodeID] = emailCode
+	return &emailCode, nil
+}
+
+// ConsumeEmailCode deletes the code and fails with not found if it has already been used.
+func (storage *MemoryStorage) ConsumeEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.emailCodes[emailCodeID]; !ok {
+		return httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+	}
+	delete(storage.emailCodes, emailCodeID)
+	return nil
+}
+
+func (storage *MemoryStorage) DeleteEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
+	defer storage.lock(ctx)()
+
+	delete(storage.emailCodes, emailCodeID)
+	return nil
+}
+
+func (storage *MemoryStorage) InsertEmailCode(ctx context.Context, emailCode *models.EmailCode) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.emailCodes[emailCode.ID]; ok {
+		return fmt.Errorf("email code %s already exists", emailCode.ID)
+	}
+	storage.emailCodes[emailCode.ID] = *emailCode
+	return nil
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+func (storage *MemoryStorage) GetIdentity(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	defer storage.rlock(ctx)()
+
+	identity, ok := storage.identities[identityKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeIdentityNotFound, "Identity not found", http.StatusNotFound)
+	}
+	return &identity, nil
+}
+
+func (storage *MemoryStorage) GetIdentitiesList(ctx context.Context, userID uuid.UUID) ([]*models.Identity, error) {
+	defer storage.rlock(ctx)()
+
+	identities := []*models.Identity{}
+	for _, identity := range storage.identities {
+		if identity.UserID == userID {
+			identities = append(identities, &identity)
+		}
+	}
+	slices.SortFunc(identities, func(a, b *models.Identity) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return identities, nil
+}
+
+// UpsertIdentity inserts the identity unless the provider account is already linked.
+// It returns the stored identity and whether it has been created.
+func (storage *MemoryStorage) UpsertIdentity(ctx context.Context, identity *models.Identity) (*models.Identity, bool, error) {
+	defer storage.lock(ctx)()
+
+	key := identityKey{provider: identity.Provider, subject: identity.Subject}
+	if existing, ok := storage.identities[key]; ok {
+		return &existing, false, nil
+	}
+	if _, ok := storage.users[identity.UserID]; !ok {
+		return nil, false, fmt.Errorf("user %s does not exist", identity.UserID)
+	}
+	storage.identities[key] = *identity
+	return identity, true, nil
+}
+
+func (storage *MemoryStorage) UpdateIdentityEmail(ctx context.Context, identityID uuid.UUID, email string) error {
+	defer storage.lock(ctx)()
+
+	for key, identity := range storage.identities {
+		if identity.ID == identityID {
+			identity.Email = email
+			storage.identities[key] = identity
+		}
+	}
+	return nil
+}
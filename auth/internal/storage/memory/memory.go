@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"auth/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage keeps all data in process memory. It mirrors the behaviour of
+// PSQLStorage and is meant for local development and tests.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	emailCodes   map[uuid.UUID]models.EmailCode
+	users        map[uuid.UUID]models.User
+	usersByEmail map[string]uuid.UUID
+	sessions     map[uuid.UUID]models.Session
+	deviceCodes  map[uuid.UUID]models.DeviceCode
+
+	oauthClients       map[string]models.OAuthClient
+	authorizationCodes map[string]models.AuthorizationCode
+	consents           map[consentKey]models.Consent
+
+	identities map[identityKey]models.Identity
+
+	outboxEmails map[uuid.UUID]models.OutboxEmail
+}
+
+func New() *MemoryStorage {
+	return &MemoryStorage{
+		emailCodes:   map[uuid.UUID]models.EmailCode{},
+		users:        map[uuid.UUID]models.User{},
+		usersByEmail: map[string]uuid.UUID{},
+		sessions:     map[uuid.UUID]models.Session{},
+		deviceCodes:  map[uuid.UUID]models.DeviceCode{},
+
+		oauthClients:       map[string]models.OAuthClient{},
+		authorizationCodes: map[string]models.AuthorizationCode{},
+		consents:           map[consentKey]models.Consent{},
+
+		identities: map[identityKey]models.Identity{},
+
+		outboxEmails: map[uuid.UUID]models.OutboxEmail{},
+	}
+}
+
+func (storage *MemoryStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+func (storage *MemoryStorage) Close() {}
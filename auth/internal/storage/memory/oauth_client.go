@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+type consentKey struct {
+	userID   uuid.UUID
+	clientID string
+}
+
+func (storage *MemoryStorage) GetOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	defer storage.rlock(ctx)()
+
+	client, ok := storage.oauthClients[clientID]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeClientNotFound, "OAuthClient not found", http.StatusNotFound)
+	}
+	return copyOAuthClient(client), nil
+}
+
+func (storage *MemoryStorage) GetOAuthClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	defer storage.rlock(ctx)()
+
+	clients := []*models.OAuthClient{}
+	for _, client := range storage.oauthClients {
+		clients = append(clients, copyOAuthClient(client))
+	}
+	slices.SortFunc(clients, func(a, b *models.OAuthClient) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return clients, nil
+}
+
+func (storage *MemoryStorage) InsertOAuthClient(ctx context.Context, client *models.OAuthClient) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.oauthClients[client.ID]; ok {
+		return fmt.Errorf("oauth client %s already exists", client.ID)
+	}
+	storage.oauthClients[client.ID] = *copyOAuthClient(*client)
+	return nil
+}
+
+func (storage *MemoryStorage) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.oauthClients[clientID]; !ok {
+		return httperror.NewWithCode(nil, httperror.CodeClientNotFound, "OAuthClient not found", http.StatusNotFound)
+	}
+	delete(storage.oauthClients, clientID)
+	for hash, code := range storage.authorizationCodes {
+		if code.ClientID == clientID {
+			delete(storage.authorizationCodes, hash)
+		}
+	}
+	for key := range storage.consents {
+		if key.clientID == clientID {
+			delete(storage.consents, key)
+		}
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) InsertAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.authorizationCodes[code.CodeHash]; ok {
+		return fmt.Errorf("authorization code already exists")
+	}
+	if _, ok := storage.oauthClients[code.ClientID]; !ok {
+		return fmt.Errorf("oauth client %s does not exist", code.ClientID)
+	}
+	if _, ok := storage.sessions[code.SessionID]; !ok {
+		return fmt.Errorf("session %s does not exist", code.SessionID)
+	}
+	storage.authorizationCodes[code.CodeHash] = *code
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes the code and returns it, so that a code
+// can be exchanged only once even by concurrent requests.
+func (storage *MemoryStorage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	defer storage.lock(ctx)()
+
+	code, ok := storage.authorizationCodes[codeHash]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeAuthorizationCodeNotFound, "AuthorizationCode not found", http.StatusNotFound)
+	}
+	delete(storage.authorizationCodes, codeHash)
+	return &code, nil
+}
+
+func (storage *MemoryStorage) GetConsent(ctx context.Context, userID uuid.UUID, clientID string) (*models.Consent, error) {
+	defer storage.rlock(ctx)()
+
+	consent, ok := storage.consents[consentKey{userID: userID, clientID: clientID}]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeConsentNotFound, "Consent not found", http.StatusNotFound)
+	}
+	return &consent, nil
+}
+
+func (storage *MemoryStorage) UpsertConsent(ctx context.Context, consent *models.Consent) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.oauthClients[consent.ClientID]; !ok {
+		return fmt.Errorf("oauth client %s does not exist", consent.ClientID)
+	}
+	if _, ok := storage.users[consent.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", consent.UserID)
+	}
+	storage.consents[consentKey{userID: consent.UserID, clientID: consent.ClientID}] = *consent
+	return nil
+}
+
+func copyOAuthClient(client models.OAuthClient) *models.OAuthClient {
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	return &client
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *MemoryStorage) InsertOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.outboxEmails[email.ID]; ok {
+		return fmt.Errorf("outbox email %s already exists", email.ID)
+	}
+	storage.outboxEmails[email.ID] = *email
+	return nil
+}
+
+// ClaimOutboxEmails returns up to limit pending emails due at now, counting
+// an attempt for each. They are not due again until leaseUntil, so that no
+// other worker picks them up while they are being sent.
+func (storage *MemoryStorage) ClaimOutboxEmails(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]*models.OutboxEmail, error) {
+	defer storage.lock(ctx)()
+
+	due := []models.OutboxEmail{}
+	for _, email := range storage.outboxEmails {
+		if email.Status == models.OutboxEmailStatusPending && !email.NextAttemptAt.After(now) {
+			due = append(due, email)
+		}
+	}
+	slices.SortFunc(due, func(a, b models.OutboxEmail) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+	emails := []*models.OutboxEmail{}
+	for _, email := range due[:min(limit, len(due))] {
+		email.Attempts++
+		email.NextAttemptAt = leaseUntil
+		storage.outboxEmails[email.ID] = email
+		emails = append(emails, &email)
+	}
+	return emails, nil
+}
+
+// UpdateOutboxEmail stores the outcome of a delivery attempt.
+func (storage *MemoryStorage) UpdateOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	defer storage.lock(ctx)()
+
+	existing, ok := storage.outboxEmails[email.ID]
+	if !ok {
+		return nil
+	}
+	existing.Subject = email.Subject
+	existing.TextBody = email.TextBody
+	existing.HTMLBody = email.HTMLBody
+	existing.Status = email.Status
+	existing.NextAttemptAt = email.NextAttemptAt
+	existing.LastError = email.LastError
+	existing.UpdatedAt = email.UpdatedAt
+	storage.outboxEmails[email.ID] = existing
+	return nil
+}
+
+// GetOutboxEmailByReference returns the latest email about the referenced object.
+func (storage *MemoryStorage) GetOutboxEmailByReference(ctx context.Context, referenceID uuid.UUID) (*models.OutboxEmail, error) {
+	defer storage.rlock(ctx)()
+
+	var latest *models.OutboxEmail
+	for _, email := range storage.outboxEmails {
+		if email.ReferenceID.Valid && email.ReferenceID.UUID == referenceID {
+			if latest == nil || email.CreatedAt.After(latest.CreatedAt) {
+				latest = &email
+			}
+		}
+	}
+	if latest == nil {
+		return nil, httperror.NewWithCode(nil, httperror.CodeOutboxEmailNotFound, "OutboxEmail not found", http.StatusNotFound)
+	}
+	return latest, nil
+}
+
+// DeleteOutboxEmails deletes sent and dead emails last updated before the
+// given time and returns how many have been deleted.
+func (storage *MemoryStorage) DeleteOutboxEmails(ctx context.Context, before time.Time) (int64, error) {
+	defer storage.lock(ctx)()
+
+	var deleted int64
+	for id, email := range storage.outboxEmails {
+		if email.Status != models.OutboxEmailStatusPending && email.UpdatedAt.Before(before) {
+			delete(storage.outboxEmails, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
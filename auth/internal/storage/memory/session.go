@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *MemoryStorage) GetSessionsList(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	defer storage.rlock(ctx)()
+
+	sessionsList := []*models.Session{}
+	for _, session := range storage.sessions {
+		if session.UserID == userID {
+			session := copySession(session)
+			sessionsList = append(sessionsList, &session)
+		}
+	}
+	return sessionsList, nil
+}
+
+func (storage *MemoryStorage) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	defer storage.rlock(ctx)()
+
+	session, ok := storage.sessions[sessionID]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+	}
+	session = copySession(session)
+	return &session, nil
+}
+
+func (storage *MemoryStorage) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	defer storage.rlock(ctx)()
+
+	for _, session := range storage.sessions {
+		if session.TokenHash == tokenHash {
+			session = copySession(session)
+			return &session, nil
+		}
+	}
+	return nil, httperror.NewWithCode(nil, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+}
+
+func (storage *MemoryStorage) InsertSession(ctx context.Context, session models.Session) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.sessions[session.ID]; ok {
+		return fmt.Errorf("session %s already exists", session.ID)
+	}
+	if _, ok := storage.users[session.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", session.UserID)
+	}
+	if storage.isTokenTaken(session.TokenHash, session.ID) {
+		return fmt.Errorf("session token hash already exists")
+	}
+	storage.sessions[session.ID] = copySession(session)
+	return nil
+}
+
+func (storage *MemoryStorage) UpdateSession(ctx context.Context, session models.Session) error {
+	if session.ID == uuid.Nil {
+		return fmt.Errorf("session id is required for the update")
+	}
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.sessions[session.ID]; !ok {
+		return nil
+	}
+	if _, ok := storage.users[session.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", session.UserID)
+	}
+	if storage.isTokenTaken(session.TokenHash, session.ID) {
+		return fmt.Errorf("session token hash already exists")
+	}
+	storage.sessions[session.ID] = copySession(session)
+	return nil
+}
+
+func (storage *MemoryStorage) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	defer storage.lock(ctx)()
+
+	delete(storage.sessions, sessionID)
+	for hash, code := range storage.authorizationCodes {
+		if code.SessionID == sessionID {
+			delete(storage.authorizationCodes, hash)
+		}
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) isTokenTaken(tokenHash string, exceptSessionID uuid.UUID) bool {
+	for id, session := range storage.sessions {
+		if id != exceptSessionID && session.TokenHash == tokenHash {
+			return true
+		}
+	}
+	return false
+}
+
+func copySession(session models.Session) models.Session {
+	session.IP = append(net.IP(nil), session.IP...)
+	return session
+}
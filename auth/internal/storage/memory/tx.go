@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"maps"
+)
+
+type txKey struct{}
+
+// WithinTx runs fn with exclusive access to the storage and discards all
+// changes made by fn if it returns an error.
+func (storage *MemoryStorage) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if storage.inTx(ctx) {
+		return fn(ctx)
+	}
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	snapshot := storage.snapshot()
+	err := fn(context.WithValue(ctx, txKey{}, storage))
+	if err != nil {
+		storage.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) inTx(ctx context.Context) bool {
+	owner, _ := ctx.Value(txKey{}).(*MemoryStorage)
+	return owner == storage
+}
+
+// lock acquires the write lock unless it is already held by the transaction in ctx.
+func (storage *MemoryStorage) lock(ctx context.Context) func() {
+	if storage.inTx(ctx) {
+		return func() {}
+	}
+	storage.mu.Lock()
+	return storage.mu.Unlock
+}
+
+// rlock acquires the read lock unless the write lock is held by the transaction in ctx.
+func (storage *MemoryStorage) rlock(ctx context.Context) func() {
+	if storage.inTx(ctx) {
+		return func() {}
+	}
+	storage.mu.RLock()
+	return storage.mu.RUnlock
+}
+
+func (storage *MemoryStorage) snapshot() *MemoryStorage {
+	return &MemoryStorage{
+		emailCodes:   maps.Clone(storage.emailCodes),
+		users:        maps.Clone(storage.users),
+		usersByEmail: maps.Clone(storage.usersByEmail),
+		sessions:     maps.Clone(storage.sessions),
+		deviceCodes:  maps.Clone(storage.deviceCodes),
+
+		oauthClients:       maps.Clone(storage.oauthClients),
+		authorizationCodes: maps.Clone(storage.authorizationCodes),
+		consents:           maps.Clone(storage.consents),
+
+		identities: maps.Clone(storage.identities),
+
+		outboxEmails: maps.Clone(storage.outboxEmails),
+	}
+}
+
+func (storage *MemoryStorage) restore(snapshot *MemoryStorage) {
+	storage.emailCodes = snapshot.emailCodes
+	storage.users = snapshot.users
+	storage.usersByEmail = snapshot.usersByEmail
+	storage.sessions = snapshot.sessions
+	storage.deviceCodes = snapshot.deviceCodes
+	storage.oauthClients = snapshot.oauthClients
+	storage.authorizationCodes = snapshot.authorizationCodes
+	storage.consents = snapshot.consents
+	storage.identities = snapshot.identities
+	storage.outboxEmails = snapshot.outboxEmails
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *MemoryStorage) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer storage.rlock(ctx)()
+
+	userID, ok := storage.usersByEmail[email]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
+	}
+	user := storage.users[userID]
+	return &user, nil
+}
+
+func (storage *MemoryStorage) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	defer storage.rlock(ctx)()
+
+	user, ok := storage.users[userID]
+	if !ok {
+		return nil, httperror.NewWithCode(nil, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
+	}
+	return &user, nil
+}
+
+func (storage *MemoryStorage) InsertUser(ctx context.Context, user *models.User) error {
+	defer storage.lock(ctx)()
+
+	if _, ok := storage.users[user.ID]; ok {
+		return fmt.Errorf("user %s already exists", user.ID)
+	}
+	if _, ok := storage.usersByEmail[user.Email]; ok {
+		return fmt.Errorf("user with email %s already exists", user.Email)
+	}
+	storage.users[user.ID] = *user
+	storage.usersByEmail[user.Email] = user.ID
+	return nil
+}
+
+// UpsertUser inserts the user unless a user with the same email exists.
+// It returns the stored user and whether it has been created.
+func (storage *MemoryStorage) UpsertUser(ctx context.Context, user *models.User) (*models.User, bool, error) {
+	defer storage.lock(ctx)()
+
+	if userID, ok := storage.usersByEmail[user.Email]; ok {
+		existing := storage.users[userID]
+		return &existing, false, nil
+	}
+	if _, ok := storage.users[user.ID]; ok {
+		return nil, false, fmt.Errorf("user %s already exists", user.ID)
+	}
+	storage.users[user.ID] = *user
+	storage.usersByEmail[user.Email] = user.ID
+	created := *user
+	return &created, true, nil
+}
+
+func (storage *MemoryStorage) UpdateUserEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	defer storage.lock(ctx)()
+
+	user, ok := storage.users[userID]
+	if !ok {
+		return nil
+	}
+	if ownerID, ok := storage.usersByEmail[email]; ok && ownerID != userID {
+		return fmt.Errorf("user with email %s already exists", email)
+	}
+	delete(storage.usersByEmail, user.Email)
+	user.Email = email
+	storage.users[userID] = user
+	storage.usersByEmail[email] = userID
+	return nil
+}
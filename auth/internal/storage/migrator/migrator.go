@@ -0,0 +1,74 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
+)
+
+// Migrator applies the embedded goose migrations of a storage backend.
+type Migrator struct {
+	provider *goose.Provider
+}
+
+func New(dialect database.Dialect, db *sql.DB, fsys fs.FS, goMigrations ...*goose.Migration) (*Migrator, error) {
+	provider, err := goose.NewProvider(dialect, db, fsys, goose.WithGoMigrations(goMigrations...))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{provider: provider}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	return m.provider.Up(ctx)
+}
+
+// UpTo applies the pending migrations up to and including version.
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]*goose.MigrationResult, error) {
+	return m.provider.UpTo(ctx, version)
+}
+
+// DownTo rolls back the migrations applied after version, zero rolls back all of them.
+func (m *Migrator) DownTo(ctx context.Context, version int64) ([]*goose.MigrationResult, error) {
+	return m.provider.DownTo(ctx, version)
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, error) {
+	return m.provider.Down(ctx)
+}
+
+// Redo rolls back the most recently applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) ([]*goose.MigrationResult, error) {
+	down, err := m.provider.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+	up, err := m.provider.UpByOne(ctx)
+	if err != nil {
+		return []*goose.MigrationResult{down}, err
+	}
+	return []*goose.MigrationResult{down, up}, nil
+}
+
+// Status returns the state of every known migration.
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	return m.provider.Status(ctx)
+}
+
+// Check returns an error if the database schema is behind the known migrations.
+func (m *Migrator) Check(ctx context.Context) error {
+	hasPending, err := m.provider.HasPending(ctx)
+	if err != nil {
+		return err
+	}
+	if hasPending {
+		return errors.New("database has pending migrations")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package migrator_test
+
+import (
+	"context"
+	"testing"
+
+	"auth/internal/storage"
+	"auth/internal/storage/storagetest"
+
+	"github.com/pressly/goose/v3"
+)
+
+// TestUpDownRedo applies every migration, rolls all of them back and applies
+// them again, so that the down migrations are known to undo the up ones.
+func TestUpDownRedo(t *testing.T) {
+	dialects := []struct {
+		name string
+		open func(t *testing.T) storage.Migratable
+	}{
+		{storage.DriverSQLite, func(t *testing.T) storage.Migratable { return storagetest.OpenSQLite(t) }},
+		{storage.DriverPostgres, func(t *testing.T) storage.Migratable { return storagetest.OpenPostgres(t) }},
+	}
+	for _, dialect := range dialects {
+		t.Run(dialect.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := dialect.open(t).Migrations()
+
+			if _, err := m.Up(ctx); err != nil {
+				t.Fatalf("Up: %v", err)
+			}
+			if err := m.Check(ctx); err != nil {
+				t.Fatalf("Check after Up: %v", err)
+			}
+			checkStates(t, m.Status, goose.StateApplied)
+
+			if _, err := m.DownTo(ctx, 0); err != nil {
+				t.Fatalf("DownTo(0): %v", err)
+			}
+			if err := m.Check(ctx); err == nil {
+				t.Error("Check after rolling back every migration reports no pending migration")
+			}
+			checkStates(t, m.Status, goose.StatePending)
+
+			if _, err := m.Up(ctx); err != nil {
+				t.Fatalf("Up after rolling back: %v", err)
+			}
+			if _, err := m.Redo(ctx); err != nil {
+				t.Fatalf("Redo: %v", err)
+			}
+			if err := m.Check(ctx); err != nil {
+				t.Fatalf("Check after Redo: %v", err)
+			}
+			checkStates(t, m.Status, goose.StateApplied)
+		})
+	}
+}
+
+func checkStates(t *testing.T, status func(ctx context.Context) ([]*goose.MigrationStatus, error), want goose.State) {
+	t.Helper()
+	statuses, err := status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("Status returned no migration")
+	}
+	for _, s := range statuses {
+		if s.State != want {
+			t.Errorf("migration %d is %s, want %s", s.Source.Version, s.State, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const deviceCodeColumns = "id, device_code_hash, user_code, client_id, scope, status, user_id, expires_at, last_polled_at, interval_seconds"
+
+func scanDeviceCode(row pgx.Row) (*models.DeviceCode, error) {
+	deviceCode := models.DeviceCode{}
+	err := row.Scan(
+		&deviceCode.ID,
+		&deviceCode.DeviceCodeHash,
+		&deviceCode.UserCode,
+		&deviceCode.ClientID,
+		&deviceCode.Scope,
+		&deviceCode.Status,
+		&deviceCode.UserID,
+		&deviceCode.ExpiresAt,
+		&deviceCode.LastPolledAt,
+		&deviceCode.IntervalSeconds,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &deviceCode, nil
+}
+
+func (storage *PSQLStorage) GetDeviceCodeByHash(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error) {
+	query := "SELECT " + deviceCodeColumns + " FROM device_codes WHERE device_code_hash=$1"
+	return scanDeviceCode(storage.conn(ctx).QueryRow(ctx, query, deviceCodeHash))
+}
+
+func (storage *PSQLStorage) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	query := "SELECT " + deviceCodeColumns + " FROM device_codes WHERE user_code=$1"
+	return scanDeviceCode(storage.conn(ctx).QueryRow(ctx, query, userCode))
+}
+
+func (storage *PSQLStorage) InsertDeviceCode(ctx context.Context, deviceCode *models.DeviceCode) error {
+	query := "INSERT INTO device_codes (" + deviceCodeColumns + ") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)"
+	_, err := storage.conn(ctx).Exec(
+		ctx,
+		query,
+		deviceCode.ID,
+		deviceCode.DeviceCodeHash,
+		deviceCode.UserCode,
+		deviceCode.ClientID,
+		deviceCode.Scope,
+		deviceCode.Status,
+		deviceCode.UserID,
+		deviceCode.ExpiresAt.UTC(),
+		deviceCode.LastPolledAt.UTC(),
+		deviceCode.IntervalSeconds,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateDeviceCodePolling records a poll of the token endpoint.
+func (storage *PSQLStorage) UpdateDeviceCodePolling(ctx context.Context, deviceCodeID uuid.UUID, lastPolledAt time.Time, intervalSeconds int) error {
+	query := "UPDATE device_codes SET last_polled_at=$2, interval_seconds=$3 WHERE id=$1"
+	_, err := storage.conn(ctx).Exec(ctx, query, deviceCodeID, lastPolledAt.UTC(), intervalSeconds)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DecideDeviceCode approves or denies a pending device code and fails with
+// not found if it has already been decided.
+func (storage *PSQLStorage) DecideDeviceCode(ctx context.Context, deviceCodeID uuid.UUID, status string, userID uuid.UUID) error {
+	query := "UPDATE device_codes SET status=$2, user_id=$3 WHERE id=$1 AND status=$4"
+	tag, err := storage.conn(ctx).Exec(ctx, query, deviceCodeID, status, userID, models.DeviceCodeStatusPending)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// ConsumeDeviceCode deletes the code and fails with not found if it has already been used.
+func (storage *PSQLStorage) ConsumeDeviceCode(ctx context.Context, deviceCodeID uuid.UUID) error {
+	query := "DELETE FROM device_codes WHERE id=$1"
+	tag, err := storage.conn(ctx).Exec(ctx, query, deviceCodeID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// DeleteExpiredDeviceCodes deletes the codes expired before the given time
+// and returns how many have been deleted.
+//
+// Times are stored in UTC, as the columns have no time zone and pgx sends
+// the wall clock of the time.
+func (storage *PSQLStorage) DeleteExpiredDeviceCodes(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM device_codes WHERE expires_at<$1"
+	tag, err := storage.conn(ctx).Exec(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
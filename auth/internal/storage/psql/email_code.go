@@ -3,7 +3,6 @@ package psql
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 
 	"auth/internal/models"
@@ -15,7 +14,7 @@ import (
 
 func (storage *PSQLStorage) GetEmailCodeByID(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
 	query := "SELECT id, email, code, expires_at, number_of_attempts FROM email_codes WHERE id=$1"
-	row := storage.QueryRow(ctx, query, emailCodeID)
+	row := storage.conn(ctx).QueryRow(ctx, query, emailCodeID)
 	emailCode := models.EmailCode{}
 	err := row.Scan(
 		&emailCode.ID,
@@ -26,37 +25,50 @@ func (storage *PSQLStorage) GetEmailCodeByID(ctx context.Context, emailCodeID uu
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, httperror.New(err, "EmailCode not found", http.StatusNotFound)
+			return nil, httperror.NewWithCode(err, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
 		}
-		println(err.Error())
 		return nil, err
 	}
 	return &emailCode, nil
 }
 
-func (storage *PSQLStorage) UpdateEmailCode(ctx context.Context, emailCode *models.EmailCode) error {
-	if emailCode.ID == uuid.Nil {
-		return fmt.Errorf("failed to update emailCode: ID cannot be empty")
-	}
-	query := "UPDATE email_codes SET email=$2, code=$3, expires_at=$4, number_of_attempts=$5 WHERE id=$1"
-	_, err := storage.Exec(
-		ctx,
-		query,
-		emailCode.ID,
-		emailCode.Email,
-		emailCode.Code,
-		emailCode.ExpiresAt,
-		emailCode.NumberOfAttempts,
+// IncrementEmailCodeAttempts atomically counts a new check attempt and returns the updated code.
+func (storage *PSQLStorage) IncrementEmailCodeAttempts(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
+	query := "UPDATE email_codes SET number_of_attempts=number_of_attempts+1 WHERE id=$1 RETURNING id, email, code, expires_at, number_of_attempts"
+	row := storage.conn(ctx).QueryRow(ctx, query, emailCodeID)
+	emailCode := models.EmailCode{}
+	err := row.Scan(
+		&emailCode.ID,
+		&emailCode.Email,
+		&emailCode.Code,
+		&emailCode.ExpiresAt,
+		&emailCode.NumberOfAttempts,
 	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &emailCode, nil
+}
+
+// ConsumeEmailCode deletes the code and fails with not found if it has already been used.
+func (storage *PSQLStorage) ConsumeEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
+	query := "DELETE FROM email_codes WHERE id=$1"
+	tag, err := storage.conn(ctx).Exec(ctx, query, emailCodeID)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+	}
 	return nil
 }
 
 func (storage *PSQLStorage) DeleteEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
 	query := "DELETE FROM email_codes WHERE id=$1"
-	_, err := storage.Exec(ctx, query, emailCodeID)
+	_, err := storage.conn(ctx).Exec(ctx, query, emailCodeID)
 	if err != nil {
 		return err
 	}
@@ -65,13 +77,13 @@ func (storage *PSQLStorage) DeleteEmailCode(ctx context.Context, emailCodeID uui
 
 func (storage *PSQLStorage) InsertEmailCode(ctx context.Context, emailCode *models.EmailCode) error {
 	query := "INSERT INTO email_codes (id, email, code, expires_at, number_of_attempts) VALUES($1,$2,$3,$4,$5)"
-	_, err := storage.Exec(
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		emailCode.ID,
 		emailCode.Email,
 		emailCode.Code,
-		emailCode.ExpiresAt,
+		emailCode.ExpiresAt.UTC(),
 		emailCode.NumberOfAttempts,
 	)
 	if err != nil {
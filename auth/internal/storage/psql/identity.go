@@ -0,0 +1,96 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (storage *PSQLStorage) GetIdentity(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	query := "SELECT id, user_id, provider, subject, email, created_at FROM identities WHERE provider=$1 AND subject=$2"
+	row := storage.conn(ctx).QueryRow(ctx, query, provider, subject)
+	identity := models.Identity{}
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeIdentityNotFound, "Identity not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (storage *PSQLStorage) GetIdentitiesList(ctx context.Context, userID uuid.UUID) ([]*models.Identity, error) {
+	query := "SELECT id, user_id, provider, subject, email, created_at FROM identities WHERE user_id=$1 ORDER BY created_at"
+	rows, err := storage.conn(ctx).Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*models.Identity{}
+	for rows.Next() {
+		var identity models.Identity
+		err = rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+// UpsertIdentity inserts the identity unless the provider account is already linked.
+// It returns the stored identity and whether it has been created.
+func (storage *PSQLStorage) UpsertIdentity(ctx context.Context, identity *models.Identity) (*models.Identity, bool, error) {
+	query := "INSERT INTO identities (id, user_id, provider, subject, email, created_at) VALUES($1,$2,$3,$4,$5,$6) ON CONFLICT (provider, subject) DO NOTHING"
+	tag, err := storage.conn(ctx).Exec(
+		ctx,
+		query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		identity.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return identity, true, nil
+	}
+	existing, err := storage.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (storage *PSQLStorage) UpdateIdentityEmail(ctx context.Context, identityID uuid.UUID, email string) error {
+	query := "UPDATE identities SET email=$2 WHERE id=$1"
+	_, err := storage.conn(ctx).Exec(ctx, query, identityID, email)
+	if err != nil {
+		return err
+	}
+	return nil
+}
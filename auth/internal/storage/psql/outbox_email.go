@@ -0,0 +1,129 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const outboxEmailColumns = "id, reference_id, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, updated_at"
+
+func scanOutboxEmail(row pgx.Row) (*models.OutboxEmail, error) {
+	email := models.OutboxEmail{}
+	err := row.Scan(
+		&email.ID,
+		&email.ReferenceID,
+		&email.Recipient,
+		&email.Subject,
+		&email.TextBody,
+		&email.HTMLBody,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.CreatedAt,
+		&email.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeOutboxEmailNotFound, "OutboxEmail not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &email, nil
+}
+
+func (storage *PSQLStorage) InsertOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	query := "INSERT INTO outbox_emails (" + outboxEmailColumns + ") VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)"
+	_, err := storage.conn(ctx).Exec(
+		ctx,
+		query,
+		email.ID,
+		email.ReferenceID,
+		email.Recipient,
+		email.Subject,
+		email.TextBody,
+		email.HTMLBody,
+		email.Status,
+		email.Attempts,
+		email.NextAttemptAt.UTC(),
+		email.LastError,
+		email.CreatedAt.UTC(),
+		email.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimOutboxEmails returns up to limit pending emails due at now, counting
+// an attempt for each. They are not due again until leaseUntil, so that no
+// other worker picks them up while they are being sent.
+//
+// Times are stored in UTC, as the columns have no time zone and pgx sends
+// the wall clock of the time.
+func (storage *PSQLStorage) ClaimOutboxEmails(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]*models.OutboxEmail, error) {
+	query := "UPDATE outbox_emails SET attempts=attempts+1, next_attempt_at=$1 WHERE id IN (" +
+		"SELECT id FROM outbox_emails WHERE status=$2 AND next_attempt_at<=$3 ORDER BY next_attempt_at LIMIT $4 FOR UPDATE SKIP LOCKED" +
+		") RETURNING " + outboxEmailColumns
+	rows, err := storage.conn(ctx).Query(ctx, query, leaseUntil.UTC(), models.OutboxEmailStatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := []*models.OutboxEmail{}
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// UpdateOutboxEmail stores the outcome of a delivery attempt.
+func (storage *PSQLStorage) UpdateOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	query := "UPDATE outbox_emails SET subject=$2, text_body=$3, html_body=$4, status=$5, next_attempt_at=$6, last_error=$7, updated_at=$8 WHERE id=$1"
+	_, err := storage.conn(ctx).Exec(
+		ctx,
+		query,
+		email.ID,
+		email.Subject,
+		email.TextBody,
+		email.HTMLBody,
+		email.Status,
+		email.NextAttemptAt.UTC(),
+		email.LastError,
+		email.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetOutboxEmailByReference returns the latest email about the referenced object.
+func (storage *PSQLStorage) GetOutboxEmailByReference(ctx context.Context, referenceID uuid.UUID) (*models.OutboxEmail, error) {
+	query := "SELECT " + outboxEmailColumns + " FROM outbox_emails WHERE reference_id=$1 ORDER BY created_at DESC LIMIT 1"
+	return scanOutboxEmail(storage.conn(ctx).QueryRow(ctx, query, referenceID))
+}
+
+// DeleteOutboxEmails deletes sent and dead emails last updated before the
+// given time and returns how many have been deleted.
+func (storage *PSQLStorage) DeleteOutboxEmails(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM outbox_emails WHERE status<>$1 AND updated_at<$2"
+	tag, err := storage.conn(ctx).Exec(ctx, query, models.OutboxEmailStatusPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 
+	"auth/internal/storage/migrator"
+	"auth/migrations"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
-	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
 )
 
 type PSQLStorage struct {
 	*pgxpool.Pool
+	migrator *migrator.Migrator
 }
 
 func New(
@@ -22,34 +26,32 @@ func New(
 	dbName string,
 ) (*PSQLStorage, error) {
 	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", username, password, host, port, dbName)
-	pool, err := pgxpool.New(ctx, dsn)
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
-	storage := PSQLStorage{
-		pool,
+	poolConfig.ConnConfig.Tracer = queryTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
 	}
-	err = storage.Ping(ctx)
+	m, err := migrator.New(database.DialectPostgres, stdlib.OpenDBFromPool(pool), migrations.Postgres)
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
-	err = storage.Migrate(ctx)
+	storage := PSQLStorage{
+		Pool:     pool,
+		migrator: m,
+	}
+	err = storage.Ping(ctx)
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 	return &storage, nil
 }
 
-func (storage *PSQLStorage) Migrate(ctx context.Context) error {
-	sqlDB := stdlib.OpenDB(*storage.Config().ConnConfig)
-	defer sqlDB.Close()
-
-	migrationsDir := "./migrations"
-	// if err := goose.ResetContext(ctx, sqlDB, migrationsDir); err != nil {
-	// 	return err
-	// }
-	if err := goose.UpContext(ctx, sqlDB, migrationsDir); err != nil {
-		return err
-	}
-	return nil
+func (storage *PSQLStorage) Migrations() *migrator.Migrator {
+	return storage.migrator
 }
@@ -14,18 +14,19 @@ import (
 )
 
 func (storage *PSQLStorage) GetSessionsList(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
-	query := "SELECT id, token, user_id, ip, location, client_info, last_login FROM sessions WHERE user_id=$1"
-	rows, err := storage.Query(ctx, query, userID)
+	query := "SELECT id, token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE user_id=$1"
+	rows, err := storage.conn(ctx).Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	sessionsList := []*models.Session{}
 	for rows.Next() {
 		if rows.Err() != nil {
 			return nil, rows.Err()
 		}
 		var session models.Session
-		err = rows.Scan(&session.ID, &session.Token, &session.UserID, &session.IP, &session.Location, &session.ClientInfo, &session.LastLogin)
+		err = rows.Scan(&session.ID, &session.TokenHash, &session.UserID, &session.IP, &session.Location, &session.ClientInfo, &session.LastLogin)
 		if err != nil {
 			return nil, err
 		}
@@ -35,13 +36,27 @@ func (storage *PSQLStorage) GetSessionsList(ctx context.Context, userID uuid.UUI
 }
 
 func (storage *PSQLStorage) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
-	query := "SELECT token, user_id, ip, location, client_info, last_login FROM sessions WHERE id=$1"
-	row := storage.QueryRow(ctx, query, sessionID)
+	query := "SELECT token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE id=$1"
+	row := storage.conn(ctx).QueryRow(ctx, query, sessionID)
 	session := models.Session{ID: sessionID}
-	err := row.Scan(&session.Token, &session.UserID, &session.IP, &session.Location, &session.ClientInfo, &session.LastLogin)
+	err := row.Scan(&session.TokenHash, &session.UserID, &session.IP, &session.Location, &session.ClientInfo, &session.LastLogin)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, httperror.New(err, "Session not found", http.StatusNotFound)
+			return nil, httperror.NewWithCode(err, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (storage *PSQLStorage) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	query := "SELECT id, token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE token_hash=$1"
+	row := storage.conn(ctx).QueryRow(ctx, query, tokenHash)
+	session := models.Session{}
+	err := row.Scan(&session.ID, &session.TokenHash, &session.UserID, &session.IP, &session.Location, &session.ClientInfo, &session.LastLogin)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
 		}
 		return nil, err
 	}
@@ -49,17 +64,17 @@ func (storage *PSQLStorage) GetSession(ctx context.Context, sessionID uuid.UUID)
 }
 
 func (storage *PSQLStorage) InsertSession(ctx context.Context, session models.Session) error {
-	query := "INSERT INTO sessions (id, token, user_id, ip, location, client_info, last_login) VALUES ($1, $2, $3, $4, $5, $6, $7)"
-	_, err := storage.Exec(
+	query := "INSERT INTO sessions (id, token_hash, user_id, ip, location, client_info, last_login) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		session.ID,
-		session.Token,
+		session.TokenHash,
 		session.UserID,
 		session.IP,
 		session.Location,
 		session.ClientInfo,
-		session.LastLogin,
+		session.LastLogin.UTC(),
 	)
 	if err != nil {
 		return err
@@ -71,17 +86,17 @@ func (storage *PSQLStorage) UpdateSession(ctx context.Context, session models.Se
 	if session.ID == uuid.Nil {
 		return fmt.Errorf("session id is required for the update")
 	}
-	query := "UPDATE sessions SET token=$2, user_id=$3, ip=$4, location=$5, client_info=$6, last_login=$7 WHERE id=$1"
-	_, err := storage.Exec(
+	query := "UPDATE sessions SET token_hash=$2, user_id=$3, ip=$4, location=$5, client_info=$6, last_login=$7 WHERE id=$1"
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		session.ID,
-		session.Token,
+		session.TokenHash,
 		session.UserID,
 		session.IP,
 		session.Location,
 		session.ClientInfo,
-		session.LastLogin,
+		session.LastLogin.UTC(),
 	)
 	if err != nil {
 		return err
@@ -91,7 +106,7 @@ func (storage *PSQLStorage) UpdateSession(ctx context.Context, session models.Se
 
 func (storage *PSQLStorage) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
 	query := "DELETE FROM sessions WHERE id=$1"
-	_, err := storage.Exec(
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		sessionID,
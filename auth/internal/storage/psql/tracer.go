@@ -0,0 +1,69 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"auth/internal/metrics"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("auth/internal/storage/psql")
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	operation string
+	startedAt time.Time
+}
+
+// queryTracer records the duration of every query executed through the pool
+// and wraps it into a span.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	operation := queryOperation(data.SQL)
+	ctx, _ = tracer.Start(
+		ctx,
+		"db."+strings.ToLower(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return context.WithValue(ctx, queryStartKey{}, queryStart{
+		operation: operation,
+		startedAt: time.Now(),
+	})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	status := "ok"
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		status = "error"
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	metrics.DBQueryDuration.WithLabelValues(start.operation, status).Observe(time.Since(start.startedAt).Seconds())
+}
+
+func queryOperation(sql string) string {
+	operation, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	return strings.ToUpper(operation)
+}
@@ -14,7 +14,7 @@ import (
 
 func (storage *PSQLStorage) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := "SELECT id, email, created_at, is_super FROM users WHERE email=$1"
-	row := storage.QueryRow(ctx, query, email)
+	row := storage.conn(ctx).QueryRow(ctx, query, email)
 	user := models.User{}
 	err := row.Scan(
 		&user.ID,
@@ -24,7 +24,7 @@ func (storage *PSQLStorage) GetUserByEmail(ctx context.Context, email string) (*
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, httperror.New(err, "User not found", http.StatusNotFound)
+			return nil, httperror.NewWithCode(err, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
 		}
 		return nil, err
 	}
@@ -33,7 +33,7 @@ func (storage *PSQLStorage) GetUserByEmail(ctx context.Context, email string) (*
 
 func (storage *PSQLStorage) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	query := "SELECT id, email, created_at, is_super FROM users WHERE id=$1"
-	row := storage.QueryRow(ctx, query, userID)
+	row := storage.conn(ctx).QueryRow(ctx, query, userID)
 	user := models.User{}
 	err := row.Scan(
 		&user.ID,
@@ -43,7 +43,7 @@ func (storage *PSQLStorage) GetUserByID(ctx context.Context, userID uuid.UUID) (
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, httperror.New(err, "User not found", http.StatusNotFound)
+			return nil, httperror.NewWithCode(err, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
 		}
 		return nil, err
 	}
@@ -52,12 +52,12 @@ func (storage *PSQLStorage) GetUserByID(ctx context.Context, userID uuid.UUID) (
 
 func (storage *PSQLStorage) InsertUser(ctx context.Context, user *models.User) error {
 	query := "INSERT INTO users (id, email, created_at, is_super) VALUES($1,$2,$3,$4)"
-	_, err := storage.Exec(
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		user.ID,
 		user.Email,
-		user.CreatedAt,
+		user.CreatedAt.UTC(),
 		user.IsSuper,
 	)
 	if err != nil {
@@ -66,9 +66,34 @@ func (storage *PSQLStorage) InsertUser(ctx context.Context, user *models.User) e
 	return nil
 }
 
+// UpsertUser inserts the user unless a user with the same email exists.
+// It returns the stored user and whether it has been created.
+func (storage *PSQLStorage) UpsertUser(ctx context.Context, user *models.User) (*models.User, bool, error) {
+	query := "INSERT INTO users (id, email, created_at, is_super) VALUES($1,$2,$3,$4) ON CONFLICT (email) DO NOTHING"
+	tag, err := storage.conn(ctx).Exec(
+		ctx,
+		query,
+		user.ID,
+		user.Email,
+		user.CreatedAt.UTC(),
+		user.IsSuper,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return user, true, nil
+	}
+	existing, err := storage.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
 func (storage *PSQLStorage) UpdateUserEmail(ctx context.Context, userID uuid.UUID, email string) error {
 	query := "UPDATE users SET email=$2 WHERE id=$1"
-	_, err := storage.Exec(
+	_, err := storage.conn(ctx).Exec(
 		ctx,
 		query,
 		userID,
@@ -0,0 +1,127 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+const deviceCodeColumns = "id, device_code_hash, user_code, client_id, scope, status, user_id, expires_at, last_polled_at, interval_seconds"
+
+func scanDeviceCode(row *sql.Row) (*models.DeviceCode, error) {
+	deviceCode := models.DeviceCode{}
+	err := row.Scan(
+		&deviceCode.ID,
+		&deviceCode.DeviceCodeHash,
+		&deviceCode.UserCode,
+		&deviceCode.ClientID,
+		&deviceCode.Scope,
+		&deviceCode.Status,
+		&deviceCode.UserID,
+		&deviceCode.ExpiresAt,
+		&deviceCode.LastPolledAt,
+		&deviceCode.IntervalSeconds,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &deviceCode, nil
+}
+
+func (storage *SQLiteStorage) GetDeviceCodeByHash(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error) {
+	query := "SELECT " + deviceCodeColumns + " FROM device_codes WHERE device_code_hash=?"
+	return scanDeviceCode(storage.conn(ctx).QueryRowContext(ctx, query, deviceCodeHash))
+}
+
+func (storage *SQLiteStorage) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	query := "SELECT " + deviceCodeColumns + " FROM device_codes WHERE user_code=?"
+	return scanDeviceCode(storage.conn(ctx).QueryRowContext(ctx, query, userCode))
+}
+
+func (storage *SQLiteStorage) InsertDeviceCode(ctx context.Context, deviceCode *models.DeviceCode) error {
+	query := "INSERT INTO device_codes (" + deviceCodeColumns + ") VALUES(?,?,?,?,?,?,?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		deviceCode.ID,
+		deviceCode.DeviceCodeHash,
+		deviceCode.UserCode,
+		deviceCode.ClientID,
+		deviceCode.Scope,
+		deviceCode.Status,
+		deviceCode.UserID,
+		deviceCode.ExpiresAt.UTC(),
+		deviceCode.LastPolledAt.UTC(),
+		deviceCode.IntervalSeconds,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateDeviceCodePolling records a poll of the token endpoint.
+func (storage *SQLiteStorage) UpdateDeviceCodePolling(ctx context.Context, deviceCodeID uuid.UUID, lastPolledAt time.Time, intervalSeconds int) error {
+	query := "UPDATE device_codes SET last_polled_at=?, interval_seconds=? WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(ctx, query, lastPolledAt.UTC(), intervalSeconds, deviceCodeID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DecideDeviceCode approves or denies a pending device code and fails with
+// not found if it has already been decided.
+func (storage *SQLiteStorage) DecideDeviceCode(ctx context.Context, deviceCodeID uuid.UUID, status string, userID uuid.UUID) error {
+	query := "UPDATE device_codes SET status=?, user_id=? WHERE id=? AND status=?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, status, userID, deviceCodeID, models.DeviceCodeStatusPending)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// ConsumeDeviceCode deletes the code and fails with not found if it has already been used.
+func (storage *SQLiteStorage) ConsumeDeviceCode(ctx context.Context, deviceCodeID uuid.UUID) error {
+	query := "DELETE FROM device_codes WHERE id=?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, deviceCodeID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeDeviceCodeNotFound, "DeviceCode not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// DeleteExpiredDeviceCodes deletes the codes expired before the given time
+// and returns how many have been deleted.
+func (storage *SQLiteStorage) DeleteExpiredDeviceCodes(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM device_codes WHERE expires_at<?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *SQLiteStorage) GetEmailCodeByID(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
+	query := "SELECT id, email, code, expires_at, number_of_attempts FROM email_codes WHERE id=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, emailCodeID)
+	emailCode := models.EmailCode{}
+	err := row.Scan(
+		&emailCode.ID,
+		&emailCode.Email,
+		&emailCode.Code,
+		&emailCode.ExpiresAt,
+		&emailCode.NumberOfAttempts,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &emailCode, nil
+}
+
+// IncrementEmailCodeAttempts atomically counts a new check attempt and returns the updated code.
+func (storage *SQLiteStorage) IncrementEmailCodeAttempts(ctx context.Context, emailCodeID uuid.UUID) (*models.EmailCode, error) {
+	query := "UPDATE email_codes SET number_of_attempts=number_of_attempts+1 WHERE id=? RETURNING id, email, code, expires_at, number_of_attempts"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, emailCodeID)
+	emailCode := models.EmailCode{}
+	err := row.Scan(
+		&emailCode.ID,
+		&emailCode.Email,
+		&emailCode.Code,
+		&emailCode.ExpiresAt,
+		&emailCode.NumberOfAttempts,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &emailCode, nil
+}
+
+// ConsumeEmailCode deletes the code and fails with not found if it has already been used.
+func (storage *SQLiteStorage) ConsumeEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
+	query := "DELETE FROM email_codes WHERE id=?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, emailCodeID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeEmailCodeNotFound, "EmailCode not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) DeleteEmailCode(ctx context.Context, emailCodeID uuid.UUID) error {
+	query := "DELETE FROM email_codes WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(ctx, query, emailCodeID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) InsertEmailCode(ctx context.Context, emailCode *models.EmailCode) error {
+	query := "INSERT INTO email_codes (id, email, code, expires_at, number_of_attempts) VALUES(?,?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		emailCode.ID,
+		emailCode.Email,
+		emailCode.Code,
+		emailCode.ExpiresAt.UTC(),
+		emailCode.NumberOfAttempts,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
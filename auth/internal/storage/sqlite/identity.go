@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *SQLiteStorage) GetIdentity(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	query := "SELECT id, user_id, provider, subject, email, created_at FROM identities WHERE provider=? AND subject=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, provider, subject)
+	identity := models.Identity{}
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeIdentityNotFound, "Identity not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (storage *SQLiteStorage) GetIdentitiesList(ctx context.Context, userID uuid.UUID) ([]*models.Identity, error) {
+	query := "SELECT id, user_id, provider, subject, email, created_at FROM identities WHERE user_id=? ORDER BY created_at"
+	rows, err := storage.conn(ctx).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*models.Identity{}
+	for rows.Next() {
+		var identity models.Identity
+		err = rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+// UpsertIdentity inserts the identity unless the provider account is already linked.
+// It returns the stored identity and whether it has been created.
+func (storage *SQLiteStorage) UpsertIdentity(ctx context.Context, identity *models.Identity) (*models.Identity, bool, error) {
+	query := "INSERT INTO identities (id, user_id, provider, subject, email, created_at) VALUES(?,?,?,?,?,?) ON CONFLICT (provider, subject) DO NOTHING"
+	result, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		identity.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if affected == 1 {
+		return identity, true, nil
+	}
+	existing, err := storage.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (storage *SQLiteStorage) UpdateIdentityEmail(ctx context.Context, identityID uuid.UUID, email string) error {
+	query := "UPDATE identities SET email=? WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(ctx, query, email, identityID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+// Redirect URIs can't contain spaces, so they are stored as a space separated list.
+
+func (storage *SQLiteStorage) GetOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	query := "SELECT id, secret_hash, name, redirect_uris, created_at FROM oauth_clients WHERE id=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, clientID)
+	client := models.OAuthClient{}
+	var redirectURIs string
+	err := row.Scan(&client.ID, &client.SecretHash, &client.Name, &redirectURIs, &client.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeClientNotFound, "OAuthClient not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	return &client, nil
+}
+
+func (storage *SQLiteStorage) GetOAuthClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	query := "SELECT id, secret_hash, name, redirect_uris, created_at FROM oauth_clients ORDER BY created_at"
+	rows, err := storage.conn(ctx).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []*models.OAuthClient{}
+	for rows.Next() {
+		var client models.OAuthClient
+		var redirectURIs string
+		err = rows.Scan(&client.ID, &client.SecretHash, &client.Name, &redirectURIs, &client.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		client.RedirectURIs = strings.Fields(redirectURIs)
+		clients = append(clients, &client)
+	}
+	return clients, rows.Err()
+}
+
+func (storage *SQLiteStorage) InsertOAuthClient(ctx context.Context, client *models.OAuthClient) error {
+	query := "INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, created_at) VALUES(?,?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		client.ID,
+		client.SecretHash,
+		client.Name,
+		strings.Join(client.RedirectURIs, " "),
+		client.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	query := "DELETE FROM oauth_clients WHERE id=?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, clientID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return httperror.NewWithCode(nil, httperror.CodeClientNotFound, "OAuthClient not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) InsertAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	query := "INSERT INTO authorization_codes (code_hash, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, expires_at) VALUES(?,?,?,?,?,?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.SessionID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes the code and returns it, so that a code
+// can be exchanged only once even by concurrent requests.
+func (storage *SQLiteStorage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	query := "DELETE FROM authorization_codes WHERE code_hash=? RETURNING code_hash, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, expires_at"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, codeHash)
+	code := models.AuthorizationCode{}
+	err := row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.SessionID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeAuthorizationCodeNotFound, "AuthorizationCode not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (storage *SQLiteStorage) GetConsent(ctx context.Context, userID uuid.UUID, clientID string) (*models.Consent, error) {
+	query := "SELECT user_id, client_id, scope, created_at FROM oauth_consents WHERE user_id=? AND client_id=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, userID, clientID)
+	consent := models.Consent{}
+	err := row.Scan(&consent.UserID, &consent.ClientID, &consent.Scope, &consent.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeConsentNotFound, "Consent not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (storage *SQLiteStorage) UpsertConsent(ctx context.Context, consent *models.Consent) error {
+	query := "INSERT INTO oauth_consents (user_id, client_id, scope, created_at) VALUES(?,?,?,?) ON CONFLICT (user_id, client_id) DO UPDATE SET scope=excluded.scope, created_at=excluded.created_at"
+	_, err := storage.conn(ctx).ExecContext(ctx, query, consent.UserID, consent.ClientID, consent.Scope, consent.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+const outboxEmailColumns = "id, reference_id, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, updated_at"
+
+// scanOutboxEmail scans a *sql.Row or the current row of *sql.Rows.
+func scanOutboxEmail(row interface{ Scan(dest ...any) error }) (*models.OutboxEmail, error) {
+	email := models.OutboxEmail{}
+	err := row.Scan(
+		&email.ID,
+		&email.ReferenceID,
+		&email.Recipient,
+		&email.Subject,
+		&email.TextBody,
+		&email.HTMLBody,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.CreatedAt,
+		&email.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeOutboxEmailNotFound, "OutboxEmail not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &email, nil
+}
+
+func (storage *SQLiteStorage) InsertOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	query := "INSERT INTO outbox_emails (" + outboxEmailColumns + ") VALUES(?,?,?,?,?,?,?,?,?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		email.ID,
+		email.ReferenceID,
+		email.Recipient,
+		email.Subject,
+		email.TextBody,
+		email.HTMLBody,
+		email.Status,
+		email.Attempts,
+		email.NextAttemptAt.UTC(),
+		email.LastError,
+		email.CreatedAt.UTC(),
+		email.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimOutboxEmails returns up to limit pending emails due at now, counting
+// an attempt for each. They are not due again until leaseUntil, so that no
+// other worker picks them up while they are being sent.
+//
+// Times are stored in UTC, as they are compared as text.
+func (storage *SQLiteStorage) ClaimOutboxEmails(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]*models.OutboxEmail, error) {
+	query := "UPDATE outbox_emails SET attempts=attempts+1, next_attempt_at=? WHERE id IN (" +
+		"SELECT id FROM outbox_emails WHERE status=? AND next_attempt_at<=? ORDER BY next_attempt_at LIMIT ?" +
+		") RETURNING " + outboxEmailColumns
+	rows, err := storage.conn(ctx).QueryContext(ctx, query, leaseUntil.UTC(), models.OutboxEmailStatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := []*models.OutboxEmail{}
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// UpdateOutboxEmail stores the outcome of a delivery attempt.
+func (storage *SQLiteStorage) UpdateOutboxEmail(ctx context.Context, email *models.OutboxEmail) error {
+	query := "UPDATE outbox_emails SET subject=?, text_body=?, html_body=?, status=?, next_attempt_at=?, last_error=?, updated_at=? WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		email.Subject,
+		email.TextBody,
+		email.HTMLBody,
+		email.Status,
+		email.NextAttemptAt.UTC(),
+		email.LastError,
+		email.UpdatedAt.UTC(),
+		email.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetOutboxEmailByReference returns the latest email about the referenced object.
+func (storage *SQLiteStorage) GetOutboxEmailByReference(ctx context.Context, referenceID uuid.UUID) (*models.OutboxEmail, error) {
+	query := "SELECT " + outboxEmailColumns + " FROM outbox_emails WHERE reference_id=? ORDER BY created_at DESC LIMIT 1"
+	return scanOutboxEmail(storage.conn(ctx).QueryRowContext(ctx, query, referenceID))
+}
+
+// DeleteOutboxEmails deletes sent and dead emails last updated before the
+// given time and returns how many have been deleted.
+func (storage *SQLiteStorage) DeleteOutboxEmails(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM outbox_emails WHERE status<>? AND updated_at<?"
+	result, err := storage.conn(ctx).ExecContext(ctx, query, models.OutboxEmailStatusPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
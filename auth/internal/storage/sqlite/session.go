@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *SQLiteStorage) GetSessionsList(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	query := "SELECT id, token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE user_id=?"
+	rows, err := storage.conn(ctx).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessionsList := []*models.Session{}
+	for rows.Next() {
+		var session models.Session
+		var ip string
+		err = rows.Scan(&session.ID, &session.TokenHash, &session.UserID, &ip, &session.Location, &session.ClientInfo, &session.LastLogin)
+		if err != nil {
+			return nil, err
+		}
+		session.IP = net.ParseIP(ip)
+		sessionsList = append(sessionsList, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessionsList, nil
+}
+
+func (storage *SQLiteStorage) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	query := "SELECT token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE id=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, sessionID)
+	session := models.Session{ID: sessionID}
+	var ip string
+	err := row.Scan(&session.TokenHash, &session.UserID, &ip, &session.Location, &session.ClientInfo, &session.LastLogin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	session.IP = net.ParseIP(ip)
+	return &session, nil
+}
+
+func (storage *SQLiteStorage) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	query := "SELECT id, token_hash, user_id, ip, location, client_info, last_login FROM sessions WHERE token_hash=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, tokenHash)
+	session := models.Session{}
+	var ip string
+	err := row.Scan(&session.ID, &session.TokenHash, &session.UserID, &ip, &session.Location, &session.ClientInfo, &session.LastLogin)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	session.IP = net.ParseIP(ip)
+	return &session, nil
+}
+
+func (storage *SQLiteStorage) InsertSession(ctx context.Context, session models.Session) error {
+	query := "INSERT INTO sessions (id, token_hash, user_id, ip, location, client_info, last_login) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		session.ID,
+		session.TokenHash,
+		session.UserID,
+		session.IP.String(),
+		session.Location,
+		session.ClientInfo,
+		session.LastLogin.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) UpdateSession(ctx context.Context, session models.Session) error {
+	if session.ID == uuid.Nil {
+		return fmt.Errorf("session id is required for the update")
+	}
+	query := "UPDATE sessions SET token_hash=?, user_id=?, ip=?, location=?, client_info=?, last_login=? WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		session.TokenHash,
+		session.UserID,
+		session.IP.String(),
+		session.Location,
+		session.ClientInfo,
+		session.LastLogin.UTC(),
+		session.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (storage *SQLiteStorage) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	query := "DELETE FROM sessions WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		sessionID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"auth/internal/storage/migrator"
+	"auth/migrations"
+
+	"github.com/pressly/goose/v3/database"
+	_ "modernc.org/sqlite"
+)
+
+type SQLiteStorage struct {
+	*sql.DB
+	migrator *migrator.Migrator
+}
+
+func New(ctx context.Context, path string) (*SQLiteStorage, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_time_format", "sqlite")
+	dsn := fmt.Sprintf("file:%s?%s", path, params.Encode())
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time, serializing access avoids SQLITE_BUSY errors.
+	db.SetMaxOpenConns(1)
+
+	m, err := migrator.New(database.DialectSQLite3, db, migrations.SQLite, migrations.SQLiteGoMigrations...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	storage := SQLiteStorage{
+		DB:       db,
+		migrator: m,
+	}
+	err = storage.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &storage, nil
+}
+
+func (storage *SQLiteStorage) Ping(ctx context.Context) error {
+	return storage.PingContext(ctx)
+}
+
+func (storage *SQLiteStorage) Close() {
+	storage.DB.Close()
+}
+
+func (storage *SQLiteStorage) Migrations() *migrator.Migrator {
+	return storage.migrator
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"auth/internal/models"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+func (storage *SQLiteStorage) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := "SELECT id, email, created_at, is_super FROM users WHERE email=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, email)
+	user := models.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsSuper,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (storage *SQLiteStorage) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	query := "SELECT id, email, created_at, is_super FROM users WHERE id=?"
+	row := storage.conn(ctx).QueryRowContext(ctx, query, userID)
+	user := models.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsSuper,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.NewWithCode(err, httperror.CodeUserNotFound, "User not found", http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (storage *SQLiteStorage) InsertUser(ctx context.Context, user *models.User) error {
+	query := "INSERT INTO users (id, email, created_at, is_super) VALUES(?,?,?,?)"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		user.ID,
+		user.Email,
+		user.CreatedAt.UTC(),
+		user.IsSuper,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpsertUser inserts the user unless a user with the same email exists.
+// It returns the stored user and whether it has been created.
+func (storage *SQLiteStorage) UpsertUser(ctx context.Context, user *models.User) (*models.User, bool, error) {
+	query := "INSERT INTO users (id, email, created_at, is_super) VALUES(?,?,?,?) ON CONFLICT (email) DO NOTHING"
+	result, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		user.ID,
+		user.Email,
+		user.CreatedAt.UTC(),
+		user.IsSuper,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if affected == 1 {
+		return user, true, nil
+	}
+	existing, err := storage.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (storage *SQLiteStorage) UpdateUserEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	query := "UPDATE users SET email=? WHERE id=?"
+	_, err := storage.conn(ctx).ExecContext(
+		ctx,
+		query,
+		email,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
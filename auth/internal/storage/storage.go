@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+
+	"auth/internal/services"
+	"auth/internal/storage/migrator"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// Storage is implemented by every storage backend the service can run on.
+type Storage interface {
+	services.AuthStore
+	services.ISessionStore
+	services.IDeviceCodeStore
+	services.IOIDCStore
+	services.IIdentityStore
+	services.IOutboxStore
+
+	Ping(ctx context.Context) error
+	Close()
+}
+
+// Migratable is implemented by storage backends with a versioned schema.
+type Migratable interface {
+	Migrations() *migrator.Migrator
+}
@@ -0,0 +1,943 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/models"
+	"auth/internal/storage"
+	"auth/internal/storage/storagetest"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+// TestConformance checks that every storage backend behaves the same, the
+// services rely on this behaviour whichever backend they run on.
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store storage.Storage)
+	}{
+		{"UpsertUser creates a user once per email", testUpsertUser},
+		{"UpsertUser creates a single user when called concurrently", testUpsertUserConcurrently},
+		{"IncrementEmailCodeAttempts counts every concurrent attempt", testIncrementEmailCodeAttempts},
+		{"ConsumeEmailCode consumes a code once", testConsumeEmailCode},
+		{"UpsertIdentity creates an identity once per subject", testUpsertIdentity},
+		{"GetUserByID and GetUserByEmail find stored users only", testGetUser},
+		{"DeleteEmailCode deletes the code", testDeleteEmailCode},
+		{"GetSessionByTokenHash finds the current token only", testSessionTokenHash},
+		{"Sessions are listed and deleted per user", testSessions},
+		{"OAuth clients are stored, listed and deleted with their codes and consents", testOAuthClients},
+		{"ConsumeAuthorizationCode consumes a code once", testConsumeAuthorizationCode},
+		{"DeleteSession deletes the authorization codes of the session", testDeleteSessionCodes},
+		{"UpsertConsent replaces the consent", testUpsertConsent},
+		{"GetIdentity finds an identity by provider and subject", testGetIdentity},
+		{"WithinTx rolls back when fn fails", testTxRollback},
+		{"WithinTx commits when fn succeeds", testTxCommit},
+		{"ClaimOutboxEmails leases due pending emails", testClaimOutboxEmails(time.UTC)},
+		{"ClaimOutboxEmails compares times of other zones as instants", testClaimOutboxEmails(time.FixedZone("UTC-3", -3*60*60))},
+		{"ClaimOutboxEmails returns the most overdue emails first", testClaimOutboxEmailsOrder},
+		{"ConsumeDeviceCode is rolled back with the transaction", testConsumeDeviceCodeRollback},
+		{"DeleteExpiredDeviceCodes deletes the expired codes only", testDeleteExpiredDeviceCodes},
+		{"DecideDeviceCode decides a pending code once", testDecideDeviceCode},
+		{"DeleteOutboxEmails deletes the handled emails only", testDeleteOutboxEmails},
+		{"Ping succeeds", testPing},
+	}
+	for _, backend := range storagetest.Backends() {
+		t.Run(backend.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, backend.Open(t))
+				})
+			}
+		})
+	}
+}
+
+// now is the time stored rows are about, truncated so that it survives the
+// precision of every backend.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// otherZone is the zone of the times which the backends must store as
+// instants, whatever the zone of the process.
+var otherZone = time.FixedZone("UTC+5", 5*60*60)
+
+// expectNotFound fails the test unless err is the not found error of the
+// given code, which every backend returns alike.
+func expectNotFound(t *testing.T, call string, err error, code string) {
+	t.Helper()
+	if !httperror.IsNotFound(err) || httperror.GetCode(err) != code {
+		t.Errorf("%s returned %v, want not found with the code %s", call, err, code)
+	}
+}
+
+func newUser(t *testing.T, store storage.Storage) *models.User {
+	t.Helper()
+	user, _, err := store.UpsertUser(context.Background(), &models.User{
+		ID:        uuid.New(),
+		Email:     uuid.NewString() + "@example.com",
+		CreatedAt: now(),
+	})
+	if err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	return user
+}
+
+func testUpsertUser(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	email := uuid.NewString() + "@example.com"
+
+	first, created, err := store.UpsertUser(ctx, &models.User{ID: uuid.New(), Email: email, CreatedAt: now()})
+	if err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	if !created {
+		t.Fatal("UpsertUser has not created a new user")
+	}
+	second, created, err := store.UpsertUser(ctx, &models.User{ID: uuid.New(), Email: email, CreatedAt: now()})
+	if err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	if created {
+		t.Error("UpsertUser has created a second user with the same email")
+	}
+	if second.ID != first.ID {
+		t.Errorf("UpsertUser returned user %s, want the existing user %s", second.ID, first.ID)
+	}
+	stored, err := store.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if stored.ID != first.ID {
+		t.Errorf("GetUserByEmail returned user %s, want %s", stored.ID, first.ID)
+	}
+}
+
+// concurrently runs fn n times in parallel and waits for the calls to return.
+func concurrently(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+func testUpsertUserConcurrently(t *testing.T, store storage.Storage) {
+	const n = 8
+	email := uuid.NewString() + "@example.com"
+	var (
+		mu      sync.Mutex
+		created int
+		ids     = map[uuid.UUID]bool{}
+	)
+	concurrently(n, func(int) {
+		user, isNew, err := store.UpsertUser(context.Background(), &models.User{ID: uuid.New(), Email: email, CreatedAt: now()})
+		if err != nil {
+			t.Errorf("UpsertUser: %v", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ids[user.ID] = true
+		if isNew {
+			created++
+		}
+	})
+	if created != 1 || len(ids) != 1 {
+		t.Errorf("%d concurrent UpsertUser created %d users and returned %d, want a single one", n, created, len(ids))
+	}
+}
+
+func insertEmailCode(t *testing.T, store storage.Storage) *models.EmailCode {
+	t.Helper()
+	emailCode := &models.EmailCode{
+		ID:        uuid.New(),
+		Email:     uuid.NewString() + "@example.com",
+		Code:      1234,
+		ExpiresAt: now().Add(time.Minute),
+	}
+	if err := store.InsertEmailCode(context.Background(), emailCode); err != nil {
+		t.Fatalf("InsertEmailCode: %v", err)
+	}
+	return emailCode
+}
+
+func testIncrementEmailCodeAttempts(t *testing.T, store storage.Storage) {
+	const n = 10
+	ctx := context.Background()
+	emailCode := insertEmailCode(t, store)
+
+	var (
+		mu       sync.Mutex
+		attempts = map[uint8]bool{}
+	)
+	concurrently(n, func(int) {
+		incremented, err := store.IncrementEmailCodeAttempts(ctx, emailCode.ID)
+		if err != nil {
+			t.Errorf("IncrementEmailCodeAttempts: %v", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[incremented.NumberOfAttempts] = true
+	})
+	// Every call sees its own attempt, none is lost.
+	for i := uint8(1); i <= n; i++ {
+		if !attempts[i] {
+			t.Errorf("no concurrent IncrementEmailCodeAttempts returned attempt %d: %v", i, attempts)
+		}
+	}
+	stored, err := store.GetEmailCodeByID(ctx, emailCode.ID)
+	if err != nil {
+		t.Fatalf("GetEmailCodeByID: %v", err)
+	}
+	if stored.NumberOfAttempts != n || stored.Code != emailCode.Code || stored.Email != emailCode.Email || !stored.ExpiresAt.Equal(emailCode.ExpiresAt) {
+		t.Errorf("GetEmailCodeByID returned %+v, want %+v with %d attempts", stored, emailCode, n)
+	}
+	if _, err = store.IncrementEmailCodeAttempts(ctx, uuid.New()); !httperror.IsNotFound(err) {
+		t.Errorf("IncrementEmailCodeAttempts of an unknown code returned %v, want not found", err)
+	}
+}
+
+func testConsumeEmailCode(t *testing.T, store storage.Storage) {
+	const n = 5
+	ctx := context.Background()
+	emailCode := insertEmailCode(t, store)
+
+	var (
+		mu       sync.Mutex
+		consumed int
+	)
+	concurrently(n, func(int) {
+		err := store.ConsumeEmailCode(ctx, emailCode.ID)
+		if err != nil && !httperror.IsNotFound(err) {
+			t.Errorf("ConsumeEmailCode: %v", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			consumed++
+		}
+	})
+	if consumed != 1 {
+		t.Errorf("%d concurrent ConsumeEmailCode consumed the code %d times, want once", n, consumed)
+	}
+	if _, err := store.GetEmailCodeByID(ctx, emailCode.ID); !httperror.IsNotFound(err) {
+		t.Errorf("GetEmailCodeByID of a consumed code returned %v, want not found", err)
+	}
+}
+
+func testGetUser(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := &models.User{ID: uuid.New(), Email: uuid.NewString() + "@example.com", CreatedAt: now().In(otherZone)}
+	if _, _, err := store.UpsertUser(ctx, user); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+
+	byID, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	byEmail, err := store.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	for _, found := range []*models.User{byID, byEmail} {
+		if found.ID != user.ID || found.Email != user.Email || !found.CreatedAt.Equal(user.CreatedAt) || found.IsSuper {
+			t.Errorf("the stored user is %+v, want %+v", found, user)
+		}
+	}
+
+	_, err = store.GetUserByID(ctx, uuid.New())
+	expectNotFound(t, "GetUserByID of an unknown user", err, httperror.CodeUserNotFound)
+	_, err = store.GetUserByEmail(ctx, "unknown@example.com")
+	expectNotFound(t, "GetUserByEmail of an unknown email", err, httperror.CodeUserNotFound)
+}
+
+func testDeleteEmailCode(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	emailCode := insertEmailCode(t, store)
+	other := insertEmailCode(t, store)
+
+	if err := store.DeleteEmailCode(ctx, emailCode.ID); err != nil {
+		t.Fatalf("DeleteEmailCode: %v", err)
+	}
+	_, err := store.GetEmailCodeByID(ctx, emailCode.ID)
+	expectNotFound(t, "GetEmailCodeByID of a deleted code", err, httperror.CodeEmailCodeNotFound)
+	if _, err = store.GetEmailCodeByID(ctx, other.ID); err != nil {
+		t.Errorf("another code has been deleted: %v", err)
+	}
+	// Deleting a code used or deleted concurrently is not an error.
+	if err = store.DeleteEmailCode(ctx, emailCode.ID); err != nil {
+		t.Errorf("DeleteEmailCode of a deleted code: %v", err)
+	}
+	err = store.ConsumeEmailCode(ctx, emailCode.ID)
+	expectNotFound(t, "ConsumeEmailCode of a deleted code", err, httperror.CodeEmailCodeNotFound)
+}
+
+func testUpsertIdentity(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := newUser(t, store)
+	identity := &models.Identity{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Provider:  "stub",
+		Subject:   uuid.NewString(),
+		Email:     user.Email,
+		CreatedAt: now(),
+	}
+
+	first, created, err := store.UpsertIdentity(ctx, identity)
+	if err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if !created {
+		t.Fatal("UpsertIdentity has not created a new identity")
+	}
+	again := *identity
+	again.ID = uuid.New()
+	second, created, err := store.UpsertIdentity(ctx, &again)
+	if err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if created {
+		t.Error("UpsertIdentity has created a second identity with the same subject")
+	}
+	if second.ID != first.ID {
+		t.Errorf("UpsertIdentity returned identity %s, want the existing identity %s", second.ID, first.ID)
+	}
+	identities, err := store.GetIdentitiesList(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetIdentitiesList: %v", err)
+	}
+	if len(identities) != 1 {
+		t.Errorf("GetIdentitiesList returned %d identities, want 1", len(identities))
+	}
+}
+
+func testGetIdentity(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := newUser(t, store)
+	identity := &models.Identity{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Provider:  "stub",
+		Subject:   uuid.NewString(),
+		Email:     user.Email,
+		CreatedAt: now().In(otherZone),
+	}
+	if _, _, err := store.UpsertIdentity(ctx, identity); err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if err := store.UpdateIdentityEmail(ctx, identity.ID, "new@example.com"); err != nil {
+		t.Fatalf("UpdateIdentityEmail: %v", err)
+	}
+
+	found, err := store.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		t.Fatalf("GetIdentity: %v", err)
+	}
+	if found.ID != identity.ID || found.UserID != user.ID || found.Email != "new@example.com" || !found.CreatedAt.Equal(identity.CreatedAt) {
+		t.Errorf("GetIdentity returned %+v, want %+v with the new email", found, identity)
+	}
+	_, err = store.GetIdentity(ctx, "other", identity.Subject)
+	expectNotFound(t, "GetIdentity of another provider", err, httperror.CodeIdentityNotFound)
+	if identities, err := store.GetIdentitiesList(ctx, uuid.New()); err != nil || identities == nil || len(identities) != 0 {
+		t.Errorf("GetIdentitiesList of a user without identities returned %v, %v, want an empty list", identities, err)
+	}
+}
+
+func testSessionTokenHash(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := newUser(t, store)
+	session := models.Session{
+		ID:         uuid.New(),
+		TokenHash:  "hash-" + uuid.NewString(),
+		UserID:     user.ID,
+		IP:         net.ParseIP("192.0.2.1"),
+		Location:   "Unknown Location",
+		ClientInfo: "test",
+		LastLogin:  now(),
+	}
+	if err := store.InsertSession(ctx, session); err != nil {
+		t.Fatalf("InsertSession: %v", err)
+	}
+
+	found, err := store.GetSessionByTokenHash(ctx, session.TokenHash)
+	if err != nil {
+		t.Fatalf("GetSessionByTokenHash: %v", err)
+	}
+	if found.ID != session.ID || found.UserID != user.ID || !found.IP.Equal(session.IP) || !found.LastLogin.Equal(session.LastLogin) {
+		t.Errorf("GetSessionByTokenHash returned %+v, want %+v", found, session)
+	}
+
+	oldHash := session.TokenHash
+	session.TokenHash = "hash-" + uuid.NewString()
+	if err := store.UpdateSession(ctx, session); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if _, err := store.GetSessionByTokenHash(ctx, oldHash); !httperror.IsNotFound(err) {
+		t.Errorf("GetSessionByTokenHash of the replaced hash returned %v, want not found", err)
+	}
+	if found, err := store.GetSessionByTokenHash(ctx, session.TokenHash); err != nil || found.ID != session.ID {
+		t.Errorf("GetSessionByTokenHash of the new hash returned %v, %v", found, err)
+	}
+	if _, err := store.GetSessionByTokenHash(ctx, "hash-unknown"); !httperror.IsNotFound(err) {
+		t.Errorf("GetSessionByTokenHash of an unknown hash returned %v, want not found", err)
+	}
+}
+
+func insertSession(t *testing.T, store storage.Storage, userID uuid.UUID) models.Session {
+	t.Helper()
+	session := models.Session{
+		ID:         uuid.New(),
+		TokenHash:  "hash-" + uuid.NewString(),
+		UserID:     userID,
+		IP:         net.ParseIP("2001:db8::1"),
+		Location:   "Unknown Location",
+		ClientInfo: "test",
+		LastLogin:  now().In(otherZone),
+	}
+	if err := store.InsertSession(context.Background(), session); err != nil {
+		t.Fatalf("InsertSession: %v", err)
+	}
+	return session
+}
+
+func testSessions(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := newUser(t, store)
+	first := insertSession(t, store, user.ID)
+	second := insertSession(t, store, user.ID)
+	other := insertSession(t, store, newUser(t, store).ID)
+
+	found, err := store.GetSession(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if found.ID != first.ID || found.TokenHash != first.TokenHash || found.UserID != user.ID || !found.IP.Equal(first.IP) ||
+		found.Location != first.Location || found.ClientInfo != first.ClientInfo || !found.LastLogin.Equal(first.LastLogin) {
+		t.Errorf("GetSession returned %+v, want %+v", found, first)
+	}
+
+	sessions, err := store.GetSessionsList(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetSessionsList: %v", err)
+	}
+	ids := []uuid.UUID{}
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	if len(ids) != 2 || !containsAll(ids, first.ID, second.ID) {
+		t.Errorf("GetSessionsList returned %v, want the sessions of the user %s and %s", ids, first.ID, second.ID)
+	}
+	if sessions, err := store.GetSessionsList(ctx, uuid.New()); err != nil || sessions == nil || len(sessions) != 0 {
+		t.Errorf("GetSessionsList of a user without sessions returned %v, %v, want an empty list", sessions, err)
+	}
+
+	if err = store.DeleteSession(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	_, err = store.GetSession(ctx, first.ID)
+	expectNotFound(t, "GetSession of a deleted session", err, httperror.CodeSessionNotFound)
+	_, err = store.GetSessionByTokenHash(ctx, first.TokenHash)
+	expectNotFound(t, "GetSessionByTokenHash of a deleted session", err, httperror.CodeSessionNotFound)
+	if _, err = store.GetSession(ctx, other.ID); err != nil {
+		t.Errorf("the session of another user has been deleted: %v", err)
+	}
+	// Logging out twice is not an error.
+	if err = store.DeleteSession(ctx, first.ID); err != nil {
+		t.Errorf("DeleteSession of a deleted session: %v", err)
+	}
+	if sessions, err = store.GetSessionsList(ctx, user.ID); err != nil || len(sessions) != 1 || sessions[0].ID != second.ID {
+		t.Errorf("GetSessionsList after the deletion returned %v, %v, want %s only", sessions, err, second.ID)
+	}
+}
+
+func insertOAuthClient(t *testing.T, store storage.Storage, createdAt time.Time) *models.OAuthClient {
+	t.Helper()
+	client := &models.OAuthClient{
+		ID:           uuid.NewString(),
+		SecretHash:   "secret-hash",
+		Name:         "Client",
+		RedirectURIs: []string{"https://client.example.com/callback", "http://127.0.0.1:8080/callback"},
+		CreatedAt:    createdAt.In(otherZone),
+	}
+	if err := store.InsertOAuthClient(context.Background(), client); err != nil {
+		t.Fatalf("InsertOAuthClient: %v", err)
+	}
+	return client
+}
+
+func insertAuthorizationCode(t *testing.T, store storage.Storage, clientID string, session models.Session) *models.AuthorizationCode {
+	t.Helper()
+	code := &models.AuthorizationCode{
+		CodeHash:      uuid.NewString(),
+		ClientID:      clientID,
+		UserID:        session.UserID,
+		SessionID:     session.ID,
+		RedirectURI:   "https://client.example.com/callback",
+		Scope:         "email openid",
+		Nonce:         "nonce",
+		CodeChallenge: "challenge",
+		ExpiresAt:     now().Add(time.Minute).In(otherZone),
+	}
+	if err := store.InsertAuthorizationCode(context.Background(), code); err != nil {
+		t.Fatalf("InsertAuthorizationCode: %v", err)
+	}
+	return code
+}
+
+func testOAuthClients(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	at := now()
+	newer := insertOAuthClient(t, store, at)
+	older := insertOAuthClient(t, store, at.Add(-time.Hour))
+	public := &models.OAuthClient{ID: uuid.NewString(), Name: "Public", RedirectURIs: []string{"http://127.0.0.1/callback"}, CreatedAt: at.Add(time.Hour)}
+	if err := store.InsertOAuthClient(ctx, public); err != nil {
+		t.Fatalf("InsertOAuthClient: %v", err)
+	}
+
+	found, err := store.GetOAuthClient(ctx, newer.ID)
+	if err != nil {
+		t.Fatalf("GetOAuthClient: %v", err)
+	}
+	if found.ID != newer.ID || found.SecretHash != newer.SecretHash || found.Name != newer.Name ||
+		!slices.Equal(found.RedirectURIs, newer.RedirectURIs) || !found.CreatedAt.Equal(newer.CreatedAt) {
+		t.Errorf("GetOAuthClient returned %+v, want %+v", found, newer)
+	}
+	if found, err := store.GetOAuthClient(ctx, public.ID); err != nil || !found.IsPublic() {
+		t.Errorf("GetOAuthClient of the public client returned %+v, %v", found, err)
+	}
+
+	clients, err := store.GetOAuthClients(ctx)
+	if err != nil {
+		t.Fatalf("GetOAuthClients: %v", err)
+	}
+	ids := []string{}
+	for _, client := range clients {
+		ids = append(ids, client.ID)
+	}
+	if want := []string{older.ID, newer.ID, public.ID}; !slices.Equal(ids, want) {
+		t.Errorf("GetOAuthClients returned %v, want the clients by creation time %v", ids, want)
+	}
+
+	user := newUser(t, store)
+	code := insertAuthorizationCode(t, store, newer.ID, insertSession(t, store, user.ID))
+	if err = store.UpsertConsent(ctx, &models.Consent{UserID: user.ID, ClientID: newer.ID, Scope: "openid", CreatedAt: at}); err != nil {
+		t.Fatalf("UpsertConsent: %v", err)
+	}
+	if err = store.DeleteOAuthClient(ctx, newer.ID); err != nil {
+		t.Fatalf("DeleteOAuthClient: %v", err)
+	}
+	_, err = store.GetOAuthClient(ctx, newer.ID)
+	expectNotFound(t, "GetOAuthClient of a deleted client", err, httperror.CodeClientNotFound)
+	_, err = store.ConsumeAuthorizationCode(ctx, code.CodeHash)
+	expectNotFound(t, "ConsumeAuthorizationCode of a deleted client", err, httperror.CodeAuthorizationCodeNotFound)
+	_, err = store.GetConsent(ctx, user.ID, newer.ID)
+	expectNotFound(t, "GetConsent of a deleted client", err, httperror.CodeConsentNotFound)
+	err = store.DeleteOAuthClient(ctx, newer.ID)
+	expectNotFound(t, "DeleteOAuthClient of a deleted client", err, httperror.CodeClientNotFound)
+	if clients, err = store.GetOAuthClients(ctx); err != nil || len(clients) != 2 {
+		t.Errorf("GetOAuthClients after the deletion returned %d clients, %v, want 2", len(clients), err)
+	}
+}
+
+func testConsumeAuthorizationCode(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	client := insertOAuthClient(t, store, now())
+	code := insertAuthorizationCode(t, store, client.ID, insertSession(t, store, newUser(t, store).ID))
+
+	var (
+		mu       sync.Mutex
+		consumed []*models.AuthorizationCode
+	)
+	concurrently(5, func(int) {
+		found, err := store.ConsumeAuthorizationCode(ctx, code.CodeHash)
+		if err != nil {
+			expectNotFound(t, "ConsumeAuthorizationCode of a consumed code", err, httperror.CodeAuthorizationCodeNotFound)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		consumed = append(consumed, found)
+	})
+	if len(consumed) != 1 {
+		t.Fatalf("concurrent ConsumeAuthorizationCode consumed the code %d times, want once", len(consumed))
+	}
+	found := consumed[0]
+	if found.CodeHash != code.CodeHash || found.ClientID != code.ClientID || found.UserID != code.UserID ||
+		found.SessionID != code.SessionID || found.RedirectURI != code.RedirectURI || found.Scope != code.Scope ||
+		found.Nonce != code.Nonce || found.CodeChallenge != code.CodeChallenge || !found.ExpiresAt.Equal(code.ExpiresAt) {
+		t.Errorf("ConsumeAuthorizationCode returned %+v, want %+v", found, code)
+	}
+}
+
+func testDeleteSessionCodes(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	client := insertOAuthClient(t, store, now())
+	user := newUser(t, store)
+	session := insertSession(t, store, user.ID)
+	code := insertAuthorizationCode(t, store, client.ID, session)
+	other := insertAuthorizationCode(t, store, client.ID, insertSession(t, store, user.ID))
+
+	if err := store.DeleteSession(ctx, session.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	_, err := store.ConsumeAuthorizationCode(ctx, code.CodeHash)
+	expectNotFound(t, "ConsumeAuthorizationCode of an ended session", err, httperror.CodeAuthorizationCodeNotFound)
+	if _, err = store.ConsumeAuthorizationCode(ctx, other.CodeHash); err != nil {
+		t.Errorf("the code of another session has been deleted: %v", err)
+	}
+}
+
+func testUpsertConsent(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	client := insertOAuthClient(t, store, now())
+	user := newUser(t, store)
+
+	_, err := store.GetConsent(ctx, user.ID, client.ID)
+	expectNotFound(t, "GetConsent before the consent", err, httperror.CodeConsentNotFound)
+
+	first := &models.Consent{UserID: user.ID, ClientID: client.ID, Scope: "openid", CreatedAt: now().In(otherZone)}
+	if err = store.UpsertConsent(ctx, first); err != nil {
+		t.Fatalf("UpsertConsent: %v", err)
+	}
+	second := &models.Consent{UserID: user.ID, ClientID: client.ID, Scope: "email openid", CreatedAt: first.CreatedAt.Add(time.Minute)}
+	if err = store.UpsertConsent(ctx, second); err != nil {
+		t.Fatalf("UpsertConsent of a new scope: %v", err)
+	}
+	found, err := store.GetConsent(ctx, user.ID, client.ID)
+	if err != nil {
+		t.Fatalf("GetConsent: %v", err)
+	}
+	if found.UserID != user.ID || found.ClientID != client.ID || found.Scope != second.Scope || !found.CreatedAt.Equal(second.CreatedAt) {
+		t.Errorf("GetConsent returned %+v, want the latest consent %+v", found, second)
+	}
+	_, err = store.GetConsent(ctx, newUser(t, store).ID, client.ID)
+	expectNotFound(t, "GetConsent of another user", err, httperror.CodeConsentNotFound)
+}
+
+func testTxRollback(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	email := uuid.NewString() + "@example.com"
+	errFailed := errors.New("failed")
+
+	err := store.WithinTx(ctx, func(ctx context.Context) error {
+		if _, _, err := store.UpsertUser(ctx, &models.User{ID: uuid.New(), Email: email, CreatedAt: now()}); err != nil {
+			return err
+		}
+		// Nested calls take part in the same transaction.
+		return store.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := store.GetUserByEmail(ctx, email); err != nil {
+				t.Errorf("GetUserByEmail within the transaction: %v", err)
+			}
+			return errFailed
+		})
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("WithinTx returned %v, want the error of fn", err)
+	}
+	if _, err := store.GetUserByEmail(ctx, email); !httperror.IsNotFound(err) {
+		t.Errorf("GetUserByEmail after the rollback returned %v, want not found", err)
+	}
+}
+
+func testTxCommit(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	email := uuid.NewString() + "@example.com"
+
+	err := store.WithinTx(ctx, func(ctx context.Context) error {
+		_, _, err := store.UpsertUser(ctx, &models.User{ID: uuid.New(), Email: email, CreatedAt: now()})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+	if _, err := store.GetUserByEmail(ctx, email); err != nil {
+		t.Errorf("GetUserByEmail after the commit: %v", err)
+	}
+}
+
+func insertDeviceCode(t *testing.T, store storage.Storage, expiresAt time.Time) *models.DeviceCode {
+	t.Helper()
+	deviceCode := &models.DeviceCode{
+		ID:              uuid.New(),
+		DeviceCodeHash:  uuid.NewString(),
+		UserCode:        uuid.NewString()[:8],
+		ClientID:        "cli",
+		Status:          models.DeviceCodeStatusPending,
+		ExpiresAt:       expiresAt,
+		LastPolledAt:    now(),
+		IntervalSeconds: 5,
+	}
+	if err := store.InsertDeviceCode(context.Background(), deviceCode); err != nil {
+		t.Fatalf("InsertDeviceCode: %v", err)
+	}
+	return deviceCode
+}
+
+func testConsumeDeviceCodeRollback(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	deviceCode := insertDeviceCode(t, store, now().Add(time.Minute))
+	errFailed := errors.New("failed")
+
+	err := store.WithinTx(ctx, func(ctx context.Context) error {
+		if err := store.ConsumeDeviceCode(ctx, deviceCode.ID); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("WithinTx returned %v, want the error of fn", err)
+	}
+	if _, err = store.GetDeviceCodeByHash(ctx, deviceCode.DeviceCodeHash); err != nil {
+		t.Errorf("the device code is gone after the rollback: %v", err)
+	}
+	if err = store.ConsumeDeviceCode(ctx, deviceCode.ID); err != nil {
+		t.Errorf("ConsumeDeviceCode after the rollback: %v", err)
+	}
+	if err = store.ConsumeDeviceCode(ctx, deviceCode.ID); !httperror.IsNotFound(err) {
+		t.Errorf("ConsumeDeviceCode of a consumed code returned %v, want not found", err)
+	}
+}
+
+func testDeleteExpiredDeviceCodes(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	at := now()
+	// The times are not in UTC, the backends must compare them as instants.
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	expired := insertDeviceCode(t, store, at.Add(-time.Minute).In(zone))
+	valid := insertDeviceCode(t, store, at.Add(time.Minute).In(zone))
+
+	deleted, err := store.DeleteExpiredDeviceCodes(ctx, at)
+	if err != nil {
+		t.Fatalf("DeleteExpiredDeviceCodes: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpiredDeviceCodes deleted %d codes, want 1", deleted)
+	}
+	if _, err = store.GetDeviceCodeByHash(ctx, expired.DeviceCodeHash); !httperror.IsNotFound(err) {
+		t.Errorf("GetDeviceCodeByHash of the expired code returned %v, want not found", err)
+	}
+	if _, err = store.GetDeviceCodeByHash(ctx, valid.DeviceCodeHash); err != nil {
+		t.Errorf("the valid code has been deleted: %v", err)
+	}
+
+	polledAt := at.Add(time.Second).In(zone)
+	if err = store.UpdateDeviceCodePolling(ctx, valid.ID, polledAt, 10); err != nil {
+		t.Fatalf("UpdateDeviceCodePolling: %v", err)
+	}
+	polled, err := store.GetDeviceCodeByHash(ctx, valid.DeviceCodeHash)
+	if err != nil {
+		t.Fatalf("GetDeviceCodeByHash: %v", err)
+	}
+	if !polled.LastPolledAt.Equal(polledAt) || !polled.ExpiresAt.Equal(valid.ExpiresAt) || polled.IntervalSeconds != 10 {
+		t.Errorf("the polled code is last polled at %v and expires at %v, want %v and %v",
+			polled.LastPolledAt, polled.ExpiresAt, polledAt, valid.ExpiresAt)
+	}
+}
+
+func testDecideDeviceCode(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	deviceCode := insertDeviceCode(t, store, now().Add(time.Minute))
+	user := newUser(t, store)
+
+	found, err := store.GetDeviceCodeByUserCode(ctx, deviceCode.UserCode)
+	if err != nil {
+		t.Fatalf("GetDeviceCodeByUserCode: %v", err)
+	}
+	if found.ID != deviceCode.ID || found.Status != models.DeviceCodeStatusPending || found.UserID.Valid {
+		t.Errorf("GetDeviceCodeByUserCode returned %+v, want the pending code %+v", found, deviceCode)
+	}
+	if err = store.DecideDeviceCode(ctx, deviceCode.ID, models.DeviceCodeStatusApproved, user.ID); err != nil {
+		t.Fatalf("DecideDeviceCode: %v", err)
+	}
+	found, err = store.GetDeviceCodeByHash(ctx, deviceCode.DeviceCodeHash)
+	if err != nil {
+		t.Fatalf("GetDeviceCodeByHash: %v", err)
+	}
+	if found.Status != models.DeviceCodeStatusApproved || found.UserID != (uuid.NullUUID{UUID: user.ID, Valid: true}) {
+		t.Errorf("the decided code is %s by %v, want approved by %s", found.Status, found.UserID, user.ID)
+	}
+	err = store.DecideDeviceCode(ctx, deviceCode.ID, models.DeviceCodeStatusDenied, user.ID)
+	expectNotFound(t, "DecideDeviceCode of a decided code", err, httperror.CodeDeviceCodeNotFound)
+	_, err = store.GetDeviceCodeByUserCode(ctx, "UNKNOWN")
+	expectNotFound(t, "GetDeviceCodeByUserCode of an unknown code", err, httperror.CodeDeviceCodeNotFound)
+	_, err = store.GetDeviceCodeByHash(ctx, "unknown")
+	expectNotFound(t, "GetDeviceCodeByHash of an unknown code", err, httperror.CodeDeviceCodeNotFound)
+}
+
+func newOutboxEmail(status string, nextAttemptAt time.Time) *models.OutboxEmail {
+	return &models.OutboxEmail{
+		ID:            uuid.New(),
+		ReferenceID:   uuid.NullUUID{UUID: uuid.New(), Valid: true},
+		Recipient:     "user@example.com",
+		Subject:       "Subject",
+		TextBody:      "Text",
+		HTMLBody:      "<p>HTML</p>",
+		Status:        status,
+		NextAttemptAt: nextAttemptAt,
+		CreatedAt:     nextAttemptAt,
+		UpdatedAt:     nextAttemptAt,
+	}
+}
+
+func insertOutboxEmails(t *testing.T, store storage.Storage, emails ...*models.OutboxEmail) {
+	t.Helper()
+	for _, email := range emails {
+		if err := store.InsertOutboxEmail(context.Background(), email); err != nil {
+			t.Fatalf("InsertOutboxEmail: %v", err)
+		}
+	}
+}
+
+func claimedIDs(t *testing.T, store storage.Storage, at time.Time, leaseUntil time.Time, limit int) []uuid.UUID {
+	t.Helper()
+	emails, err := store.ClaimOutboxEmails(context.Background(), at, leaseUntil, limit)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEmails: %v", err)
+	}
+	ids := []uuid.UUID{}
+	for _, email := range emails {
+		ids = append(ids, email.ID)
+	}
+	return ids
+}
+
+// testClaimOutboxEmails stores the emails with times of the zone, and claims
+// them with times in UTC.
+func testClaimOutboxEmails(zone *time.Location) func(t *testing.T, store storage.Storage) {
+	return func(t *testing.T, store storage.Storage) {
+		claimOutboxEmails(t, store, zone)
+	}
+}
+
+func claimOutboxEmails(t *testing.T, store storage.Storage, zone *time.Location) {
+	ctx := context.Background()
+	at := now()
+	due := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(-time.Minute).In(zone))
+	later := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(time.Hour).In(zone))
+	sent := newOutboxEmail(models.OutboxEmailStatusSent, at.Add(-time.Minute).In(zone))
+	dead := newOutboxEmail(models.OutboxEmailStatusDead, at.Add(-time.Minute).In(zone))
+	insertOutboxEmails(t, store, due, later, sent, dead)
+
+	leaseUntil := at.Add(2 * time.Minute)
+	claimed, err := store.ClaimOutboxEmails(ctx, at, leaseUntil, 10)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEmails: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != due.ID {
+		t.Fatalf("ClaimOutboxEmails claimed %d emails, want the due pending one only", len(claimed))
+	}
+	if claimed[0].Attempts != 1 {
+		t.Errorf("claimed email has %d attempts, want 1", claimed[0].Attempts)
+	}
+	if !claimed[0].NextAttemptAt.Equal(leaseUntil) {
+		t.Errorf("claimed email is next due at %v, want the end of the lease %v", claimed[0].NextAttemptAt, leaseUntil)
+	}
+	if claimed[0].Subject != due.Subject || claimed[0].Recipient != due.Recipient {
+		t.Errorf("claimed email is %+v, want %+v", claimed[0], due)
+	}
+
+	if ids := claimedIDs(t, store, at.Add(time.Minute), leaseUntil, 10); len(ids) != 0 {
+		t.Errorf("ClaimOutboxEmails claimed %v during the lease", ids)
+	}
+	ids := claimedIDs(t, store, leaseUntil, leaseUntil.Add(2*time.Minute), 10)
+	if len(ids) != 1 || ids[0] != due.ID {
+		t.Fatalf("ClaimOutboxEmails claimed %v once the lease has expired, want %s", ids, due.ID)
+	}
+	email, err := store.GetOutboxEmailByReference(ctx, due.ReferenceID.UUID)
+	if err != nil {
+		t.Fatalf("GetOutboxEmailByReference: %v", err)
+	}
+	if email.Attempts != 2 {
+		t.Errorf("email claimed twice has %d attempts, want 2", email.Attempts)
+	}
+
+	email.Status = models.OutboxEmailStatusSent
+	email.UpdatedAt = leaseUntil.In(zone)
+	if err := store.UpdateOutboxEmail(ctx, email); err != nil {
+		t.Fatalf("UpdateOutboxEmail: %v", err)
+	}
+	if ids := claimedIDs(t, store, leaseUntil.Add(time.Hour), leaseUntil.Add(2*time.Hour), 10); len(ids) != 1 || ids[0] != later.ID {
+		t.Errorf("ClaimOutboxEmails claimed %v after the email has been sent, want %s only", ids, later.ID)
+	}
+}
+
+func testDeleteOutboxEmails(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	at := now()
+	sent := newOutboxEmail(models.OutboxEmailStatusSent, at.Add(-time.Hour).In(otherZone))
+	dead := newOutboxEmail(models.OutboxEmailStatusDead, at.Add(-time.Hour).In(otherZone))
+	pending := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(-time.Hour).In(otherZone))
+	recent := newOutboxEmail(models.OutboxEmailStatusSent, at.In(otherZone))
+	insertOutboxEmails(t, store, sent, dead, pending, recent)
+
+	deleted, err := store.DeleteOutboxEmails(ctx, at.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("DeleteOutboxEmails: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteOutboxEmails deleted %d emails, want the old sent and dead ones", deleted)
+	}
+	for _, email := range []*models.OutboxEmail{sent, dead} {
+		_, err = store.GetOutboxEmailByReference(ctx, email.ReferenceID.UUID)
+		expectNotFound(t, "GetOutboxEmailByReference of a deleted email", err, httperror.CodeOutboxEmailNotFound)
+	}
+	for _, email := range []*models.OutboxEmail{pending, recent} {
+		if _, err = store.GetOutboxEmailByReference(ctx, email.ReferenceID.UUID); err != nil {
+			t.Errorf("the %s email updated at %v has been deleted: %v", email.Status, email.UpdatedAt, err)
+		}
+	}
+}
+
+func testClaimOutboxEmailsOrder(t *testing.T, store storage.Storage) {
+	at := now()
+	recent := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(-time.Minute))
+	oldest := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(-time.Hour))
+	older := newOutboxEmail(models.OutboxEmailStatusPending, at.Add(-10*time.Minute))
+	insertOutboxEmails(t, store, recent, oldest, older)
+
+	leaseUntil := at.Add(time.Minute)
+	ids := claimedIDs(t, store, at, leaseUntil, 2)
+	if len(ids) != 2 || !containsAll(ids, oldest.ID, older.ID) {
+		t.Errorf("ClaimOutboxEmails claimed %v, want the two most overdue emails %s and %s", ids, oldest.ID, older.ID)
+	}
+	if ids := claimedIDs(t, store, at, leaseUntil, 2); len(ids) != 1 || ids[0] != recent.ID {
+		t.Errorf("ClaimOutboxEmails claimed %v, want the remaining email %s", ids, recent.ID)
+	}
+}
+
+func containsAll(ids []uuid.UUID, want ...uuid.UUID) bool {
+	for _, id := range want {
+		found := false
+		for _, got := range ids {
+			found = found || got == id
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func testPing(t *testing.T, store storage.Storage) {
+	if err := store.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
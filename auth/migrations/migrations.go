@@ -0,0 +1,24 @@
+// Package migrations embeds the SQL migrations so the binary does not depend
+// on the working directory it is started from.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var (
+	Postgres = mustSub("postgres")
+	SQLite   = mustSub("sqlite")
+)
+
+func mustSub(dir string) fs.FS {
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -34,7 +34,7 @@ CREATE TABLE
 -- +goose StatementEnd
 -- +goose Down
 -- +goose StatementBegin
-DROP TABLE refresh_tokens;
+DROP TABLE sessions;
 
 DROP TABLE email_codes;
 
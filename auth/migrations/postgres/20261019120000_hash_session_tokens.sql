@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE sessions RENAME COLUMN token TO token_hash;
+
+ALTER TABLE sessions
+ALTER COLUMN token_hash TYPE CHAR(64) USING encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+-- Refresh tokens can't be restored from their hashes, every session has to log in again.
+DELETE FROM sessions;
+
+ALTER TABLE sessions
+ALTER COLUMN token_hash TYPE VARCHAR(255);
+
+ALTER TABLE sessions RENAME COLUMN token_hash TO token;
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE
+    device_codes (
+        id UUID PRIMARY KEY,
+        device_code_hash CHAR(64) UNIQUE NOT NULL,
+        user_code VARCHAR(16) UNIQUE NOT NULL,
+        client_id VARCHAR(255) NOT NULL,
+        scope VARCHAR(255) NOT NULL,
+        status VARCHAR(16) NOT NULL,
+        user_id UUID,
+        expires_at TIMESTAMP NOT NULL,
+        last_polled_at TIMESTAMP NOT NULL,
+        interval_seconds INTEGER NOT NULL,
+        CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE device_codes;
+
+-- +goose StatementEnd
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE
+    oauth_clients (
+        id VARCHAR(255) PRIMARY KEY,
+        secret_hash VARCHAR(64) NOT NULL,
+        name VARCHAR(255) NOT NULL,
+        redirect_uris TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    );
+
+CREATE TABLE
+    authorization_codes (
+        code_hash CHAR(64) PRIMARY KEY,
+        client_id VARCHAR(255) NOT NULL,
+        user_id UUID NOT NULL,
+        session_id UUID NOT NULL,
+        redirect_uri TEXT NOT NULL,
+        scope VARCHAR(255) NOT NULL,
+        nonce VARCHAR(255) NOT NULL,
+        code_challenge VARCHAR(128) NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        CONSTRAINT fk_client FOREIGN KEY (client_id) REFERENCES oauth_clients (id) ON DELETE CASCADE,
+        CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        CONSTRAINT fk_session FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+    );
+
+CREATE TABLE
+    oauth_consents (
+        user_id UUID NOT NULL,
+        client_id VARCHAR(255) NOT NULL,
+        scope VARCHAR(255) NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        PRIMARY KEY (user_id, client_id),
+        CONSTRAINT fk_client FOREIGN KEY (client_id) REFERENCES oauth_clients (id) ON DELETE CASCADE,
+        CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE oauth_consents;
+
+DROP TABLE authorization_codes;
+
+DROP TABLE oauth_clients;
+
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE
+    outbox_emails (
+        id UUID PRIMARY KEY,
+        reference_id UUID,
+        recipient VARCHAR(255) NOT NULL,
+        subject TEXT NOT NULL,
+        text_body TEXT NOT NULL,
+        html_body TEXT NOT NULL,
+        status VARCHAR(16) NOT NULL,
+        attempts INTEGER NOT NULL,
+        next_attempt_at TIMESTAMP NOT NULL,
+        last_error TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL
+    );
+
+CREATE INDEX status_next_attempt_at_outbox_emails_idx ON outbox_emails (status, next_attempt_at);
+
+CREATE INDEX reference_id_outbox_emails_idx ON outbox_emails (reference_id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE outbox_emails;
+
+-- +goose StatementEnd
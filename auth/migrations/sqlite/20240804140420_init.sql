@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE
+    users (
+        id TEXT PRIMARY KEY,
+        email TEXT UNIQUE NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        is_super BOOLEAN NOT NULL
+    );
+
+CREATE INDEX email_users_idx ON users (email);
+
+CREATE TABLE
+    email_codes (
+        id TEXT PRIMARY KEY,
+        email TEXT NOT NULL,
+        code INTEGER NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        number_of_attempts INTEGER NOT NULL
+    );
+
+CREATE TABLE
+    sessions (
+        id TEXT PRIMARY KEY,
+        token TEXT UNIQUE NOT NULL,
+        user_id TEXT NOT NULL,
+        ip TEXT NOT NULL,
+        location TEXT NOT NULL,
+        client_info TEXT NOT NULL,
+        last_login TIMESTAMP NOT NULL,
+        CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE sessions;
+
+DROP TABLE email_codes;
+
+DROP TABLE users;
+
+-- +goose StatementEnd
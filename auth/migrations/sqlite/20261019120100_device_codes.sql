@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE
+    device_codes (
+        id TEXT PRIMARY KEY,
+        device_code_hash TEXT UNIQUE NOT NULL,
+        user_code TEXT UNIQUE NOT NULL,
+        client_id TEXT NOT NULL,
+        scope TEXT NOT NULL,
+        status TEXT NOT NULL,
+        user_id TEXT,
+        expires_at TIMESTAMP NOT NULL,
+        last_polled_at TIMESTAMP NOT NULL,
+        interval_seconds INTEGER NOT NULL,
+        CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE device_codes;
+
+-- +goose StatementEnd
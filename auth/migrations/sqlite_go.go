@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/pressly/goose/v3"
+)
+
+// SQLiteGoMigrations are the SQLite migrations that can't be expressed in
+// plain SQL because SQLite lacks the required functions.
+var SQLiteGoMigrations = []*goose.Migration{
+	goose.NewGoMigration(
+		20261019120000,
+		&goose.GoFunc{RunTx: hashSessionTokensUp},
+		&goose.GoFunc{RunTx: hashSessionTokensDown},
+	),
+}
+
+func hashSessionTokensUp(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "ALTER TABLE sessions RENAME COLUMN token TO token_hash"); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id, token_hash FROM sessions")
+	if err != nil {
+		return err
+	}
+	hashes := map[string]string{}
+	for rows.Next() {
+		var id, token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return err
+		}
+		sum := sha256.Sum256([]byte(token))
+		hashes[id] = hex.EncodeToString(sum[:])
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for id, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET token_hash=? WHERE id=?", hash, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashSessionTokensDown(ctx context.Context, tx *sql.Tx) error {
+	// Refresh tokens can't be restored from their hashes, every session has to log in again.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sessions"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "ALTER TABLE sessions RENAME COLUMN token_hash TO token")
+	return err
+}
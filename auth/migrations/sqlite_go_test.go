@@ -0,0 +1,64 @@
+package migrations_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"auth/internal/storage/storagetest"
+	"auth/pkg/httperror"
+
+	"github.com/google/uuid"
+)
+
+// initVersion is the migration before the one hashing the session tokens.
+const initVersion = 20240804140420
+
+func TestSQLiteHashSessionTokens(t *testing.T) {
+	ctx := context.Background()
+	store := storagetest.OpenSQLite(t)
+	if _, err := store.Migrations().UpTo(ctx, initVersion); err != nil {
+		t.Fatalf("failed to apply the init migration: %v", err)
+	}
+
+	// Sessions created before the migration keep their plaintext refresh token.
+	userID, sessionID := uuid.New(), uuid.New()
+	token := "refresh-" + uuid.NewString()
+	if _, err := store.ExecContext(ctx, "INSERT INTO users (id, email, created_at, is_super) VALUES (?, ?, ?, ?)",
+		userID, "user@example.com", time.Now().UTC(), false); err != nil {
+		t.Fatalf("failed to insert the user: %v", err)
+	}
+	if _, err := store.ExecContext(ctx, "INSERT INTO sessions (id, token, user_id, ip, location, client_info, last_login) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID, token, userID, "192.0.2.1", "Unknown Location", "test", time.Now().UTC()); err != nil {
+		t.Fatalf("failed to insert the session: %v", err)
+	}
+
+	if _, err := store.Migrations().Up(ctx); err != nil {
+		t.Fatalf("failed to apply the migrations: %v", err)
+	}
+	sum := sha256.Sum256([]byte(token))
+	session, err := store.GetSessionByTokenHash(ctx, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("the session is not found by the hash of its token: %v", err)
+	}
+	if session.ID != sessionID {
+		t.Errorf("GetSessionByTokenHash returned session %s, want %s", session.ID, sessionID)
+	}
+	if _, err := store.GetSessionByTokenHash(ctx, token); !httperror.IsNotFound(err) {
+		t.Errorf("the plaintext token is still stored: %v", err)
+	}
+
+	// Rolling back drops the sessions, the tokens can't be restored from their hashes.
+	if _, err := store.Migrations().DownTo(ctx, initVersion); err != nil {
+		t.Fatalf("failed to roll back the migrations: %v", err)
+	}
+	var count int
+	if err := store.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE token IS NOT NULL").Scan(&count); err != nil {
+		t.Fatalf("failed to count the sessions: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("%d sessions are left after the rollback, want none", count)
+	}
+}
@@ -0,0 +1,22 @@
+// Package openapi embeds the OpenAPI document of the REST API. It is written
+// by hand in YAML and served as JSON.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+// JSON returns the document as JSON.
+func JSON() ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
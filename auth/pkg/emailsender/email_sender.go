@@ -1,35 +1,204 @@
 package emailsender
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// TLS modes of the SMTP connection.
+const (
+	// TLSModeStartTLS upgrades a plain connection, usually on port 587.
+	TLSModeStartTLS = "starttls"
+	// TLSModeImplicit connects with TLS right away, usually on port 465.
+	TLSModeImplicit = "tls"
+	// TLSModeNone sends mail unencrypted, only for local relays.
+	TLSModeNone = "none"
 )
 
 type IEmailSender interface {
-	Send(subject, body string, recipients ...string) error
+	// Send delivers the message, giving up once ctx is done.
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPSettings configures the SMTP server mail is sent through.
+type SMTPSettings struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// From is the sender address, the username is used when it is empty.
+	From     string
+	FromName string
+	TLSMode  string
+	// Timeout bounds connecting and the whole conversation with the server.
+	Timeout time.Duration
 }
 
 type EmailSender struct {
-	smtpHost     string
-	smtpPort     string
-	smtpUsername string
-	smtpPassword string
+	SMTPSettings
+	from *mail.Address
+	// rootCAs verify the certificate of the server, nil uses the system roots.
+	rootCAs *x509.CertPool
 }
 
-func New(smtpHost, smtpPort, smtpUsername, smtpPassword string) *EmailSender {
+func New(settings SMTPSettings) (*EmailSender, error) {
+	switch settings.TLSMode {
+	case TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", settings.TLSMode)
+	}
+	if settings.From == "" {
+		settings.From = settings.Username
+	}
+	from, err := mail.ParseAddress(settings.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", settings.From, err)
+	}
+	from.Name = settings.FromName
 	return &EmailSender{
-		smtpHost:     smtpHost,
-		smtpPort:     smtpPort,
-		smtpUsername: smtpUsername,
-		smtpPassword: smtpPassword,
+		SMTPSettings: settings,
+		from:         from,
+	}, nil
+}
+
+func (s *EmailSender) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes(s.from, time.Now())
+	if err != nil {
+		return err
+	}
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if s.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server does not support authentication")
+		}
+		// PlainAuth refuses to send the password over a connection without TLS.
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err = client.Mail(s.from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range msg.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		if err = client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
 	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// IsPermanent reports whether the message has been rejected for good, e.g.
+// because the mailbox does not exist, so that sending it again is useless.
+// Errors of several providers are permanent if all of them are.
+func IsPermanent(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, err := range errs {
+			if !IsPermanent(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		switch protoErr.Code {
+		case 550, 551, 553:
+			return true
+		}
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			return true
+		}
+	}
+	return false
+}
+
+// Ping checks that the SMTP server accepts connections.
+func (s *EmailSender) Ping(ctx context.Context) error {
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
 }
 
-func (s *EmailSender) Send(subject, body string, recipients ...string) error {
-	auth := smtp.PlainAuth("", s.smtpUsername, s.smtpPassword, s.smtpHost)
-	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", s.smtpUsername, "", subject, body))
-	err := smtp.SendMail(s.smtpHost+":"+s.smtpPort, auth, s.smtpUsername, recipients, msg)
-	return err
+// dial connects to the server and sets up TLS as configured. The connection
+// fails once ctx is done or the timeout has passed, so that a stuck server
+// can't block the caller.
+func (s *EmailSender) dial(ctx context.Context) (*smtp.Client, error) {
+	deadline := time.Now().Add(s.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	addr := net.JoinHostPort(s.Host, s.Port)
+	tlsConfig := &tls.Config{ServerName: s.Host, RootCAs: s.rootCAs, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if s.TLSMode == TLSModeImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
 }
 
 type EmailSenderMock struct {
@@ -39,8 +208,8 @@ func NewMock() *EmailSenderMock {
 	return &EmailSenderMock{}
 }
 
-func (sender *EmailSenderMock) Send(subject, body string, recipients ...string) error {
-	msg := fmt.Sprintf("Subject: %s\nBody: %s\n", subject, body)
-	fmt.Print(msg)
+func (sender *EmailSenderMock) Send(_ context.Context, msg *Message) error {
+	text := fmt.Sprintf("Subject: %s\nBody: %s\n", msg.Subject, msg.Text)
+	fmt.Print(text)
 	return nil
 }
@@ -0,0 +1,348 @@
+package emailsender
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by the test SMTP server.
+type smtpMessage struct {
+	// TLS reports whether the message has been sent over TLS.
+	TLS bool
+	// Auth is the decoded AUTH PLAIN response, empty without authentication.
+	Auth string
+	From string
+	To   []string
+	Data []byte
+}
+
+// smtpServer is an in-process SMTP server which accepts every message, except
+// for the recipients in rejected.
+type smtpServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	// implicitTLS starts TLS right away, otherwise it is offered by STARTTLS.
+	implicitTLS bool
+	rejected    []string
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &smtpServer{listener: listener, tls: tlsConfig, implicitTLS: implicitTLS}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				server.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+	return server
+}
+
+func (s *smtpServer) port() string {
+	return strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *smtpServer) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	isTLS := s.implicitTLS
+	if isTLS {
+		conn = tls.Server(conn, s.tls)
+	}
+	tp := textproto.NewConn(conn)
+	msg := smtpMessage{TLS: isTLS}
+	_ = tp.PrintfLine("220 test ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			extensions := []string{"test", "8BITMIME", "AUTH PLAIN"}
+			if s.tls != nil && !isTLS {
+				extensions = append(extensions, "STARTTLS")
+			}
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				_ = tp.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			tp = textproto.NewConn(tlsConn)
+			isTLS, msg.TLS = true, true
+		case "AUTH":
+			_, response, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				_ = tp.PrintfLine("501 Invalid response")
+				continue
+			}
+			msg.Auth = string(decoded)
+			_ = tp.PrintfLine("235 Authenticated")
+		case "MAIL":
+			path, _, _ := strings.Cut(strings.TrimPrefix(arg, "FROM:"), " ")
+			msg.From = strings.Trim(path, "<>")
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT":
+			recipient := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if slices.Contains(s.rejected, recipient) {
+				_ = tp.PrintfLine("550 No such user")
+				continue
+			}
+			msg.To = append(msg.To, recipient)
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 Go ahead")
+			msg.Data, err = tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 Queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1 and the
+// pool trusting it.
+func newTestCertificate(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test SMTP server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create the certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse the certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+func newTestSender(t *testing.T, port string, tlsMode string, username string, rootCAs *x509.CertPool) *EmailSender {
+	t.Helper()
+	sender, err := New(SMTPSettings{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: username,
+		Password: "secret",
+		From:     "noreply@example.com",
+		FromName: "Atlas",
+		TLSMode:  tlsMode,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sender.rootCAs = rootCAs
+	return sender
+}
+
+func TestSendTLSModes(t *testing.T) {
+	tlsConfig, rootCAs := newTestCertificate(t)
+	tests := []struct {
+		mode        string
+		tls         *tls.Config
+		implicitTLS bool
+		username    string
+	}{
+		{mode: TLSModeStartTLS, tls: tlsConfig, username: "user@example.com"},
+		{mode: TLSModeImplicit, tls: tlsConfig, implicitTLS: true, username: "user@example.com"},
+		{mode: TLSModeNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			server := newSMTPServer(t, tt.tls, tt.implicitTLS)
+			sender := newTestSender(t, server.port(), tt.mode, tt.username, rootCAs)
+			msg := &Message{To: []string{"Alice <alice@example.com>"}, Subject: "Login code", Text: "Your code is 123456"}
+			if err := sender.Send(context.Background(), msg); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if err := sender.Ping(context.Background()); err != nil {
+				t.Errorf("Ping: %v", err)
+			}
+
+			messages := server.received()
+			if len(messages) != 1 {
+				t.Fatalf("the server received %d messages, want 1", len(messages))
+			}
+			got := messages[0]
+			if wantTLS := tt.mode != TLSModeNone; got.TLS != wantTLS {
+				t.Errorf("the message was sent with TLS %v, want %v", got.TLS, wantTLS)
+			}
+			if wantAuth := "\x00user@example.com\x00secret"; tt.username != "" && got.Auth != wantAuth {
+				t.Errorf("AUTH PLAIN response is %q, want %q", got.Auth, wantAuth)
+			}
+			if got.From != "noreply@example.com" {
+				t.Errorf("MAIL FROM is %q, want noreply@example.com", got.From)
+			}
+			if len(got.To) != 1 || got.To[0] != "alice@example.com" {
+				t.Errorf("RCPT TO is %q, want alice@example.com", got.To)
+			}
+			if !bytes.Contains(got.Data, []byte("Your code is 123456")) {
+				t.Errorf("the message data misses the text:\n%s", got.Data)
+			}
+		})
+	}
+}
+
+func TestSendRefusesUntrustedCertificate(t *testing.T) {
+	tlsConfig, _ := newTestCertificate(t)
+	server := newSMTPServer(t, tlsConfig, false)
+	sender := newTestSender(t, server.port(), TLSModeStartTLS, "", nil)
+	err := sender.Send(context.Background(), &Message{To: []string{"alice@example.com"}, Text: "text"})
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Fatalf("Send returned %v, want a certificate verification error", err)
+	}
+	if len(server.received()) != 0 {
+		t.Error("the message was sent over a connection with an untrusted certificate")
+	}
+}
+
+func TestSendRejectedRecipientIsPermanent(t *testing.T) {
+	server := newSMTPServer(t, nil, false)
+	server.rejected = []string{"nobody@example.com"}
+	sender := newTestSender(t, server.port(), TLSModeNone, "", nil)
+	err := sender.Send(context.Background(), &Message{To: []string{"nobody@example.com"}, Text: "text"})
+	if err == nil {
+		t.Fatal("Send to a rejected recipient succeeded")
+	}
+	if !IsPermanent(err) {
+		t.Errorf("IsPermanent(%v) = false, want true", err)
+	}
+}
+
+// TestSendDeadline checks that a server which accepts the connection but
+// never answers can't block the sender beyond its timeout or the context.
+func TestSendDeadline(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var conns sync.WaitGroup
+	conns.Add(1)
+	go func() {
+		defer conns.Done()
+		var accepted []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range accepted {
+					conn.Close()
+				}
+				return
+			}
+			accepted = append(accepted, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		conns.Wait()
+	})
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	msg := &Message{To: []string{"alice@example.com"}, Text: "text"}
+
+	t.Run("timeout", func(t *testing.T) {
+		sender := newTestSender(t, port, TLSModeNone, "", nil)
+		sender.Timeout = 200 * time.Millisecond
+		checkDeadline(t, func() error { return sender.Send(context.Background(), msg) })
+	})
+
+	t.Run("context", func(t *testing.T) {
+		sender := newTestSender(t, port, TLSModeNone, "", nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		checkDeadline(t, func() error { return sender.Send(ctx, msg) })
+	})
+}
+
+func checkDeadline(t *testing.T, send func() error) {
+	t.Helper()
+	start := time.Now()
+	err := send()
+	elapsed := time.Since(start)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Send returned %v, want a timeout", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Send gave up after %s, want about 200ms", elapsed)
+	}
+}
@@ -0,0 +1,113 @@
+package emailsender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// maxErrorBodyLength bounds the part of an error response kept in APIError.
+const maxErrorBodyLength = 512
+
+// HTTPSettings configures an email API the messages are posted to as JSON,
+// the way Mailgun, SendGrid and similar services accept them.
+type HTTPSettings struct {
+	// URL is the endpoint messages are posted to.
+	URL string
+	// APIKey is sent as a bearer token.
+	APIKey   string
+	From     string
+	FromName string
+	Timeout  time.Duration
+}
+
+// HTTPSender posts messages to an email API. The request body is
+//
+//	{"from": "Name <address>", "to": ["address"], "subject": "...", "text": "...", "html": "..."}
+//
+// and any 2xx response means that the message has been accepted.
+type HTTPSender struct {
+	HTTPSettings
+	from   *mail.Address
+	client *http.Client
+}
+
+// APIError is returned when the API rejects a message.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("email API responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+type httpMessage struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html,omitempty"`
+}
+
+func NewHTTP(settings HTTPSettings) (*HTTPSender, error) {
+	if settings.URL == "" {
+		return nil, fmt.Errorf("email API URL is required")
+	}
+	from, err := mail.ParseAddress(settings.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", settings.From, err)
+	}
+	from.Name = settings.FromName
+	return &HTTPSender{
+		HTTPSettings: settings,
+		from:         from,
+		client:       &http.Client{Timeout: settings.Timeout},
+	}, nil
+}
+
+func (s *HTTPSender) Send(ctx context.Context, msg *Message) error {
+	to := make([]string, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		to = append(to, address.Address)
+	}
+	body, err := json.Marshal(httpMessage{
+		From:    s.from.String(),
+		To:      to,
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		HTML:    msg.HTML,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// Drain the body, so that the connection is reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+	return &APIError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(respBody))}
+}
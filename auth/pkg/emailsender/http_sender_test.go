@@ -0,0 +1,148 @@
+package emailsender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHTTPSender(t *testing.T, url string) *HTTPSender {
+	t.Helper()
+	sender, err := NewHTTP(HTTPSettings{
+		URL:      url,
+		APIKey:   "api-key",
+		From:     "noreply@example.com",
+		FromName: "Atlas",
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewHTTP: %v", err)
+	}
+	return sender
+}
+
+var testHTTPMessage = &Message{
+	To:      []string{"Alice <alice@example.com>"},
+	Subject: "Login code",
+	Text:    "Your code is 123456",
+	HTML:    "<p>Your code is <b>123456</b></p>",
+}
+
+func TestHTTPSenderAccepted(t *testing.T) {
+	var got httpMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method is %s, want POST", r.Method)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Content-Type is %q, want application/json", contentType)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer api-key" {
+			t.Errorf("Authorization is %q, want the bearer API key", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"id": "queued"}`))
+	}))
+	defer server.Close()
+
+	if err := newTestHTTPSender(t, server.URL).Send(context.Background(), testHTTPMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	want := httpMessage{
+		From:    `"Atlas" <noreply@example.com>`,
+		To:      []string{"alice@example.com"},
+		Subject: testHTTPMessage.Subject,
+		Text:    testHTTPMessage.Text,
+		HTML:    testHTTPMessage.HTML,
+	}
+	if got.From != want.From || strings.Join(got.To, ",") != strings.Join(want.To, ",") ||
+		got.Subject != want.Subject || got.Text != want.Text || got.HTML != want.HTML {
+		t.Errorf("the API received %+v, want %+v", got, want)
+	}
+}
+
+func TestHTTPSenderRejected(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		permanent  bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusUnprocessableEntity, true},
+		// A wrong key or a rate limit is fixed without changing the message.
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.statusCode), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(" rejected: " + strings.Repeat("x", 2*maxErrorBodyLength)))
+			}))
+			defer server.Close()
+
+			err := newTestHTTPSender(t, server.URL).Send(context.Background(), testHTTPMessage)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Send returned %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.statusCode {
+				t.Errorf("APIError.StatusCode is %d, want %d", apiErr.StatusCode, tt.statusCode)
+			}
+			if !strings.HasPrefix(apiErr.Body, "rejected: ") || len(apiErr.Body) > maxErrorBodyLength {
+				t.Errorf("APIError.Body is not the trimmed, truncated response: %q", apiErr.Body)
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, !tt.permanent, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestHTTPSenderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sender := newTestHTTPSender(t, server.URL)
+	sender.client.Timeout = 100 * time.Millisecond
+	err := sender.Send(context.Background(), testHTTPMessage)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Send returned %v, want a timeout", err)
+	}
+	if IsPermanent(err) {
+		t.Errorf("IsPermanent(%v) = true, want a timeout to be retried", err)
+	}
+}
+
+func TestHTTPSenderInvalidRecipient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("a message with an invalid recipient was posted")
+	}))
+	defer server.Close()
+
+	msg := &Message{To: []string{"not an address"}, Text: "text"}
+	if err := newTestHTTPSender(t, server.URL).Send(context.Background(), msg); err == nil {
+		t.Error("Send to an invalid recipient succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+package emailsender
+
+import (
+	"context"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CapturedMessage is a message kept by a Mailbox.
+type CapturedMessage struct {
+	ID         string    `json:"id"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Text       string    `json:"text"`
+	HTML       string    `json:"html"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Mailbox keeps the latest messages in memory instead of sending them, so
+// that they can be read in development and end-to-end tests.
+type Mailbox struct {
+	mu       sync.RWMutex
+	capacity int
+	lastID   int
+	// messages are ordered from the oldest.
+	messages []CapturedMessage
+}
+
+// NewMailbox creates a mailbox keeping up to capacity messages, the oldest
+// ones are dropped first.
+func NewMailbox(capacity int) *Mailbox {
+	return &Mailbox{capacity: max(capacity, 1)}
+}
+
+func (m *Mailbox) Send(_ context.Context, msg *Message) error {
+	to := make([]string, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		to = append(to, address.Address)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	m.messages = append(m.messages, CapturedMessage{
+		ID:         strconv.Itoa(m.lastID),
+		To:         to,
+		Subject:    msg.Subject,
+		Text:       msg.Text,
+		HTML:       msg.HTML,
+		ReceivedAt: time.Now(),
+	})
+	if len(m.messages) > m.capacity {
+		m.messages = m.messages[len(m.messages)-m.capacity:]
+	}
+	return nil
+}
+
+// List returns the messages sent to the address, or all of them if it is
+// empty, the newest first.
+func (m *Mailbox) List(address string) []CapturedMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	messages := []CapturedMessage{}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if address == "" || m.messages[i].isFor(address) {
+			messages = append(messages, m.messages[i])
+		}
+	}
+	return messages
+}
+
+// Get returns the message with the id.
+func (m *Mailbox) Get(id string) (CapturedMessage, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return CapturedMessage{}, false
+}
+
+// Clear deletes all messages.
+func (m *Mailbox) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+func (msg *CapturedMessage) isFor(address string) bool {
+	for _, to := range msg.To {
+		if strings.EqualFold(to, address) {
+			return true
+		}
+	}
+	return false
+}
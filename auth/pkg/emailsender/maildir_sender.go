@@ -0,0 +1,67 @@
+package emailsender
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// MaildirSender writes messages to a Maildir instead of sending them, e.g. on
+// staging, where they can be read with any mail client or picked up by tests.
+type MaildirSender struct {
+	dir      string
+	hostname string
+	from     *mail.Address
+}
+
+// NewMaildir creates the Maildir layout in dir if it does not exist.
+func NewMaildir(dir string, from string, fromName string) (*MaildirSender, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("maildir directory is required")
+	}
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+	address.Name = fromName
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &MaildirSender{dir: dir, hostname: hostname, from: address}, nil
+}
+
+// Send writes the message to tmp and then moves it to new, so that readers
+// never see a partially written message.
+func (s *MaildirSender) Send(_ context.Context, msg *Message) error {
+	now := time.Now()
+	data, err := msg.Bytes(s.from, now)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := strconv.FormatInt(now.Unix(), 10) + "." + hex.EncodeToString(b) + "." + s.hostname
+	tmpPath := filepath.Join(s.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.dir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
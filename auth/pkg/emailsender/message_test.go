@@ -0,0 +1,176 @@
+package emailsender
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFrom = &mail.Address{Name: "Atlas", Address: "noreply@example.com"}
+
+func parseMessage(t *testing.T, msg *Message) *mail.Message {
+	t.Helper()
+	data, err := msg.Bytes(testFrom, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 998 {
+			t.Errorf("line is longer than the 998 characters allowed: %q", line)
+		}
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("the message can't be parsed: %v\n%s", err, data)
+	}
+	return parsed
+}
+
+// readQuotedPrintable checks that the body is quoted-printable and returns it decoded.
+func readQuotedPrintable(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	if encoding != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding is %q, want quoted-printable", encoding)
+	}
+	encoded, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+	for _, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("quoted-printable line is longer than 76 characters: %q", line)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatalf("invalid quoted-printable body: %v", err)
+	}
+	return string(decoded)
+}
+
+func TestMessageHeaders(t *testing.T) {
+	subject := "Ваш код входа в Atlas — 123456, действителен 10 минут, не сообщайте его никому"
+	parsed := parseMessage(t, &Message{
+		To:      []string{"Alice <alice@example.com>", "bob@example.com"},
+		Subject: subject,
+		Text:    "text",
+	})
+
+	rawSubject := parsed.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?utf-8?q?") {
+		t.Errorf("Subject is not Q-encoded: %q", rawSubject)
+	}
+	// The long subject is split into several encoded-words, one per line.
+	words := strings.Fields(rawSubject)
+	if len(words) < 2 {
+		t.Errorf("the long Subject is a single encoded-word: %q", rawSubject)
+	}
+	for _, word := range words {
+		if len(word) > 75 {
+			t.Errorf("encoded-word is longer than 75 characters: %q", word)
+		}
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	if err != nil {
+		t.Fatalf("Subject can't be decoded: %v", err)
+	}
+	if decoded != subject {
+		t.Errorf("Subject decodes to %q, want %q", decoded, subject)
+	}
+
+	to, err := parsed.Header.AddressList("To")
+	if err != nil {
+		t.Fatalf("invalid To: %v", err)
+	}
+	if len(to) != 2 || to[0].Address != "alice@example.com" || to[1].Address != "bob@example.com" {
+		t.Errorf("To is %v, want alice@example.com and bob@example.com", to)
+	}
+	from, err := parsed.Header.AddressList("From")
+	if err != nil || len(from) != 1 || *from[0] != *testFrom {
+		t.Errorf("From is %v (%v), want %v", from, err, testFrom)
+	}
+	if _, err = parsed.Header.Date(); err != nil {
+		t.Errorf("invalid Date: %v", err)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID is %q, want an id of the sender's domain", id)
+	}
+	if version := parsed.Header.Get("MIME-Version"); version != "1.0" {
+		t.Errorf("MIME-Version is %q, want 1.0", version)
+	}
+}
+
+func TestMessageSubjectInjection(t *testing.T) {
+	parsed := parseMessage(t, &Message{
+		To:      []string{"alice@example.com"},
+		Subject: "Hello\r\nBcc: mallory@example.com",
+		Text:    "text",
+	})
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("the subject injected the header Bcc: %q", bcc)
+	}
+	if subject := parsed.Header.Get("Subject"); subject != "Hello Bcc: mallory@example.com" {
+		t.Errorf("Subject is %q, want the line break replaced by a space", subject)
+	}
+}
+
+func TestMessagePlainText(t *testing.T) {
+	text := "Your code is 123456.\nIt expires in 10 minutes: " + strings.Repeat("très long, ", 20)
+	parsed := parseMessage(t, &Message{To: []string{"alice@example.com"}, Subject: "Login code", Text: text})
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/plain" || params["charset"] != "utf-8" {
+		t.Errorf("Content-Type is %q, want text/plain; charset=utf-8", parsed.Header.Get("Content-Type"))
+	}
+	body := readQuotedPrintable(t, parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body)
+	if want := strings.ReplaceAll(text, "\n", "\r\n"); body != want {
+		t.Errorf("body is %q, want %q", body, want)
+	}
+}
+
+func TestMessageAlternatives(t *testing.T) {
+	text := "Your code is 123456"
+	html := `<p style="font-size: 20px">Your code is <b>123456</b> — don't share it</p>`
+	parsed := parseMessage(t, &Message{To: []string{"alice@example.com"}, Subject: "Login code", Text: text, HTML: html})
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type is %q, want multipart/alternative", parsed.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	// HTML goes last, clients show the last alternative they support.
+	for _, want := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		// NextRawPart keeps the Content-Transfer-Encoding header.
+		part, err := reader.NextRawPart()
+		if err != nil {
+			t.Fatalf("missing the %s part: %v", want.contentType, err)
+		}
+		if contentType := part.Header.Get("Content-Type"); contentType != want.contentType {
+			t.Errorf("part Content-Type is %q, want %q", contentType, want.contentType)
+		}
+		if body := readQuotedPrintable(t, part.Header.Get("Content-Transfer-Encoding"), part); body != want.content {
+			t.Errorf("%s part is %q, want %q", want.contentType, body, want.content)
+		}
+	}
+	if _, err = reader.NextRawPart(); err != io.EOF {
+		t.Errorf("the message has more than two parts: %v", err)
+	}
+}
+
+func TestMessageWithoutRecipients(t *testing.T) {
+	if _, err := (&Message{Subject: "Login code", Text: "text"}).Bytes(testFrom, time.Now()); err == nil {
+		t.Error("Bytes of a message without recipients succeeded")
+	}
+	if _, err := (&Message{To: []string{"not an address"}, Text: "text"}).Bytes(testFrom, time.Now()); err == nil {
+		t.Error("Bytes of a message with an invalid recipient succeeded")
+	}
+}
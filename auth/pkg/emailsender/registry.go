@@ -0,0 +1,163 @@
+package emailsender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the built-in providers.
+const (
+	ProviderSMTP    = "smtp"
+	ProviderHTTP    = "http"
+	ProviderMaildir = "maildir"
+	ProviderStdout  = "stdout"
+	ProviderMailbox = "mailbox"
+)
+
+// Settings holds the settings of all providers, each provider uses its own.
+type Settings struct {
+	SMTP       SMTPSettings
+	HTTP       HTTPSettings
+	MaildirDir string
+	// Mailbox is the mailbox of the "mailbox" provider, which is unavailable
+	// when it is nil.
+	Mailbox *Mailbox
+	// From and FromName are used by the providers without settings of their own.
+	From     string
+	FromName string
+}
+
+// Factory creates a provider from the settings.
+type Factory func(settings Settings) (IEmailSender, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		ProviderSMTP: func(settings Settings) (IEmailSender, error) {
+			return New(settings.SMTP)
+		},
+		ProviderHTTP: func(settings Settings) (IEmailSender, error) {
+			return NewHTTP(settings.HTTP)
+		},
+		ProviderMaildir: func(settings Settings) (IEmailSender, error) {
+			return NewMaildir(settings.MaildirDir, settings.From, settings.FromName)
+		},
+		ProviderStdout: func(_ Settings) (IEmailSender, error) {
+			return NewMock(), nil
+		},
+		ProviderMailbox: func(settings Settings) (IEmailSender, error) {
+			if settings.Mailbox == nil {
+				return nil, errors.New("the mailbox is only available in development")
+			}
+			return settings.Mailbox, nil
+		},
+	}
+)
+
+// Register makes a provider available under the name, replacing the provider
+// registered under it before.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Providers returns the names of the registered providers.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates the named provider.
+func NewProvider(name string, settings Settings) (IEmailSender, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown email provider %q, expected one of %s", name, strings.Join(Providers(), ", "))
+	}
+	sender, err := factory(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init %s email provider: %w", name, err)
+	}
+	return sender, nil
+}
+
+// NewFromNames creates the named providers. Several providers are combined
+// with failover, in the given order.
+func NewFromNames(names []string, settings Settings) (IEmailSender, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no email provider is configured")
+	}
+	failover := &FailoverSender{}
+	for _, name := range names {
+		sender, err := NewProvider(name, settings)
+		if err != nil {
+			return nil, err
+		}
+		failover.Providers = append(failover.Providers, NamedSender{Name: name, Sender: sender})
+	}
+	if len(failover.Providers) == 1 {
+		return failover.Providers[0].Sender, nil
+	}
+	return failover, nil
+}
+
+// NamedSender is a provider of a FailoverSender.
+type NamedSender struct {
+	Name   string
+	Sender IEmailSender
+}
+
+// FailoverSender sends through the first provider and tries the next one
+// whenever a provider fails.
+type FailoverSender struct {
+	Providers []NamedSender
+}
+
+func (s *FailoverSender) Send(ctx context.Context, msg *Message) error {
+	var errs []error
+	for i, provider := range s.Providers {
+		err := provider.Sender.Send(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(s.Providers)-1 {
+			slog.WarnContext(ctx, "email provider failed, trying the next one", "provider", provider.Name, "error", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ping succeeds if any of the providers is reachable. Providers that can't
+// be pinged are assumed to be up.
+func (s *FailoverSender) Ping(ctx context.Context) error {
+	var errs []error
+	for _, provider := range s.Providers {
+		pinger, ok := provider.Sender.(interface{ Ping(context.Context) error })
+		if !ok {
+			return nil
+		}
+		err := pinger.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+	}
+	return errors.Join(errs...)
+}
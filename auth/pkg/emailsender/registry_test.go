@@ -0,0 +1,133 @@
+package emailsender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// senderFunc is a provider which returns the result of the function.
+type senderFunc func(ctx context.Context, msg *Message) error
+
+func (f senderFunc) Send(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// failoverTest records the order the providers of a FailoverSender are tried in.
+type failoverTest struct {
+	calls []string
+}
+
+func (ft *failoverTest) provider(name string, err error) NamedSender {
+	return NamedSender{Name: name, Sender: senderFunc(func(context.Context, *Message) error {
+		ft.calls = append(ft.calls, name)
+		return err
+	})}
+}
+
+func TestFailoverSender(t *testing.T) {
+	permanent := &APIError{StatusCode: http.StatusBadRequest, Body: "invalid recipient"}
+	rejected := &textproto.Error{Code: 550, Msg: "No such user"}
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls []string
+		wantErr   bool
+		permanent bool
+	}{
+		{name: "first succeeds", errs: []error{nil, nil}, wantCalls: []string{"primary"}},
+		{name: "failover", errs: []error{unavailable, nil}, wantCalls: []string{"primary", "secondary"}},
+		{name: "failover on permanent error", errs: []error{permanent, nil, nil}, wantCalls: []string{"primary", "secondary"}},
+		{name: "all retryable", errs: []error{unavailable, unavailable}, wantCalls: []string{"primary", "secondary"}, wantErr: true},
+		{name: "some permanent", errs: []error{permanent, unavailable}, wantCalls: []string{"primary", "secondary"}, wantErr: true},
+		{name: "all permanent", errs: []error{permanent, rejected}, wantCalls: []string{"primary", "secondary"}, wantErr: true, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := &failoverTest{}
+			sender := &FailoverSender{}
+			for i, err := range tt.errs {
+				sender.Providers = append(sender.Providers, ft.provider([]string{"primary", "secondary", "tertiary"}[i], err))
+			}
+			err := sender.Send(context.Background(), &Message{To: []string{"alice@example.com"}})
+			if !slices.Equal(ft.calls, tt.wantCalls) {
+				t.Errorf("the providers were tried in the order %v, want %v", ft.calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send returned %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			for _, name := range tt.wantCalls {
+				if !strings.Contains(err.Error(), name+": ") {
+					t.Errorf("the error %q does not name the provider %s", err, name)
+				}
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, !tt.permanent, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestFailoverSenderStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ft := &failoverTest{}
+	sender := &FailoverSender{Providers: []NamedSender{
+		{Name: "primary", Sender: senderFunc(func(ctx context.Context, _ *Message) error {
+			ft.calls = append(ft.calls, "primary")
+			cancel()
+			return ctx.Err()
+		})},
+		ft.provider("secondary", nil),
+	}}
+	err := sender.Send(ctx, &Message{To: []string{"alice@example.com"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Send returned %v, want context.Canceled", err)
+	}
+	if !slices.Equal(ft.calls, []string{"primary"}) {
+		t.Errorf("the providers tried after the cancellation are %v, want only primary", ft.calls)
+	}
+}
+
+func TestNewFromNames(t *testing.T) {
+	settings := Settings{Mailbox: NewMailbox(10), From: "noreply@example.com"}
+
+	sender, err := NewFromNames([]string{ProviderMailbox}, settings)
+	if err != nil {
+		t.Fatalf("NewFromNames: %v", err)
+	}
+	if sender != settings.Mailbox {
+		t.Errorf("a single provider is wrapped in %T", sender)
+	}
+
+	sender, err = NewFromNames([]string{ProviderMailbox, ProviderStdout}, settings)
+	if err != nil {
+		t.Fatalf("NewFromNames: %v", err)
+	}
+	failover, ok := sender.(*FailoverSender)
+	if !ok {
+		t.Fatalf("several providers are combined in %T, want *FailoverSender", sender)
+	}
+	var names []string
+	for _, provider := range failover.Providers {
+		names = append(names, provider.Name)
+	}
+	if want := []string{ProviderMailbox, ProviderStdout}; !slices.Equal(names, want) {
+		t.Errorf("the providers are %v, want them in the configured order %v", names, want)
+	}
+
+	if _, err = NewFromNames([]string{"carrier-pigeon"}, settings); err == nil {
+		t.Error("NewFromNames with an unknown provider succeeded")
+	}
+	if _, err = NewFromNames(nil, settings); err == nil {
+		t.Error("NewFromNames without providers succeeded")
+	}
+}
@@ -0,0 +1,44 @@
+package httperror
+
+// Error codes are part of the API: unlike messages, they don't change, so
+// that clients can tell errors apart. They are returned as the "code" member
+// of problem details and as the reason of the gRPC error info.
+const (
+	// Generic errors
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeInvalidToken   = "invalid_token"
+	CodeNotFound       = "not_found"
+	CodeInternal       = "internal_error"
+
+	// Login with email codes and sessions
+	CodeInvalidEmail        = "invalid_email"
+	CodeIncorrect           = "code_incorrect"
+	CodeExpired             = "code_expired"
+	CodeAttemptsExceeded    = "code_attempts_exceeded"
+	CodeEmailCodeNotFound   = "email_code_not_found"
+	CodeDeliveryNotFound    = "delivery_not_found"
+	CodeSessionNotFound     = "session_not_found"
+	CodeUserNotFound        = "user_not_found"
+	CodeDeviceCodeNotFound  = "device_code_not_found"
+	CodeOutboxEmailNotFound = "outbox_email_not_found"
+	CodeMessageNotFound     = "message_not_found"
+	CodeInvalidAudience     = "invalid_audience"
+
+	// OAuth2 and OpenID Connect
+	CodeInvalidClient             = "invalid_client"
+	CodeClientNotFound            = "client_not_found"
+	CodeAuthorizationCodeNotFound = "authorization_code_not_found"
+	CodeConsentNotFound           = "consent_not_found"
+
+	// Login with upstream identity providers
+	CodeProviderNotFound      = "provider_not_found"
+	CodeProviderError         = "provider_error"
+	CodeIdentityNotFound      = "identity_not_found"
+	CodeReturnToNotAllowed    = "return_to_not_allowed"
+	CodeLoginStateMismatch    = "login_state_mismatch"
+	CodeLoginExpired          = "login_expired"
+	CodeLoginCancelled        = "login_cancelled"
+	CodeEmailNotVerified      = "email_not_verified"
+	CodeEmailDomainNotAllowed = "email_domain_not_allowed"
+)
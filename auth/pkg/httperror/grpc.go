@@ -0,0 +1,147 @@
+package httperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorInfoDomain is the domain of the gRPC error info, see errdetails.ErrorInfo.
+const errorInfoDomain = "auth"
+
+// StatusClientClosedRequest is the non-standard status of requests the client
+// has given up on, as used by nginx. It stands for codes.Canceled, which is
+// not a failure of the service.
+const StatusClientClosedRequest = 499
+
+// GRPCStatus returns the gRPC status of the error, the code and the details
+// are sent as error info. It is used by the gRPC server to send the error.
+func (e *HTTPError) GRPCStatus() *status.Status {
+	st := status.New(grpcCode(e.statusCode), e.msg)
+	info := &errdetails.ErrorInfo{Reason: e.code, Domain: errorInfoDomain}
+	if len(e.details) > 0 {
+		info.Metadata = make(map[string]string, len(e.details))
+		for key, value := range e.details {
+			info.Metadata[key] = fmt.Sprint(value)
+		}
+	}
+	if withDetails, err := st.WithDetails(info); err == nil {
+		st = withDetails
+	}
+	return st
+}
+
+// ToGRPC returns the error to send to gRPC clients for err. Errors that are
+// neither HTTPErrors nor gRPC statuses are masked as internal errors.
+func ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.GRPCStatus().Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, internalMessage)
+}
+
+// FromGRPC converts an error returned by a gRPC call into an HTTPError, so
+// that it can be passed on to HTTP clients. The code is read from the error
+// info sent by ToGRPC.
+func FromGRPC(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code := ""
+	var details map[string]any
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == errorInfoDomain {
+			code = info.Reason
+			for key, value := range info.Metadata {
+				if details == nil {
+					details = make(map[string]any, len(info.Metadata))
+				}
+				details[key] = value
+			}
+		}
+	}
+	statusCode := httpStatusCode(st.Code())
+	msg := st.Message()
+	switch {
+	case code != "":
+	case statusCode >= http.StatusInternalServerError:
+		// The message of a failure is not meant for clients.
+		code, msg = CodeInternal, internalMessage
+	default:
+		code = codeOfStatus(statusCode)
+	}
+	return &HTTPError{
+		err:        err,
+		code:       code,
+		msg:        msg,
+		statusCode: statusCode,
+		details:    details,
+	}
+}
+
+func grpcCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case StatusClientClosedRequest:
+		return codes.Canceled
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.InvalidArgument
+}
+
+func httpStatusCode(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled:
+		return StatusClientClosedRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -2,13 +2,22 @@ package httperror
 
 import (
 	"errors"
+	"maps"
 	"net/http"
 )
 
+// internalMessage replaces the message of errors that are not HTTPErrors,
+// which may tell about the internals of the service.
+const internalMessage = "Internal server error"
+
+// HTTPError is an error meant to be shown to clients. The message is for
+// humans, the code is a stable identifier clients can rely on, see codes.go.
 type HTTPError struct {
 	err        error
+	code       string
 	msg        string
 	statusCode int
+	details    map[string]any
 }
 
 func (e *HTTPError) Error() string {
@@ -18,21 +27,67 @@ func (e *HTTPError) Unwrap() error {
 	return e.err
 }
 
+// Code returns the stable identifier of the error.
+func (e *HTTPError) Code() string {
+	return e.code
+}
+
+// Details returns the additional information about the error, e.g. the
+// invalid field of a request.
+func (e *HTTPError) Details() map[string]any {
+	return e.details
+}
+
+// New returns an error with the generic code of the status code, see
+// NewWithCode for errors clients need to tell apart.
 func New(err error, msg string, statusCode int) error {
+	return NewWithCode(err, codeOfStatus(statusCode), msg, statusCode)
+}
+
+// NewWithCode returns an error with one of the stable codes of codes.go.
+func NewWithCode(err error, code string, msg string, statusCode int) error {
 	return &HTTPError{
 		err:        err,
+		code:       code,
 		msg:        msg,
 		statusCode: statusCode,
 	}
 }
 
+// WithDetails returns a copy of err with the details added, err is returned
+// as is if it is not an HTTPError.
+func WithDetails(err error, details map[string]any) error {
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		return err
+	}
+	copied := *e
+	copied.details = maps.Clone(e.details)
+	if copied.details == nil {
+		copied.details = make(map[string]any, len(details))
+	}
+	maps.Copy(copied.details, details)
+	return &copied
+}
+
+// GetMessageAndStatusCode returns the message and the status code of err.
+// The message of errors that are not HTTPErrors is masked.
 func GetMessageAndStatusCode(err error) (string, int) {
 	var e *HTTPError
 	if errors.As(err, &e) {
 		return e.msg, e.statusCode
 	} else {
-		return err.Error(), http.StatusInternalServerError
+		return internalMessage, http.StatusInternalServerError
+	}
+}
+
+// GetCode returns the code of err, CodeInternal if it is not an HTTPError.
+func GetCode(err error) string {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.code
 	}
+	return CodeInternal
 }
 
 func IsNotFound(err error) bool {
@@ -44,3 +99,17 @@ func IsNotFound(err error) bool {
 	}
 	return false
 }
+
+// codeOfStatus returns the generic code of errors with the status code.
+func codeOfStatus(statusCode int) string {
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		return CodeInternal
+	case statusCode == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case statusCode == http.StatusNotFound:
+		return CodeNotFound
+	default:
+		return CodeInvalidRequest
+	}
+}
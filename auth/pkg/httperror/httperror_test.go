@@ -0,0 +1,325 @@
+package httperror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"auth/pkg/httperror"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProblem(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want map[string]any
+	}{
+		{
+			name: "with code",
+			err:  httperror.NewWithCode(nil, httperror.CodeExpired, "Code expired", http.StatusGone),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Gone",
+				"status":   float64(http.StatusGone),
+				"detail":   "Code expired",
+				"instance": "/test",
+				"code":     httperror.CodeExpired,
+			},
+		},
+		{
+			name: "with details",
+			err: httperror.WithDetails(
+				httperror.NewWithCode(nil, httperror.CodeIncorrect, "Code incorrect", http.StatusPreconditionFailed),
+				map[string]any{"attempts_left": 2},
+			),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Precondition Failed",
+				"status":   float64(http.StatusPreconditionFailed),
+				"detail":   "Code incorrect",
+				"instance": "/test",
+				"code":     httperror.CodeIncorrect,
+				"details":  map[string]any{"attempts_left": float64(2)},
+			},
+		},
+		{
+			name: "generic code",
+			err:  httperror.New(nil, "Session not found", http.StatusNotFound),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Not Found",
+				"status":   float64(http.StatusNotFound),
+				"detail":   "Session not found",
+				"instance": "/test",
+				"code":     httperror.CodeNotFound,
+			},
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("checking: %w", httperror.NewWithCode(errors.New("cause"), httperror.CodeUnauthorized, "Unauthorized", http.StatusUnauthorized)),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Unauthorized",
+				"status":   float64(http.StatusUnauthorized),
+				"detail":   "Unauthorized",
+				"instance": "/test",
+				"code":     httperror.CodeUnauthorized,
+			},
+		},
+		{
+			name: "internal",
+			err:  errors.New("pq: password authentication failed for user postgres"),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Internal Server Error",
+				"status":   float64(http.StatusInternalServerError),
+				"detail":   "Internal server error",
+				"instance": "/test",
+				"code":     httperror.CodeInternal,
+			},
+		},
+		{
+			name: "client closed request",
+			err:  httperror.FromGRPC(status.Error(codes.Canceled, "context canceled")),
+			want: map[string]any{
+				"type":     "about:blank",
+				"title":    "Client Closed Request",
+				"status":   float64(httperror.StatusClientClosedRequest),
+				"detail":   "context canceled",
+				"instance": "/test",
+				"code":     httperror.CodeInvalidRequest,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(httperror.NewProblem(tt.err, "/test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("the problem is %s, want %v", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantStatus int
+	}{
+		{
+			name:       "bad request",
+			err:        httperror.NewWithCode(nil, httperror.CodeInvalidEmail, "Invalid email", http.StatusBadRequest),
+			wantCode:   codes.InvalidArgument,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unauthorized",
+			err:        httperror.NewWithCode(nil, httperror.CodeInvalidToken, "Invalid token", http.StatusUnauthorized),
+			wantCode:   codes.Unauthenticated,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "forbidden",
+			err:        httperror.NewWithCode(nil, httperror.CodeEmailDomainNotAllowed, "Email domain not allowed", http.StatusForbidden),
+			wantCode:   codes.PermissionDenied,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "not found",
+			err:        httperror.NewWithCode(nil, httperror.CodeSessionNotFound, "Session not found", http.StatusNotFound),
+			wantCode:   codes.NotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			// gRPC has no code for gone resources.
+			name:       "gone",
+			err:        httperror.NewWithCode(nil, httperror.CodeExpired, "Code expired", http.StatusGone),
+			wantCode:   codes.NotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "conflict",
+			err:        httperror.New(nil, "Already exists", http.StatusConflict),
+			wantCode:   codes.AlreadyExists,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "precondition failed with details",
+			err: httperror.WithDetails(
+				httperror.NewWithCode(nil, httperror.CodeIncorrect, "Code incorrect", http.StatusPreconditionFailed),
+				map[string]any{"attempts_left": 2},
+			),
+			wantCode:   codes.FailedPrecondition,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "too many requests",
+			err:        httperror.New(nil, "Too many requests", http.StatusTooManyRequests),
+			wantCode:   codes.ResourceExhausted,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "unavailable",
+			err:        httperror.New(nil, "Unavailable", http.StatusServiceUnavailable),
+			wantCode:   codes.Unavailable,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "timeout",
+			err:        httperror.New(nil, "Timeout", http.StatusGatewayTimeout),
+			wantCode:   codes.DeadlineExceeded,
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "client closed request",
+			err:        httperror.New(nil, "Canceled", httperror.StatusClientClosedRequest),
+			wantCode:   codes.Canceled,
+			wantStatus: httperror.StatusClientClosedRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			grpcErr := httperror.ToGRPC(tt.err)
+			st, ok := status.FromError(grpcErr)
+			if !ok {
+				t.Fatalf("ToGRPC returned %v, want a gRPC status", grpcErr)
+			}
+			if st.Code() != tt.wantCode {
+				t.Errorf("the gRPC code is %s, want %s", st.Code(), tt.wantCode)
+			}
+
+			err := httperror.FromGRPC(grpcErr)
+			var e *httperror.HTTPError
+			if !errors.As(err, &e) {
+				t.Fatalf("FromGRPC returned %v, want an HTTPError", err)
+			}
+			msg, statusCode := httperror.GetMessageAndStatusCode(err)
+			wantMsg, _ := httperror.GetMessageAndStatusCode(tt.err)
+			if msg != wantMsg || statusCode != tt.wantStatus {
+				t.Errorf("the error is %d %q, want %d %q", statusCode, msg, tt.wantStatus, wantMsg)
+			}
+			if e.Code() != httperror.GetCode(tt.err) {
+				t.Errorf("the code is %q, want %q", e.Code(), httperror.GetCode(tt.err))
+			}
+			// The values of the details are sent as strings.
+			var wantDetails map[string]any
+			var original *httperror.HTTPError
+			if errors.As(tt.err, &original) && original.Details() != nil {
+				wantDetails = map[string]any{}
+				for key, value := range original.Details() {
+					wantDetails[key] = fmt.Sprint(value)
+				}
+			}
+			if !maps.Equal(e.Details(), wantDetails) {
+				t.Errorf("the details are %v, want %v", e.Details(), wantDetails)
+			}
+		})
+	}
+}
+
+func TestToGRPC(t *testing.T) {
+	if err := httperror.ToGRPC(nil); err != nil {
+		t.Errorf("ToGRPC(nil) returned %v", err)
+	}
+
+	st := status.Convert(httperror.ToGRPC(errors.New("pq: connection refused")))
+	if st.Code() != codes.Internal || strings.Contains(st.Message(), "pq") {
+		t.Errorf("an internal error is sent as %s %q, want it masked", st.Code(), st.Message())
+	}
+
+	grpcErr := status.Error(codes.Unavailable, "upstream unavailable")
+	if err := httperror.ToGRPC(fmt.Errorf("calling: %w", grpcErr)); status.Code(err) != codes.Unavailable {
+		t.Errorf("a gRPC status is sent as %v, want it kept", err)
+	}
+}
+
+func TestFromGRPC(t *testing.T) {
+	plain := errors.New("not a status")
+	if err := httperror.FromGRPC(plain); err != plain {
+		t.Errorf("FromGRPC of an error without status returned %v, want it as is", err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{
+			name:       "internal without info",
+			err:        status.Error(codes.Internal, "pq: connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   httperror.CodeInternal,
+			wantMsg:    "Internal server error",
+		},
+		{
+			name:       "unknown",
+			err:        status.Error(codes.Unknown, "panic: runtime error"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   httperror.CodeInternal,
+			wantMsg:    "Internal server error",
+		},
+		{
+			name:       "not found without info",
+			err:        status.Error(codes.NotFound, "no such user"),
+			wantStatus: http.StatusNotFound,
+			wantCode:   httperror.CodeNotFound,
+			wantMsg:    "no such user",
+		},
+		{
+			name:       "unauthenticated without info",
+			err:        status.Error(codes.Unauthenticated, "missing token"),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   httperror.CodeUnauthorized,
+			wantMsg:    "missing token",
+		},
+		{
+			name:       "canceled",
+			err:        status.Error(codes.Canceled, "context canceled"),
+			wantStatus: httperror.StatusClientClosedRequest,
+			wantCode:   httperror.CodeInvalidRequest,
+			wantMsg:    "context canceled",
+		},
+		{
+			name:       "info of another domain",
+			err:        withInfo(t, status.New(codes.InvalidArgument, "bad"), &errdetails.ErrorInfo{Reason: "other", Domain: "other"}),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   httperror.CodeInvalidRequest,
+			wantMsg:    "bad",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := httperror.FromGRPC(tt.err)
+			msg, statusCode := httperror.GetMessageAndStatusCode(err)
+			if statusCode != tt.wantStatus || httperror.GetCode(err) != tt.wantCode || msg != tt.wantMsg {
+				t.Errorf("the error is %d %s %q, want %d %s %q", statusCode, httperror.GetCode(err), msg, tt.wantStatus, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func withInfo(t *testing.T, st *status.Status, info *errdetails.ErrorInfo) error {
+	t.Helper()
+	st, err := st.WithDetails(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
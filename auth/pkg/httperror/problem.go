@@ -0,0 +1,51 @@
+package httperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ProblemContentType is the media type of problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of error responses, see RFC 7807. Code and Details are
+// extension members.
+type Problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// NewProblem returns the problem details of err, which occurred handling the
+// request for instance. Errors that are not HTTPErrors are masked as
+// internal errors.
+func NewProblem(err error, instance string) *Problem {
+	msg, statusCode := GetMessageAndStatusCode(err)
+	problem := &Problem{
+		// The problems are identified by their code, they have no
+		// documentation of their own.
+		Type:     "about:blank",
+		Title:    statusText(statusCode),
+		Status:   statusCode,
+		Detail:   msg,
+		Instance: instance,
+		Code:     GetCode(err),
+	}
+	var e *HTTPError
+	if errors.As(err, &e) {
+		problem.Details = e.details
+	}
+	return problem
+}
+
+// statusText is http.StatusText knowing StatusClientClosedRequest.
+func statusText(statusCode int) string {
+	if statusCode == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(statusCode)
+}
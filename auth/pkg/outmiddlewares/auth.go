@@ -4,43 +4,83 @@ import (
 	"net/http"
 	"strings"
 
+	"auth/pkg/httperror"
 	pb "auth/proto"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
-func NewAuthMiddleware(conn *grpc.ClientConn) gin.HandlerFunc {
+// DialOptions returns the options the auth gRPC connection should be created with
+// so that the trace context of the incoming request is propagated to the auth service.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+}
+
+type options struct {
+	audience string
+}
+
+// Option customizes the auth middleware.
+type Option func(*options)
+
+// WithAudience makes the auth service accept only access tokens issued for audience,
+// normally the name of the service using the middleware.
+func WithAudience(audience string) Option {
+	return func(o *options) {
+		o.audience = audience
+	}
+}
+
+func NewAuthMiddleware(conn *grpc.ClientConn, opts ...Option) gin.HandlerFunc {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	client := pb.NewAuthClient(conn)
 	return func(c *gin.Context) {
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
+			abortWithError(c, httperror.NewWithCode(nil, httperror.CodeUnauthorized, "Authorization header is required", http.StatusUnauthorized))
 			return
 		}
 
 		clearToken, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header"})
-			c.Abort()
+			abortWithError(c, httperror.NewWithCode(nil, httperror.CodeUnauthorized, "Invalid Authorization header", http.StatusUnauthorized))
 			return
 		}
 
-		resp, err := client.AuthUser(c.Request.Context(), &pb.AuthUserRequest{Token: clearToken})
+		// The call is traced by the otelgrpc handler of DialOptions.
+		ctx := c.Request.Context()
+		if requestID := c.GetHeader("X-Request-ID"); requestID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", requestID)
+		}
+		resp, err := client.AuthUser(ctx, &pb.AuthUserRequest{Token: clearToken, Audience: o.audience})
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
+			abortWithError(c, httperror.FromGRPC(err))
 			return
 		}
 		userID, err := uuid.Parse(resp.UserId)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			c.Abort()
+			abortWithError(c, err)
 			return
 		}
 		c.Set(gin.AuthUserKey, userID)
 		c.Next()
 	}
 }
+
+// abortWithError writes err as problem details, see httperror.Problem. The
+// errors of the auth service are passed on with their codes, other errors
+// are masked.
+func abortWithError(c *gin.Context, err error) {
+	problem := httperror.NewProblem(err, c.Request.URL.Path)
+	c.Header("Content-Type", httperror.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
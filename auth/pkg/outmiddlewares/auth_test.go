@@ -0,0 +1,173 @@
+package outmiddlewares_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth/internal/tracing"
+	"auth/pkg/httperror"
+	"auth/pkg/outmiddlewares"
+	pb "auth/proto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	validToken   = "valid-token"
+	authUserSpan = "auth.Auth/AuthUser"
+)
+
+// stubAuthServer accepts validToken only.
+type stubAuthServer struct {
+	pb.UnimplementedAuthServer
+	userID    uuid.UUID
+	requestID string
+	audience  string
+}
+
+func (s *stubAuthServer) AuthUser(ctx context.Context, req *pb.AuthUserRequest) (*pb.AuthUserResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-request-id")) > 0 {
+		s.requestID = md.Get("x-request-id")[0]
+	}
+	s.audience = req.Audience
+	if req.Token != validToken {
+		return nil, httperror.ToGRPC(httperror.NewWithCode(nil, httperror.CodeInvalidToken, "Invalid token", http.StatusUnauthorized))
+	}
+	return &pb.AuthUserResponse{UserId: s.userID.String()}, nil
+}
+
+type middlewareTest struct {
+	provider *sdktrace.TracerProvider
+	exporter *tracetest.InMemoryExporter
+	server   *stubAuthServer
+	router   *gin.Engine
+}
+
+func newMiddlewareTest(t *testing.T) *middlewareTest {
+	t.Helper()
+	// The tracer provider must be installed before the otelgrpc handlers are created.
+	provider, exporter := tracing.SetupInMemory("outmiddlewares-test")
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	listener := bufconn.Listen(1 << 20)
+	server := &stubAuthServer{userID: uuid.New()}
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	pb.RegisterAuthServer(grpcServer, server)
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///auth", append(outmiddlewares.DialOptions(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)...)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/orders", outmiddlewares.NewAuthMiddleware(conn, outmiddlewares.WithAudience("orders")), func(c *gin.Context) {
+		c.String(http.StatusOK, c.MustGet(gin.AuthUserKey).(uuid.UUID).String())
+	})
+	return &middlewareTest{provider: provider, exporter: exporter, server: server, router: router}
+}
+
+// get requests /orders within a span, as the tracing middleware of the
+// service would start, and returns the response and the span.
+func (mt *middlewareTest) get(token string) (*httptest.ResponseRecorder, trace.SpanContext) {
+	ctx, span := mt.provider.Tracer("test").Start(context.Background(), "GET /orders", trace.WithSpanKind(trace.SpanKindServer))
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "request-1")
+	w := httptest.NewRecorder()
+	mt.router.ServeHTTP(w, req)
+	span.End()
+	return w, span.SpanContext()
+}
+
+// authUserSpans returns the client and server spans of the AuthUser call.
+func (mt *middlewareTest) authUserSpans(t *testing.T) (client tracetest.SpanStub, server tracetest.SpanStub) {
+	t.Helper()
+	var names []string
+	for _, span := range mt.exporter.GetSpans() {
+		names = append(names, span.Name)
+		if span.Name != authUserSpan {
+			continue
+		}
+		switch span.SpanKind {
+		case trace.SpanKindClient:
+			client = span
+		case trace.SpanKindServer:
+			server = span
+		}
+	}
+	// The request span and the spans of otelgrpc, no span of the middleware itself.
+	if len(names) != 3 {
+		t.Errorf("the spans are %q, want the request span and the client and server spans of %s", names, authUserSpan)
+	}
+	if !client.SpanContext.IsValid() || !server.SpanContext.IsValid() {
+		t.Fatalf("the spans are %q, want the client and server spans of %s", names, authUserSpan)
+	}
+	return client, server
+}
+
+func TestAuthMiddlewareTracing(t *testing.T) {
+	mt := newMiddlewareTest(t)
+	w, request := mt.get(validToken)
+	if w.Code != http.StatusOK || w.Body.String() != mt.server.userID.String() {
+		t.Fatalf("the response is %d %q, want 200 with the user id %s", w.Code, w.Body.String(), mt.server.userID)
+	}
+	if mt.server.requestID != "request-1" {
+		t.Errorf("the request id passed to the auth service is %q, want request-1", mt.server.requestID)
+	}
+	if mt.server.audience != "orders" {
+		t.Errorf("the audience passed to the auth service is %q, want orders", mt.server.audience)
+	}
+
+	client, server := mt.authUserSpans(t)
+	if client.Parent.SpanID() != request.SpanID() {
+		t.Errorf("the client span is a child of %s, want the request span %s", client.Parent.SpanID(), request.SpanID())
+	}
+	// The trace context is propagated to the auth service.
+	if server.SpanContext.TraceID() != request.TraceID() || server.Parent.SpanID() != client.SpanContext.SpanID() {
+		t.Errorf("the server span is a child of %s in trace %s, want the client span %s in trace %s",
+			server.Parent.SpanID(), server.SpanContext.TraceID(), client.SpanContext.SpanID(), request.TraceID())
+	}
+}
+
+func TestAuthMiddlewareInvalidToken(t *testing.T) {
+	mt := newMiddlewareTest(t)
+	w, _ := mt.get("invalid-token")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("the response status is %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var problem httperror.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("the response is not a problem: %v", err)
+	}
+	if problem.Code != httperror.CodeInvalidToken {
+		t.Errorf("the problem code is %q, want %q", problem.Code, httperror.CodeInvalidToken)
+	}
+
+	client, _ := mt.authUserSpans(t)
+	if client.Status.Code != codes.Error {
+		t.Errorf("the client span status is %v, want an error", client.Status.Code)
+	}
+}
@@ -24,7 +24,7 @@ const (
 
 // AuthClient is the client API for Auth service.
 //
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.IsDev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type AuthClient interface {
 	AuthUser(ctx context.Context, in *AuthUserRequest, opts ...grpc.CallOption) (*AuthUserResponse, error)
 }